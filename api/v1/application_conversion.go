@@ -0,0 +1,22 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+// v1 是 Application 的存储版本，也是各版本之间转换的 Hub，其他版本实现 conversion.Convertible 与 v1 相互转换
+
+// Hub marks this type as a conversion hub.
+func (*Application) Hub() {}
@@ -0,0 +1,92 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// ChildNamespacesIndexField is the field index of Applications by their ChildNamespaces.
+// The Application controller registers it so lookups of the Applications rendering into
+// a namespace do not list every Application in the cluster.
+const ChildNamespacesIndexField = "spec.childNamespaces"
+
+// ChildNamespaces returns the namespaces the child resources of the Application are rendered into:
+// the namespaces of the environments when declared, otherwise the namespace of the Application.
+func (r *Application) ChildNamespaces() []string {
+	if len(r.Spec.Environments) == 0 {
+		return []string{r.Namespace}
+	}
+	namespaces := make([]string, 0, len(r.Spec.Environments))
+	for _, env := range r.Spec.Environments {
+		namespaces = append(namespaces, env.Namespace)
+	}
+	return namespaces
+}
+
+// PodLabels returns the labels of the pods rendered for the Application,
+// i.e. the pod template labels merged with the Application's labels.
+// Labels contributed by a referenced template are not included.
+func (r *Application) PodLabels() labels.Set {
+	return labels.Merge(r.Spec.Deployment.Template.Labels, r.Labels)
+}
+
+// SelectsPodsOf reports whether the Service or Deployment selector of the Application
+// matches the pods of other. The Service selector is taken from the Application's labels.
+func (r *Application) SelectsPodsOf(other *Application) bool {
+	podLabels := other.PodLabels()
+	if len(r.Labels) > 0 && labels.SelectorFromSet(r.Labels).Matches(podLabels) {
+		return true
+	}
+	if r.Spec.Deployment.Selector == nil {
+		return false
+	}
+	selector, err := metav1.LabelSelectorAsSelector(r.Spec.Deployment.Selector)
+	if err != nil || selector.Empty() {
+		return false
+	}
+	return selector.Matches(podLabels)
+}
+
+// SharedNamespaces returns the namespaces both Applications render child resources into.
+func (r *Application) SharedNamespaces(other *Application) []string {
+	namespaces := map[string]bool{}
+	for _, namespace := range r.ChildNamespaces() {
+		namespaces[namespace] = true
+	}
+	var shared []string
+	for _, namespace := range other.ChildNamespaces() {
+		if namespaces[namespace] {
+			shared = append(shared, namespace)
+			delete(namespaces, namespace)
+		}
+	}
+	return shared
+}
+
+// SelectorOverlaps reports whether the Applications render child resources into a shared namespace
+// and the selectors of either one match the pods of the other, so that traffic or pods leak between them.
+func (r *Application) SelectorOverlaps(other *Application) bool {
+	if r.Namespace == other.Namespace && r.Name == other.Name {
+		return false
+	}
+	if len(r.SharedNamespaces(other)) == 0 {
+		return false
+	}
+	return r.SelectsPodsOf(other) || other.SelectsPodsOf(r)
+}
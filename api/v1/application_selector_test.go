@@ -0,0 +1,117 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newSelectorApplication(name string, labels map[string]string, environments ...string) *Application {
+	app := &Application{}
+	app.Name, app.Namespace = name, "default"
+	app.Labels = labels
+	for _, namespace := range environments {
+		app.Spec.Environments = append(app.Spec.Environments, ApplicationEnvironment{Name: namespace, Namespace: namespace})
+	}
+	return app
+}
+
+func TestSelectorOverlaps(t *testing.T) {
+	tests := []struct {
+		name  string
+		app   *Application
+		other *Application
+		want  bool
+	}{
+		{
+			name:  "the same Application",
+			app:   newSelectorApplication("web", map[string]string{"app": "web"}),
+			other: newSelectorApplication("web", map[string]string{"app": "web"}),
+		},
+		{
+			name:  "identical labels",
+			app:   newSelectorApplication("web", map[string]string{"app": "web"}),
+			other: newSelectorApplication("web-canary", map[string]string{"app": "web"}),
+			want:  true,
+		},
+		{
+			name:  "distinct labels",
+			app:   newSelectorApplication("web", map[string]string{"app": "web"}),
+			other: newSelectorApplication("api", map[string]string{"app": "api"}),
+		},
+		{
+			name:  "a broader Service selector of the other Application",
+			app:   newSelectorApplication("web", map[string]string{"app": "web", "track": "stable"}),
+			other: newSelectorApplication("web-all", map[string]string{"app": "web"}),
+			want:  true,
+		},
+		{
+			name: "a Deployment selector matching the pod template labels of the other Application",
+			app: func() *Application {
+				app := newSelectorApplication("web", map[string]string{"app": "web"})
+				app.Spec.Deployment.Selector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"frontend"}},
+				}}
+				return app
+			}(),
+			other: func() *Application {
+				other := newSelectorApplication("api", map[string]string{"app": "api"})
+				other.Spec.Deployment.Template.Labels = map[string]string{"tier": "frontend"}
+				return other
+			}(),
+			want: true,
+		},
+		{
+			name: "an empty Deployment selector selects nothing",
+			app: func() *Application {
+				app := newSelectorApplication("web", nil)
+				app.Spec.Deployment.Selector = &metav1.LabelSelector{}
+				return app
+			}(),
+			other: newSelectorApplication("api", map[string]string{"app": "api"}),
+		},
+		{
+			name:  "identical labels in distinct environments",
+			app:   newSelectorApplication("web", map[string]string{"app": "web"}, "team-a-dev"),
+			other: newSelectorApplication("web-prod", map[string]string{"app": "web"}, "team-a-prod"),
+		},
+		{
+			name:  "identical labels in a shared environment",
+			app:   newSelectorApplication("web", map[string]string{"app": "web"}, "team-a-dev", "team-a-prod"),
+			other: newSelectorApplication("web-prod", map[string]string{"app": "web"}, "team-a-prod"),
+			want:  true,
+		},
+		{
+			name:  "an environment in the namespace of the other Application",
+			app:   newSelectorApplication("web", map[string]string{"app": "web"}, "default"),
+			other: newSelectorApplication("web-canary", map[string]string{"app": "web"}),
+			want:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.app.SelectorOverlaps(tt.other); got != tt.want {
+				t.Errorf("SelectorOverlaps() = %v, want %v", got, tt.want)
+			}
+			if got := tt.other.SelectorOverlaps(tt.app); got != tt.want {
+				t.Errorf("SelectorOverlaps() of the other Application = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
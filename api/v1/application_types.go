@@ -17,6 +17,8 @@ limitations under the License.
 package v1
 
 import (
+	"encoding/json"
+
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -35,6 +37,102 @@ type ApplicationSpec struct {
 
 	Deployment DeploymentTemplate `json:"deployment,omitempty"`
 	Service    ServiceTemplate    `json:"service,omitempty"`
+
+	// Paused stops the controller from mutating the child resources of this
+	// Application while status keeps being observed. The same effect can be
+	// achieved with the apps.clusterops.io/paused=true annotation.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// DependsOn lists the Applications that must report Ready before the
+	// child resources of this Application are rolled out.
+	// +optional
+	DependsOn []ApplicationReference `json:"dependsOn,omitempty"`
+
+	// TemplateRef refers to an ApplicationTemplate or ClusterApplicationTemplate
+	// whose spec is merged underneath this Application's spec.
+	// +optional
+	TemplateRef *TemplateReference `json:"templateRef,omitempty"`
+
+	// Environments renders this Application into one namespace per environment,
+	// each with its own overlay on top of the base spec. When set, the child
+	// resources are rendered into the environment namespaces instead of the
+	// namespace of the Application itself.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Environments []ApplicationEnvironment `json:"environments,omitempty"`
+
+	// Security selects the Pod Security Standard the pod template is defaulted to
+	// and validated against.
+	// +optional
+	Security *SecuritySpec `json:"security,omitempty"`
+}
+
+// SecurityProfile is a Pod Security Standard level, or custom.
+// +kubebuilder:validation:Enum=restricted;baseline;custom
+type SecurityProfile string
+
+const (
+	// SecurityProfileRestricted 注入并强制 restricted 级别的 Pod 安全标准
+	SecurityProfileRestricted SecurityProfile = "restricted"
+	// SecurityProfileBaseline 注入 seccompProfile 并强制 baseline 级别的 Pod 安全标准
+	SecurityProfileBaseline SecurityProfile = "baseline"
+	// SecurityProfileCustom 不注入也不校验，securityContext 完全由用户声明
+	SecurityProfileCustom SecurityProfile = "custom"
+)
+
+// SecuritySpec declares the security profile of an Application.
+type SecuritySpec struct {
+	// Profile is one of restricted, baseline or custom. restricted and baseline
+	// fill unset securityContext fields with the defaults of the level and reject
+	// pod templates that violate it, custom leaves the pod template as declared.
+	// The profile also applies to containers added through spec.templateRef, those are
+	// checked when the template is merged and violations fail the reconciliation.
+	// +kubebuilder:default=restricted
+	// +optional
+	Profile SecurityProfile `json:"profile,omitempty"`
+}
+
+// ApplicationEnvironment declares the per-environment overlay applied on top of the base spec.
+type ApplicationEnvironment struct {
+	// Name of the environment, e.g. dev, staging or prod.
+	Name string `json:"name"`
+	// Namespace the child resources of this environment are rendered into.
+	Namespace string `json:"namespace"`
+	// Replicas overrides spec.deployment.replicas.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// ImageTag overrides the tag of every container image declared by the Application.
+	// +optional
+	ImageTag string `json:"imageTag,omitempty"`
+	// Resources are merged into the resources of every container declared by the Application.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Env is merged by name into the environment variables of every container declared by the Application.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// TemplateReference refers to an ApplicationTemplate in the namespace of the
+// Application or to a ClusterApplicationTemplate.
+type TemplateReference struct {
+	// Kind of the referenced template.
+	// +kubebuilder:validation:Enum=ApplicationTemplate;ClusterApplicationTemplate
+	// +kubebuilder:default=ApplicationTemplate
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	// Name of the referenced template.
+	Name string `json:"name"`
+}
+
+// ApplicationReference refers to another Application.
+type ApplicationReference struct {
+	// Name of the referenced Application.
+	Name string `json:"name"`
+	// Namespace of the referenced Application, defaults to the namespace of the referring Application.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
 }
 
 type DeploymentTemplate struct {
@@ -52,6 +150,152 @@ type ApplicationStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 	Workflow appsv1.DeploymentStatus `json:"workflow"`
 	Network  corev1.ServiceStatus    `json:"network"`
+
+	// Conditions represent the latest available observations of the Application's state.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Environments reports the observed state of each environment declared in spec.environments.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Environments []EnvironmentStatus `json:"environments,omitempty"`
+
+	// Images reports the image each container was declared with and the digest it has been pinned to.
+	// +optional
+	// +listType=map
+	// +listMapKey=container
+	Images []ImageStatus `json:"images,omitempty"`
+}
+
+// ImageStatus is the image of one container of an Application.
+type ImageStatus struct {
+	// Container is the name of the container.
+	Container string `json:"container"`
+	// Image is the image reference as declared, e.g. nginx:1.25.
+	Image string `json:"image"`
+	// Pinned is the digest-pinned reference the Deployment runs, empty when the image is not pinned.
+	// +optional
+	Pinned string `json:"pinned,omitempty"`
+}
+
+// EnvironmentStatus is the observed state of one environment of an Application.
+type EnvironmentStatus struct {
+	// Name of the environment.
+	Name string `json:"name"`
+	// Namespace the environment is rendered into.
+	Namespace string `json:"namespace"`
+	// Replicas is the number of desired replicas in this environment.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// UpdatedReplicas is the number of replicas running the latest pod template.
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+	// AvailableReplicas is the number of available replicas.
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+	// Ready is true when all desired replicas of this environment are available.
+	// +optional
+	Ready bool `json:"ready,omitempty"`
+}
+
+const (
+	// PausedAnnotation 设置为 "true" 时与 spec.paused 等效，便于在事故期间通过 kubectl annotate 快速止血
+	PausedAnnotation = "apps.clusterops.io/paused"
+	// SpecHashAnnotation 记录子资源对应的期望状态摘要，摘要变化时控制器才会更新子资源
+	SpecHashAnnotation = "apps.clusterops.io/spec-hash"
+	// ManagedMetadataAnnotation 以 JSON 记录控制器写入子资源的标签和注解的 key
+	// 期望状态中移除的 key 据此从子资源上删除，不影响其他组件写入的标签和注解
+	ManagedMetadataAnnotation = "apps.clusterops.io/managed-metadata"
+	// AllowImmutableChangesAnnotation 设置为 "true" 时允许修改 Deployment selector 和 Service clusterIP 等实际不可变的字段
+	// 控制器会删除受影响的子资源，观察到删除后立即按新的期望状态重建，注解只对一次变更生效，重建完成后由控制器移除
+	// 重建期间服务会中断：Service 删除到重建之间的请求无法路由；旧 Pod 随 Deployment 在后台回收，新 Pod 就绪前可能没有可用副本
+	AllowImmutableChangesAnnotation = "apps.clusterops.io/allow-immutable-changes"
+	// ProtectedAnnotation 设置为 "true" 时，Application 只有在确认删除后才能被删除
+	// 只支持注解：metadata.labels 会成为 Service 的 selector 和 Pod 的标签，开关保护不应触发滚动更新
+	ProtectedAnnotation = "apps.clusterops.io/protected"
+	// ConfirmDeleteAnnotation 设置为 Application 的名称时确认删除受保护的 Application
+	ConfirmDeleteAnnotation = "apps.clusterops.io/confirm-delete"
+	// ShardAnnotation 的值代替 namespace/name 作为分片的哈希 key，值相同的 Application 由同一个副本调谐
+	// 与删除保护相同，只支持注解，修改分片不应改变 Service 的 selector 和 Pod 的标签
+	ShardAnnotation = "apps.clusterops.io/shard"
+	// OriginalImagesAnnotation 以 JSON 记录 webhook 固定到 digest 之前各容器声明的镜像，key 为容器名称
+	OriginalImagesAnnotation = "apps.clusterops.io/original-images"
+)
+
+const (
+	// OwnerNameLabel 和 OwnerNamespaceLabel 标识子资源所属的 Application
+	// 环境渲染出的子资源可能位于其他命名空间，无法使用 OwnerReference，只能依赖这两个标签反查
+	OwnerNameLabel      = "apps.clusterops.io/owner-name"
+	OwnerNamespaceLabel = "apps.clusterops.io/owner-namespace"
+	// EnvironmentLabel 标识子资源由哪个环境渲染而来
+	EnvironmentLabel = "apps.clusterops.io/environment"
+)
+
+const (
+	// EnvironmentsFinalizer 用于在 Application 删除时清理位于其他命名空间中的环境子资源
+	EnvironmentsFinalizer = "apps.clusterops.io/environments"
+)
+
+const (
+	// ConditionTypePaused 表示 Application 当前是否处于暂停调谐的状态
+	ConditionTypePaused = "Paused"
+	// ConditionTypeReady 表示 Application 的子资源是否已就绪，被其他 Application 的 dependsOn 所依赖
+	ConditionTypeReady = "Ready"
+	// ConditionTypeWaitingForDependencies 表示 Application 是否仍在等待 dependsOn 中的依赖就绪
+	ConditionTypeWaitingForDependencies = "WaitingForDependencies"
+	// ConditionTypeTemplateResolved 表示 spec.templateRef 引用的模板是否已成功解析并合并
+	ConditionTypeTemplateResolved = "TemplateResolved"
+	// ConditionTypeSelectorConflict 表示 Application 的 selector 是否与同一命名空间中其他 Application 的 Pod 重叠
+	ConditionTypeSelectorConflict = "SelectorConflict"
+	// ConditionTypeReconcileError 表示上一次调谐是否因需要用户处理的问题失败，例如目标位置已存在不属于该 Application 的子资源
+	ConditionTypeReconcileError = "ReconcileError"
+)
+
+// IsPaused reports whether reconciliation of the child resources is paused,
+// either through spec.paused or the paused annotation.
+func (r *Application) IsPaused() bool {
+	return r.Spec.Paused || r.Annotations[PausedAnnotation] == "true"
+}
+
+// IsProtected reports whether the Application is marked as protected from deletion
+// through the protected annotation.
+func (r *Application) IsProtected() bool {
+	return r.Annotations[ProtectedAnnotation] == "true"
+}
+
+// DeletionConfirmed reports whether the deletion of the Application has been confirmed.
+func (r *Application) DeletionConfirmed() bool {
+	return r.Annotations[ConfirmDeleteAnnotation] == r.Name
+}
+
+// OriginalImages returns the images recorded before they were pinned to digests,
+// keyed by container name. A malformed annotation is treated as empty.
+func (r *Application) OriginalImages() map[string]string {
+	images := map[string]string{}
+	if data, ok := r.Annotations[OriginalImagesAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &images); err != nil {
+			return map[string]string{}
+		}
+	}
+	return images
+}
+
+// ShardKey returns the key hashed to assign the Application to an operator replica,
+// the shard annotation when set and namespace/name otherwise.
+func (r *Application) ShardKey() string {
+	if key := r.Annotations[ShardAnnotation]; key != "" {
+		return key
+	}
+	return r.Namespace + "/" + r.Name
+}
+
+// AllowsImmutableChanges reports whether immutable fields may be changed
+// by recreating the affected child resources.
+func (r *Application) AllowsImmutableChanges() bool {
+	return r.Annotations[AllowImmutableChangesAnnotation] == "true"
 }
 
 // 这个标记主要是被 controller-tools 识别，然后 controller-tools 的对象生成器就知道这个标记下面的对象代表一个 Kind，接着对象生成器会生成相应的 Kind 需要的代码，也就是实现 runtime.Object 接口
@@ -60,6 +304,10 @@ type ApplicationStatus struct {
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:resource:path=applications,singular=application,scope=Namespaced,shortName=app
+//+kubebuilder:storageversion
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Paused",type="string",JSONPath=".status.conditions[?(@.type==\"Paused\")].status"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
 
 // Application is the Schema for the applications API
 type Application struct {
@@ -0,0 +1,136 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApplicationPolicy 约束所在命名空间内的 Application，ClusterApplicationPolicy 约束所有命名空间
+// 准入 Webhook 在创建和更新 Application 时逐条评估所有生效的策略，每条策略按自身的 enforcementMode 处理违规:
+//   - Enforce: 拒绝请求
+//   - Warn: 放行请求并向客户端返回警告
+//   - Audit: 放行请求，仅记录日志
+
+// PolicyEnforcementMode decides how violations of a policy are handled.
+// +kubebuilder:validation:Enum=Enforce;Warn;Audit
+type PolicyEnforcementMode string
+
+const (
+	// EnforcementModeEnforce rejects Applications violating the policy.
+	EnforcementModeEnforce PolicyEnforcementMode = "Enforce"
+	// EnforcementModeWarn admits Applications violating the policy and returns warnings.
+	EnforcementModeWarn PolicyEnforcementMode = "Warn"
+	// EnforcementModeAudit admits Applications violating the policy and only logs the violations.
+	EnforcementModeAudit PolicyEnforcementMode = "Audit"
+)
+
+// ApplicationPolicySpec defines the constraints applied to Applications.
+type ApplicationPolicySpec struct {
+	// MaxReplicas is the maximum number of replicas of the Application and each of its environments.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MaxReplicas *int32 `json:"maxReplicas,omitempty"`
+
+	// AllowedRegistries lists the registries, optionally followed by a repository path prefix,
+	// that container images may be pulled from, e.g. "registry.example.com" or "docker.io/library".
+	// Images without a registry are treated as coming from docker.io. Empty allows any registry.
+	// +optional
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+
+	// RequiredLabels lists the label keys every Application must carry.
+	// +optional
+	RequiredLabels []string `json:"requiredLabels,omitempty"`
+
+	// RequiredResourceLimits lists the resources every container must declare a limit for, e.g. cpu and memory.
+	// +optional
+	RequiredResourceLimits []corev1.ResourceName `json:"requiredResourceLimits,omitempty"`
+
+	// ForbiddenServiceTypes lists the Service types Applications may not use, e.g. NodePort and LoadBalancer.
+	// +optional
+	ForbiddenServiceTypes []corev1.ServiceType `json:"forbiddenServiceTypes,omitempty"`
+
+	// DeletionProtection rejects the deletion of Applications unless the deletion is confirmed first.
+	// +optional
+	DeletionProtection *DeletionProtection `json:"deletionProtection,omitempty"`
+
+	// EnforcementMode decides how violations are handled.
+	// +kubebuilder:default=Enforce
+	// +optional
+	EnforcementMode PolicyEnforcementMode `json:"enforcementMode,omitempty"`
+}
+
+// DeletionProtection decides which Applications are protected from deletion.
+// A protected Application can only be deleted after the confirm-delete annotation is set to its name.
+type DeletionProtection struct {
+	// Always protects every Application the policy applies to.
+	// +optional
+	Always bool `json:"always,omitempty"`
+	// WhileReceivingTraffic protects Applications whose Services still have ready endpoints.
+	// +optional
+	WhileReceivingTraffic bool `json:"whileReceivingTraffic,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=applicationpolicies,singular=applicationpolicy,scope=Namespaced,shortName=apppol
+//+kubebuilder:printcolumn:name="Mode",type="string",JSONPath=".spec.enforcementMode"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ApplicationPolicy is the Schema for the applicationpolicies API
+type ApplicationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ApplicationPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ApplicationPolicyList contains a list of ApplicationPolicy
+type ApplicationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ApplicationPolicy `json:"items"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=clusterapplicationpolicies,singular=clusterapplicationpolicy,scope=Cluster,shortName=capppol
+//+kubebuilder:printcolumn:name="Mode",type="string",JSONPath=".spec.enforcementMode"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ClusterApplicationPolicy is the Schema for the clusterapplicationpolicies API
+type ClusterApplicationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ApplicationPolicySpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterApplicationPolicyList contains a list of ClusterApplicationPolicy
+type ClusterApplicationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterApplicationPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ApplicationPolicy{}, &ApplicationPolicyList{})
+	SchemeBuilder.Register(&ClusterApplicationPolicy{}, &ClusterApplicationPolicyList{})
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApplicationSet 根据生成器产生的参数批量渲染 Application
+// 模板中的 {{key}} 占位符会被替换为参数值，例如 list 生成器元素中的字段，或 namespaces 生成器提供的 {{namespace}}
+
+const (
+	// ApplicationSetLabel 标识 Application 由哪个 ApplicationSet 生成
+	ApplicationSetLabel = "apps.clusterops.io/application-set"
+	// ConditionTypePruneBlocked 表示不再生成的 Application 是否因删除保护等原因被拒绝删除
+	ConditionTypePruneBlocked = "PruneBlocked"
+)
+
+// ApplicationSetPrunePolicy decides what happens to generated Applications
+// that are no longer produced by the generators.
+// +kubebuilder:validation:Enum=Delete;Orphan
+type ApplicationSetPrunePolicy string
+
+const (
+	// PrunePolicyDelete deletes Applications that are no longer generated.
+	PrunePolicyDelete ApplicationSetPrunePolicy = "Delete"
+	// PrunePolicyOrphan releases Applications that are no longer generated and keeps them running.
+	PrunePolicyOrphan ApplicationSetPrunePolicy = "Orphan"
+)
+
+// ApplicationSetSpec defines the desired state of ApplicationSet
+type ApplicationSetSpec struct {
+	// Generators produce the parameter sets, one Application is rendered per parameter set.
+	// +kubebuilder:validation:MinItems=1
+	Generators []ApplicationSetGenerator `json:"generators"`
+
+	// Template is rendered once per parameter set.
+	Template ApplicationSetTemplate `json:"template"`
+
+	// PrunePolicy decides what happens to Applications that are no longer generated.
+	// +kubebuilder:default=Delete
+	// +optional
+	PrunePolicy ApplicationSetPrunePolicy `json:"prunePolicy,omitempty"`
+}
+
+// ApplicationSetGenerator produces parameter sets. Exactly one generator type should be set.
+type ApplicationSetGenerator struct {
+	// List generates one parameter set per element.
+	// +optional
+	List *ListGenerator `json:"list,omitempty"`
+	// Namespaces generates one parameter set per namespace matching the selector.
+	// +optional
+	Namespaces *NamespacesGenerator `json:"namespaces,omitempty"`
+	// Matrix generates the cartesian product of a list and the matching namespaces.
+	// +optional
+	Matrix *MatrixGenerator `json:"matrix,omitempty"`
+}
+
+// ListGenerator generates one parameter set per element.
+type ListGenerator struct {
+	// Elements are the parameter sets, e.g. {"name": "payments", "tag": "v1.2"}.
+	Elements []map[string]string `json:"elements"`
+}
+
+// NamespacesGenerator generates the parameters "namespace" and "namespace.labels.<key>"
+// for every namespace matching the selector.
+type NamespacesGenerator struct {
+	// Selector selects the namespaces, an empty selector matches all namespaces.
+	// +optional
+	Selector metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// MatrixGenerator combines every list element with every matching namespace.
+type MatrixGenerator struct {
+	List       ListGenerator       `json:"list"`
+	Namespaces NamespacesGenerator `json:"namespaces"`
+}
+
+// ApplicationSetTemplate is the template of the generated Applications.
+type ApplicationSetTemplate struct {
+	Metadata ApplicationSetTemplateMetadata `json:"metadata"`
+	Spec     ApplicationSpec                `json:"spec,omitempty"`
+}
+
+// ApplicationSetTemplateMetadata is the metadata of the generated Applications.
+type ApplicationSetTemplateMetadata struct {
+	// Name of the generated Application, usually containing placeholders such as {{name}}.
+	Name string `json:"name"`
+	// Namespace of the generated Application, usually containing placeholders such as {{namespace}}.
+	Namespace string `json:"namespace"`
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// ApplicationSetStatus defines the observed state of ApplicationSet
+type ApplicationSetStatus struct {
+	// Applications is the number of Applications currently generated.
+	// +optional
+	Applications int32 `json:"applications,omitempty"`
+	// ReadyApplications is the number of generated Applications reporting Ready.
+	// +optional
+	ReadyApplications int32 `json:"readyApplications,omitempty"`
+	// Resources lists the generated Applications and their readiness.
+	// +optional
+	Resources []ApplicationSetResource `json:"resources,omitempty"`
+	// Conditions represent the latest available observations of the ApplicationSet's state.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+}
+
+// ApplicationSetResource is a generated Application.
+type ApplicationSetResource struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Ready     bool   `json:"ready"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:path=applicationsets,singular=applicationset,scope=Cluster,shortName=appset
+//+kubebuilder:printcolumn:name="Applications",type="integer",JSONPath=".status.applications"
+//+kubebuilder:printcolumn:name="Ready",type="integer",JSONPath=".status.readyApplications"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// ApplicationSet is the Schema for the applicationsets API
+type ApplicationSet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ApplicationSetSpec   `json:"spec,omitempty"`
+	Status ApplicationSetStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ApplicationSetList contains a list of ApplicationSet
+type ApplicationSetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ApplicationSet `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ApplicationSet{}, &ApplicationSetList{})
+}
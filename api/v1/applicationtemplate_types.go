@@ -0,0 +1,107 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ApplicationTemplate 用于沉淀多个 Application 共用的探针、资源、安全上下文和 Sidecar 等配置
+// Application 通过 spec.templateRef 引用模板，控制器在调谐时以模板为底、Application 为补丁进行 strategic merge:
+//   - 标量字段以 Application 为准，Application 未设置时使用模板中的值
+//   - containers 等列表按 patchMergeKey(例如容器的 name)合并，模板中独有的容器(例如 Sidecar)会追加到 Application 中
+//   - 名为 "*" 的模板容器不会被追加，而是作为默认值合并到 Application 的每一个容器中
+
+const (
+	// TemplateWildcardContainerName 是模板中作用于所有容器的默认容器名
+	TemplateWildcardContainerName = "*"
+)
+
+// ApplicationTemplateSpec defines the shared defaults merged underneath the spec of referencing Applications.
+type ApplicationTemplateSpec struct {
+	// Metadata holds labels and annotations added to the child resources of referencing Applications.
+	// +optional
+	Metadata TemplateMetadata `json:"metadata,omitempty"`
+
+	// Deployment holds a partial DeploymentSpec merged underneath the Application's deployment.
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Deployment DeploymentTemplate `json:"deployment,omitempty"`
+
+	// Service holds a partial ServiceSpec merged underneath the Application's service.
+	// +optional
+	// +kubebuilder:validation:Schemaless
+	// +kubebuilder:validation:Type=object
+	// +kubebuilder:pruning:PreserveUnknownFields
+	Service ServiceTemplate `json:"service,omitempty"`
+}
+
+// TemplateMetadata holds the metadata shared through a template.
+type TemplateMetadata struct {
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=applicationtemplates,singular=applicationtemplate,scope=Namespaced,shortName=apptpl
+
+// ApplicationTemplate is the Schema for the applicationtemplates API
+type ApplicationTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ApplicationTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ApplicationTemplateList contains a list of ApplicationTemplate
+type ApplicationTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ApplicationTemplate `json:"items"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:path=clusterapplicationtemplates,singular=clusterapplicationtemplate,scope=Cluster,shortName=capptpl
+
+// ClusterApplicationTemplate is the cluster-scoped variant of ApplicationTemplate,
+// which can be referenced by Applications in any namespace.
+type ClusterApplicationTemplate struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ApplicationTemplateSpec `json:"spec,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterApplicationTemplateList contains a list of ClusterApplicationTemplate
+type ClusterApplicationTemplateList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterApplicationTemplate `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ApplicationTemplate{}, &ApplicationTemplateList{})
+	SchemeBuilder.Register(&ClusterApplicationTemplate{}, &ClusterApplicationTemplateList{})
+}
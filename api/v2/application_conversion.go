@@ -0,0 +1,408 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// v2 与 v1(Hub)之间的转换规则:
+//   - v2 的容器字段对应 v1 的第一个容器，v2 转换为 v1 时容器名为 MainContainerName
+//   - 带有 servicePort 的端口对应 v1 中同名且 targetPort 为 containerPort 的 Service 端口
+//   - v1 的 spec 转换为 v2 有损(例如存在探针、Sidecar 或 selector)时，v1 的 spec 以 JSON 保存在 LossyDataAnnotation 注解中；
+//     转换回 v1 时以注解为底，只覆盖在 v2 中被修改过的字段，注解本身不会写入存储
+//   - status 由控制器通过 v1 维护，v2 只展示其中的副本数、Condition 和环境状态，不保存在注解中
+
+const (
+	// LossyDataAnnotation 保存 v2 无法表达的 v1 数据
+	LossyDataAnnotation = "apps.clusterops.io/v1-conversion-data"
+
+	// MainContainerName 是 v2 转换为 v1 时应用容器的名称
+	MainContainerName = "app"
+)
+
+// lossyData 是保存在 LossyDataAnnotation 注解中的 v1 数据
+type lossyData struct {
+	Spec v1.ApplicationSpec `json:"spec"`
+}
+
+var _ conversion.Convertible = &Application{}
+
+// ConvertTo converts this Application to the Hub version (v1).
+func (src *Application) ConvertTo(dstRaw conversion.Hub) error {
+	dst, ok := dstRaw.(*v1.Application)
+	if !ok {
+		return fmt.Errorf("expected a v1 Application but got %T", dstRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	restored, err := popLossyData(&dst.ObjectMeta)
+	if err != nil {
+		return err
+	}
+	dst.Status = statusToV1(&src.Status)
+	if restored == nil {
+		dst.Spec = specToV1(&src.Spec)
+		return nil
+	}
+	dst.Spec = mergeSpec(&restored.Spec, &src.Spec)
+	return nil
+}
+
+// ConvertFrom converts from the Hub version (v1) to this version.
+func (dst *Application) ConvertFrom(srcRaw conversion.Hub) error {
+	src, ok := srcRaw.(*v1.Application)
+	if !ok {
+		return fmt.Errorf("expected a v1 Application but got %T", srcRaw)
+	}
+
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+	dst.Spec = specFromV1(&src.Spec)
+	dst.Status = statusFromV1(&src.Status)
+
+	// 只有 spec 中存在 v2 无法表达的字段时才保存注解
+	if apiequality.Semantic.DeepEqual(specToV1(&dst.Spec), src.Spec) {
+		return nil
+	}
+
+	data, err := json.Marshal(lossyData{Spec: src.Spec})
+	if err != nil {
+		return fmt.Errorf("marshal v1 conversion data: %w", err)
+	}
+	if dst.Annotations == nil {
+		dst.Annotations = map[string]string{}
+	}
+	dst.Annotations[LossyDataAnnotation] = string(data)
+	return nil
+}
+
+// popLossyData 读取并移除 LossyDataAnnotation 注解，注解不存在时返回 nil
+func popLossyData(meta *metav1.ObjectMeta) (*lossyData, error) {
+	data, ok := meta.Annotations[LossyDataAnnotation]
+	if !ok {
+		return nil, nil
+	}
+	delete(meta.Annotations, LossyDataAnnotation)
+	if len(meta.Annotations) == 0 {
+		meta.Annotations = nil
+	}
+
+	restored := &lossyData{}
+	if err := json.Unmarshal([]byte(data), restored); err != nil {
+		return nil, fmt.Errorf("unmarshal v1 conversion data: %w", err)
+	}
+	return restored, nil
+}
+
+// mergeSpec 以注解中恢复的 v1 spec 为底，覆盖在 v2 中被修改过的字段
+func mergeSpec(restored *v1.ApplicationSpec, spec *ApplicationSpec) v1.ApplicationSpec {
+	eq := apiequality.Semantic.DeepEqual
+	previous := specFromV1(restored)
+	canonical := specToV1(spec)
+	merged := *restored.DeepCopy()
+
+	if !eq(previous.Replicas, spec.Replicas) {
+		merged.Deployment.Replicas = canonical.Deployment.Replicas
+	}
+
+	containerChanged := !eq(previous.Image, spec.Image) || !eq(previous.Command, spec.Command) ||
+		!eq(previous.Args, spec.Args) || !eq(previous.Env, spec.Env) ||
+		!eq(previous.Resources, spec.Resources) || !eq(previous.Ports, spec.Ports)
+	containers := merged.Deployment.Template.Spec.Containers
+	if containerChanged && len(containers) == 0 {
+		containers = []corev1.Container{{Name: MainContainerName}}
+		merged.Deployment.Template.Spec.Containers = containers
+	}
+	if containerChanged {
+		main, canonicalMain := &containers[0], &canonical.Deployment.Template.Spec.Containers[0]
+		if !eq(previous.Image, spec.Image) {
+			main.Image = canonicalMain.Image
+		}
+		if !eq(previous.Command, spec.Command) {
+			main.Command = canonicalMain.Command
+		}
+		if !eq(previous.Args, spec.Args) {
+			main.Args = canonicalMain.Args
+		}
+		if !eq(previous.Env, spec.Env) {
+			main.Env = canonicalMain.Env
+		}
+		if !eq(previous.Resources, spec.Resources) {
+			main.Resources.Requests = canonicalMain.Resources.Requests
+			main.Resources.Limits = canonicalMain.Resources.Limits
+		}
+		if !eq(previous.Ports, spec.Ports) {
+			main.Ports = canonicalMain.Ports
+			merged.Service.Ports = canonical.Service.Ports
+		}
+	}
+
+	if !eq(previous.Expose, spec.Expose) {
+		merged.Service.Type = canonical.Service.Type
+	}
+	if previous.Paused != spec.Paused {
+		merged.Paused = canonical.Paused
+	}
+	if !eq(previous.DependsOn, spec.DependsOn) {
+		merged.DependsOn = canonical.DependsOn
+	}
+	if !eq(previous.TemplateRef, spec.TemplateRef) {
+		merged.TemplateRef = canonical.TemplateRef
+	}
+	if !eq(previous.Environments, spec.Environments) {
+		merged.Environments = canonical.Environments
+	}
+	return merged
+}
+
+// specToV1 将 v2 spec 转换为 v1 中对应的单容器 spec
+func specToV1(in *ApplicationSpec) v1.ApplicationSpec {
+	container := corev1.Container{
+		Name:    MainContainerName,
+		Image:   in.Image,
+		Command: copyStrings(in.Command),
+		Args:    copyStrings(in.Args),
+		Env:     envToV1(in.Env),
+		Resources: corev1.ResourceRequirements{
+			Requests: in.Resources.Requests.DeepCopy(),
+			Limits:   in.Resources.Limits.DeepCopy(),
+		},
+	}
+
+	var servicePorts []corev1.ServicePort
+	for _, port := range in.Ports {
+		container.Ports = append(container.Ports, corev1.ContainerPort{
+			Name:          port.Name,
+			ContainerPort: port.ContainerPort,
+			Protocol:      port.Protocol,
+		})
+		if port.ServicePort == 0 {
+			continue
+		}
+		servicePorts = append(servicePorts, corev1.ServicePort{
+			Name:       port.Name,
+			Protocol:   port.Protocol,
+			Port:       port.ServicePort,
+			TargetPort: intstr.FromInt(int(port.ContainerPort)),
+		})
+	}
+
+	out := v1.ApplicationSpec{
+		Paused:      in.Paused,
+		DependsOn:   referencesToV1(in.DependsOn),
+		TemplateRef: templateRefToV1(in.TemplateRef),
+	}
+	out.Deployment.Replicas = copyInt32(in.Replicas)
+	out.Deployment.Template.Spec.Containers = []corev1.Container{container}
+	out.Service.Type = in.Expose.Type
+	out.Service.Ports = servicePorts
+	for _, env := range in.Environments {
+		outEnv := v1.ApplicationEnvironment{
+			Name:      env.Name,
+			Namespace: env.Namespace,
+			Replicas:  copyInt32(env.Replicas),
+			ImageTag:  env.ImageTag,
+			Env:       envToV1(env.Env),
+		}
+		if env.Resources != nil {
+			outEnv.Resources = &corev1.ResourceRequirements{
+				Requests: env.Resources.Requests.DeepCopy(),
+				Limits:   env.Resources.Limits.DeepCopy(),
+			}
+		}
+		out.Environments = append(out.Environments, outEnv)
+	}
+	return out
+}
+
+// specFromV1 将 v1 spec 转换为 v2 spec，第一个容器之外的容器以及 v2 不支持的字段会被丢弃
+func specFromV1(in *v1.ApplicationSpec) ApplicationSpec {
+	out := ApplicationSpec{
+		Replicas:    copyInt32(in.Deployment.Replicas),
+		Expose:      Expose{Type: in.Service.Type},
+		Paused:      in.Paused,
+		DependsOn:   referencesFromV1(in.DependsOn),
+		TemplateRef: templateRefFromV1(in.TemplateRef),
+	}
+
+	if containers := in.Deployment.Template.Spec.Containers; len(containers) > 0 {
+		main := &containers[0]
+		out.Image = main.Image
+		out.Command = copyStrings(main.Command)
+		out.Args = copyStrings(main.Args)
+		out.Env = envFromV1(main.Env)
+		out.Resources = Resources{
+			Requests: main.Resources.Requests.DeepCopy(),
+			Limits:   main.Resources.Limits.DeepCopy(),
+		}
+		out.Ports = portsFromV1(main.Ports, in.Service.Ports)
+	}
+
+	for _, env := range in.Environments {
+		outEnv := ApplicationEnvironment{
+			Name:      env.Name,
+			Namespace: env.Namespace,
+			Replicas:  copyInt32(env.Replicas),
+			ImageTag:  env.ImageTag,
+			Env:       envFromV1(env.Env),
+		}
+		if env.Resources != nil {
+			outEnv.Resources = &Resources{
+				Requests: env.Resources.Requests.DeepCopy(),
+				Limits:   env.Resources.Limits.DeepCopy(),
+			}
+		}
+		out.Environments = append(out.Environments, outEnv)
+	}
+	return out
+}
+
+// portsFromV1 为每个容器端口匹配同名且 targetPort 指向该端口的 Service 端口，每个 Service 端口只匹配一次
+func portsFromV1(containerPorts []corev1.ContainerPort, servicePorts []corev1.ServicePort) []ApplicationPort {
+	var ports []ApplicationPort
+	used := make([]bool, len(servicePorts))
+	for _, containerPort := range containerPorts {
+		port := ApplicationPort{
+			Name:          containerPort.Name,
+			ContainerPort: containerPort.ContainerPort,
+			Protocol:      containerPort.Protocol,
+		}
+		targetPort := intstr.FromInt(int(containerPort.ContainerPort))
+		for i, servicePort := range servicePorts {
+			if used[i] || servicePort.Name != containerPort.Name || servicePort.TargetPort != targetPort {
+				continue
+			}
+			port.ServicePort = servicePort.Port
+			used[i] = true
+			break
+		}
+		ports = append(ports, port)
+	}
+	return ports
+}
+
+func statusToV1(in *ApplicationStatus) v1.ApplicationStatus {
+	out := v1.ApplicationStatus{
+		Conditions: copyConditions(in.Conditions),
+	}
+	out.Workflow.Replicas = in.Replicas
+	out.Workflow.ReadyReplicas = in.ReadyReplicas
+	out.Workflow.UpdatedReplicas = in.UpdatedReplicas
+	out.Workflow.AvailableReplicas = in.AvailableReplicas
+	for _, env := range in.Environments {
+		out.Environments = append(out.Environments, v1.EnvironmentStatus(env))
+	}
+	return out
+}
+
+func statusFromV1(in *v1.ApplicationStatus) ApplicationStatus {
+	out := ApplicationStatus{
+		Replicas:          in.Workflow.Replicas,
+		ReadyReplicas:     in.Workflow.ReadyReplicas,
+		UpdatedReplicas:   in.Workflow.UpdatedReplicas,
+		AvailableReplicas: in.Workflow.AvailableReplicas,
+		Conditions:        copyConditions(in.Conditions),
+	}
+	for _, env := range in.Environments {
+		out.Environments = append(out.Environments, EnvironmentStatus(env))
+	}
+	return out
+}
+
+func envToV1(in []EnvVar) []corev1.EnvVar {
+	var out []corev1.EnvVar
+	for _, env := range in {
+		out = append(out, corev1.EnvVar{Name: env.Name, Value: env.Value})
+	}
+	return out
+}
+
+// envFromV1 只保留 name 和 value，valueFrom 在 v2 中无法表达
+func envFromV1(in []corev1.EnvVar) []EnvVar {
+	var out []EnvVar
+	for _, env := range in {
+		out = append(out, EnvVar{Name: env.Name, Value: env.Value})
+	}
+	return out
+}
+
+func referencesToV1(in []ApplicationReference) []v1.ApplicationReference {
+	var out []v1.ApplicationReference
+	for _, ref := range in {
+		out = append(out, v1.ApplicationReference(ref))
+	}
+	return out
+}
+
+func referencesFromV1(in []v1.ApplicationReference) []ApplicationReference {
+	var out []ApplicationReference
+	for _, ref := range in {
+		out = append(out, ApplicationReference(ref))
+	}
+	return out
+}
+
+func templateRefToV1(in *TemplateReference) *v1.TemplateReference {
+	if in == nil {
+		return nil
+	}
+	out := v1.TemplateReference(*in)
+	return &out
+}
+
+func templateRefFromV1(in *v1.TemplateReference) *TemplateReference {
+	if in == nil {
+		return nil
+	}
+	out := TemplateReference(*in)
+	return &out
+}
+
+func copyConditions(in []metav1.Condition) []metav1.Condition {
+	if in == nil {
+		return nil
+	}
+	out := make([]metav1.Condition, len(in))
+	for i := range in {
+		in[i].DeepCopyInto(&out[i])
+	}
+	return out
+}
+
+func copyStrings(in []string) []string {
+	if in == nil {
+		return nil
+	}
+	return append([]string{}, in...)
+}
+
+func copyInt32(in *int32) *int32 {
+	if in == nil {
+		return nil
+	}
+	out := *in
+	return &out
+}
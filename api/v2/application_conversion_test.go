@@ -0,0 +1,184 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+const fuzzIterations = 1000
+
+func newFuzzer(seed int64) *fuzz.Fuzzer {
+	codecs := runtimeserializer.CodecFactory{}
+	funcs := append(metafuzzer.Funcs(codecs),
+		// 端口以 name 作为列表的 key，v2 中的端口名必须唯一且非空
+		func(spec *ApplicationSpec, c fuzz.Continue) {
+			c.FuzzNoCustom(spec)
+			for i := range spec.Ports {
+				spec.Ports[i].Name = fmt.Sprintf("port-%d", i)
+			}
+		},
+	)
+	return fuzz.New().
+		NilChance(.5).
+		NumElements(0, 3).
+		RandSource(rand.NewSource(seed)).
+		Funcs(funcs...)
+}
+
+func TestApplicationConversionFromHub(t *testing.T) {
+	f := newFuzzer(1)
+	for i := 0; i < fuzzIterations; i++ {
+		hub := &v1.Application{}
+		f.Fuzz(hub)
+		hub.TypeMeta = metav1.TypeMeta{}
+
+		spoke := &Application{}
+		if err := spoke.ConvertFrom(hub.DeepCopy()); err != nil {
+			t.Fatalf("convert from v1: %v", err)
+		}
+		restored := &v1.Application{}
+		if err := spoke.ConvertTo(restored); err != nil {
+			t.Fatalf("convert to v1: %v", err)
+		}
+
+		// status 只保留 v2 能够表达的部分
+		spokeStatus := statusFromV1(&hub.Status)
+		hub.Status = statusToV1(&spokeStatus)
+		if !apiequality.Semantic.DeepEqual(hub, restored) {
+			t.Fatalf("v1 -> v2 -> v1 round trip changed the object:\n%s", diff.ObjectReflectDiff(hub, restored))
+		}
+	}
+}
+
+// TestApplicationConversionLossyDataAnnotation 确认只有 spec 中存在 v2 无法表达的字段时才保存注解
+func TestApplicationConversionLossyDataAnnotation(t *testing.T) {
+	replicas := int32(2)
+	tests := []struct {
+		name   string
+		mutate func(hub *v1.Application)
+		want   bool
+	}{
+		{
+			name: "a spec v2 can express",
+			mutate: func(hub *v1.Application) {
+				hub.Spec.Deployment.Replicas = &replicas
+			},
+		},
+		{
+			name: "a status v2 cannot express",
+			mutate: func(hub *v1.Application) {
+				hub.Status.Workflow.ObservedGeneration = 3
+				hub.Status.Workflow.UnavailableReplicas = 1
+			},
+		},
+		{
+			name: "a sidecar container",
+			mutate: func(hub *v1.Application) {
+				hub.Spec.Deployment.Template.Spec.Containers = append(hub.Spec.Deployment.Template.Spec.Containers,
+					corev1.Container{Name: "sidecar", Image: "envoy:1.28"})
+			},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := &v1.Application{}
+			hub.Name = "demo"
+			hub.Spec.Deployment.Template.Spec.Containers = []corev1.Container{{Name: MainContainerName, Image: "nginx:1.25"}}
+			tt.mutate(hub)
+
+			spoke := &Application{}
+			if err := spoke.ConvertFrom(hub); err != nil {
+				t.Fatalf("convert from v1: %v", err)
+			}
+			if _, ok := spoke.Annotations[LossyDataAnnotation]; ok != tt.want {
+				t.Errorf("annotation %s present = %v, want %v", LossyDataAnnotation, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplicationConversionToHub(t *testing.T) {
+	f := newFuzzer(2)
+	for i := 0; i < fuzzIterations; i++ {
+		spoke := &Application{}
+		f.Fuzz(spoke)
+		spoke.TypeMeta = metav1.TypeMeta{}
+		delete(spoke.Annotations, LossyDataAnnotation)
+		if len(spoke.Annotations) == 0 {
+			spoke.Annotations = nil
+		}
+
+		hub := &v1.Application{}
+		if err := spoke.DeepCopy().ConvertTo(hub); err != nil {
+			t.Fatalf("convert to v1: %v", err)
+		}
+		restored := &Application{}
+		if err := restored.ConvertFrom(hub); err != nil {
+			t.Fatalf("convert from v1: %v", err)
+		}
+
+		if !apiequality.Semantic.DeepEqual(spoke, restored) {
+			t.Fatalf("v2 -> v1 -> v2 round trip changed the object:\n%s", diff.ObjectReflectDiff(spoke, restored))
+		}
+	}
+}
+
+// TestApplicationConversionPreservesUnchangedFields 确认在 v2 中修改部分字段后，v2 无法表达的 v1 字段得以保留
+func TestApplicationConversionPreservesUnchangedFields(t *testing.T) {
+	hub := &v1.Application{}
+	hub.Name = "demo"
+	hub.Spec.Deployment.Template.Spec.Containers = []corev1.Container{
+		{Name: "web", Image: "nginx:1.24"},
+		{Name: "sidecar", Image: "envoy:1.28"},
+	}
+
+	spoke := &Application{}
+	if err := spoke.ConvertFrom(hub); err != nil {
+		t.Fatalf("convert from v1: %v", err)
+	}
+	if _, ok := spoke.Annotations[LossyDataAnnotation]; !ok {
+		t.Fatalf("expected annotation %s for a lossy conversion", LossyDataAnnotation)
+	}
+
+	spoke.Spec.Image = "nginx:1.25"
+	restored := &v1.Application{}
+	if err := spoke.ConvertTo(restored); err != nil {
+		t.Fatalf("convert to v1: %v", err)
+	}
+
+	containers := restored.Spec.Deployment.Template.Spec.Containers
+	if len(containers) != 2 || containers[0].Name != "web" || containers[0].Image != "nginx:1.25" || containers[1].Image != "envoy:1.28" {
+		t.Fatalf("unexpected containers after conversion: %+v", containers)
+	}
+	if _, ok := restored.Annotations[LossyDataAnnotation]; ok {
+		t.Fatalf("annotation %s must not be stored in v1", LossyDataAnnotation)
+	}
+}
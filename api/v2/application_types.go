@@ -0,0 +1,234 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v2
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// v2 不再内联上游的 DeploymentSpec 和 ServiceSpec，只保留单容器应用常用的字段
+// v1 仍是存储版本(Hub)，v2 中无法表达的 v1 字段(例如探针、Sidecar)在转换时保存在 LossyDataAnnotation 注解中
+
+// ApplicationSpec defines the desired state of Application
+type ApplicationSpec struct {
+	// Image of the application container.
+	// +optional
+	Image string `json:"image,omitempty"`
+
+	// Command overrides the entrypoint of the image.
+	// +optional
+	Command []string `json:"command,omitempty"`
+
+	// Args overrides the arguments of the entrypoint.
+	// +optional
+	Args []string `json:"args,omitempty"`
+
+	// Replicas is the number of desired pods.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+
+	// Ports exposed by the application container.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Ports []ApplicationPort `json:"ports,omitempty"`
+
+	// Env lists the environment variables of the application container.
+	// +optional
+	Env []EnvVar `json:"env,omitempty"`
+
+	// Resources of the application container.
+	// +optional
+	Resources Resources `json:"resources,omitempty"`
+
+	// Expose decides how the ports with a servicePort are exposed.
+	// +optional
+	Expose Expose `json:"expose,omitempty"`
+
+	// Paused stops the controller from mutating the child resources of this
+	// Application while status keeps being observed.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
+	// DependsOn lists the Applications that must report Ready before the
+	// child resources of this Application are rolled out.
+	// +optional
+	DependsOn []ApplicationReference `json:"dependsOn,omitempty"`
+
+	// TemplateRef refers to an ApplicationTemplate or ClusterApplicationTemplate
+	// whose spec is merged underneath this Application's spec.
+	// +optional
+	TemplateRef *TemplateReference `json:"templateRef,omitempty"`
+
+	// Environments renders this Application into one namespace per environment,
+	// each with its own overlay on top of the base spec.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Environments []ApplicationEnvironment `json:"environments,omitempty"`
+}
+
+// ApplicationPort is a port of the application container.
+type ApplicationPort struct {
+	// Name of the port, used as the name of both the container port and the Service port.
+	Name string `json:"name"`
+	// ContainerPort is the port the application listens on.
+	ContainerPort int32 `json:"containerPort"`
+	// Protocol of the port, defaults to TCP.
+	// +optional
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+	// ServicePort exposes the port through the Service. The port is not exposed when unset.
+	// +optional
+	ServicePort int32 `json:"servicePort,omitempty"`
+}
+
+// EnvVar is an environment variable of the application container.
+type EnvVar struct {
+	Name string `json:"name"`
+	// +optional
+	Value string `json:"value,omitempty"`
+}
+
+// Resources are the compute resources of the application container.
+type Resources struct {
+	// +optional
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+	// +optional
+	Limits corev1.ResourceList `json:"limits,omitempty"`
+}
+
+// Expose decides how the application is exposed.
+type Expose struct {
+	// Type of the Service, defaults to ClusterIP.
+	// +kubebuilder:validation:Enum=ClusterIP;NodePort;LoadBalancer
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+}
+
+// ApplicationEnvironment declares the per-environment overlay applied on top of the base spec.
+type ApplicationEnvironment struct {
+	// Name of the environment, e.g. dev, staging or prod.
+	Name string `json:"name"`
+	// Namespace the child resources of this environment are rendered into.
+	Namespace string `json:"namespace"`
+	// Replicas overrides spec.replicas.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// ImageTag overrides the tag of spec.image.
+	// +optional
+	ImageTag string `json:"imageTag,omitempty"`
+	// Resources are merged into spec.resources.
+	// +optional
+	Resources *Resources `json:"resources,omitempty"`
+	// Env is merged by name into spec.env.
+	// +optional
+	Env []EnvVar `json:"env,omitempty"`
+}
+
+// TemplateReference refers to an ApplicationTemplate in the namespace of the
+// Application or to a ClusterApplicationTemplate.
+type TemplateReference struct {
+	// Kind of the referenced template.
+	// +kubebuilder:validation:Enum=ApplicationTemplate;ClusterApplicationTemplate
+	// +kubebuilder:default=ApplicationTemplate
+	// +optional
+	Kind string `json:"kind,omitempty"`
+	// Name of the referenced template.
+	Name string `json:"name"`
+}
+
+// ApplicationReference refers to another Application.
+type ApplicationReference struct {
+	// Name of the referenced Application.
+	Name string `json:"name"`
+	// Namespace of the referenced Application, defaults to the namespace of the referring Application.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// ApplicationStatus defines the observed state of Application
+type ApplicationStatus struct {
+	// Replicas is the number of pods targeted by the Deployment.
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// ReadyReplicas is the number of ready pods.
+	// +optional
+	ReadyReplicas int32 `json:"readyReplicas,omitempty"`
+	// UpdatedReplicas is the number of pods running the latest pod template.
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+	// AvailableReplicas is the number of available pods.
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+
+	// Conditions represent the latest available observations of the Application's state.
+	// +optional
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Environments reports the observed state of each environment declared in spec.environments.
+	// +optional
+	// +listType=map
+	// +listMapKey=name
+	Environments []EnvironmentStatus `json:"environments,omitempty"`
+}
+
+// EnvironmentStatus is the observed state of one environment of an Application.
+type EnvironmentStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	// +optional
+	Replicas int32 `json:"replicas,omitempty"`
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+	// +optional
+	AvailableReplicas int32 `json:"availableReplicas,omitempty"`
+	// +optional
+	Ready bool `json:"ready,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:resource:path=applications,singular=application,scope=Namespaced,shortName=app
+//+kubebuilder:printcolumn:name="Image",type="string",JSONPath=".spec.image"
+//+kubebuilder:printcolumn:name="Available",type="integer",JSONPath=".status.availableReplicas"
+//+kubebuilder:printcolumn:name="Ready",type="string",JSONPath=".status.conditions[?(@.type==\"Ready\")].status"
+//+kubebuilder:printcolumn:name="Age",type="date",JSONPath=".metadata.creationTimestamp"
+
+// Application is the Schema for the applications API
+type Application struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ApplicationSpec   `json:"spec,omitempty"`
+	Status ApplicationStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// ApplicationList contains a list of Application
+type ApplicationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Application `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Application{}, &ApplicationList{})
+}
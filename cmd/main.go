@@ -17,22 +17,42 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	appsv2 "github.com/ahwhy/clusterops-operator/api/v2"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/certs"
+	"github.com/ahwhy/clusterops-operator/internal/config"
 	"github.com/ahwhy/clusterops-operator/internal/controller"
+	"github.com/ahwhy/clusterops-operator/internal/debug"
+	"github.com/ahwhy/clusterops-operator/internal/featuregate"
+	"github.com/ahwhy/clusterops-operator/internal/health"
+	"github.com/ahwhy/clusterops-operator/internal/shutdown"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+	webhookappsv1 "github.com/ahwhy/clusterops-operator/internal/webhook/v1"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -43,20 +63,67 @@ var (
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(apiextensionsv1.AddToScheme(scheme))
 
 	utilruntime.Must(appsv1.AddToScheme(scheme))
+	utilruntime.Must(appsv2.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
 func main() {
+	var configFile string
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var applicationDefaultsFile string
+	var imageDigestsFile string
+	var allowedRegistries string
+	var watchNamespaces string
+	var watchNamespaceSelector string
+	var enableSharding bool
+	var featureGates string
+	var provisionWebhookCerts bool
+	var debugAddr string
+	var tracingExporter string
+	flag.StringVar(&configFile, "config", "",
+		"The versioned config file of the manager. Flags set on the command line take precedence over it. "+
+			"The admission section is reloaded when the file changes.")
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&applicationDefaultsFile, "application-defaults-file", "",
+		"The YAML file holding the defaults the webhook fills into Applications. "+
+			"Built-in defaults are used when empty.")
+	flag.StringVar(&imageDigestsFile, "image-digests-file", "",
+		"The YAML file mapping image references to digests. "+
+			"When set, the webhook pins the images of Applications to these digests and rejects images it cannot resolve.")
+	flag.StringVar(&allowedRegistries, "allowed-registries", "",
+		"Comma-separated registries, optionally with a repository prefix, container images of Applications may come from. "+
+			"Any registry is allowed when empty.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", "",
+		"Comma-separated namespaces the manager watches. The whole cluster is watched when neither "+
+			"this nor --watch-namespace-selector is set.")
+	flag.StringVar(&watchNamespaceSelector, "watch-namespace-selector", "",
+		"Label selector of the namespaces the manager watches, in addition to --watch-namespaces. "+
+			"It is evaluated when the manager starts.")
+	flag.BoolVar(&enableSharding, "shard", false,
+		"Spread Applications across the replicas of the manager. Every replica reconciles the Applications "+
+			"assigned to it by a consistent hash, the Application controller does not need leader election. "+
+			"Requires --leader-elect, the other controllers still run on the leader only.")
+	flag.StringVar(&featureGates, "feature-gates", "",
+		"Comma-separated Name=true|false pairs enabling or disabling experimental features, "+
+			"overriding the featureGates of the config file. Options are:\n"+featuregate.Default.Usage())
+	flag.BoolVar(&provisionWebhookCerts, "provision-webhook-certs", false,
+		"Generate and rotate a self-signed certificate for the webhook server and inject its CA into the "+
+			"webhook configurations and CRDs, instead of relying on cert-manager.")
+	flag.StringVar(&debugAddr, "debug-bind-address", "",
+		"The address the debug endpoints (pprof, work queues, last reconcile results, desired state of Applications) "+
+			"bind to. They are disabled when empty and should only bind to localhost.")
+	flag.StringVar(&tracingExporter, "tracing-exporter", "",
+		"Where OpenTelemetry spans of reconciles, API calls and admission requests are exported to: "+
+			"none, stdout, file or otlp, overriding tracing.exporter of the config file.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -65,59 +132,381 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	// 命令行中显式设置的参数覆盖配置文件中的对应字段，重新加载配置文件时同样适用
+	setFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { setFlags[f.Name] = true })
+	applyFlags := func(cfg *config.ManagerConfig) error {
+		if setFlags["metrics-bind-address"] {
+			cfg.Metrics.BindAddress = metricsAddr
+		}
+		if setFlags["health-probe-bind-address"] {
+			cfg.Health.BindAddress = probeAddr
+		}
+		if setFlags["leader-elect"] {
+			cfg.LeaderElection.LeaderElect = enableLeaderElection
+		}
+		if setFlags["application-defaults-file"] && applicationDefaultsFile != "" {
+			data, err := os.ReadFile(applicationDefaultsFile)
+			if err != nil {
+				return err
+			}
+			if cfg.Admission.Defaults, err = webhookappsv1.LoadApplicationDefaults(data); err != nil {
+				return err
+			}
+		}
+		if setFlags["image-digests-file"] {
+			cfg.Admission.ImageDigestsFile = imageDigestsFile
+		}
+		if setFlags["allowed-registries"] {
+			cfg.Admission.AllowedRegistries = nil
+			for _, registry := range strings.Split(allowedRegistries, ",") {
+				if registry = strings.TrimSpace(registry); registry != "" {
+					cfg.Admission.AllowedRegistries = append(cfg.Admission.AllowedRegistries, registry)
+				}
+			}
+		}
+		if setFlags["watch-namespaces"] {
+			cfg.Cache.Namespaces = nil
+			for _, namespace := range strings.Split(watchNamespaces, ",") {
+				if namespace = strings.TrimSpace(namespace); namespace != "" {
+					cfg.Cache.Namespaces = append(cfg.Cache.Namespaces, namespace)
+				}
+			}
+		}
+		if setFlags["shard"] {
+			cfg.Sharding.Enabled = enableSharding
+		}
+		if setFlags["debug-bind-address"] {
+			cfg.Debug.BindAddress = debugAddr
+		}
+		if setFlags["tracing-exporter"] {
+			cfg.Tracing.Exporter = tracingExporter
+		}
+		if setFlags["provision-webhook-certs"] {
+			cfg.Webhook.CertProvisioner.Enabled = provisionWebhookCerts
+		}
+		if setFlags["feature-gates"] {
+			gates, err := featuregate.Parse(featureGates)
+			if err != nil {
+				return err
+			}
+			if cfg.FeatureGates == nil {
+				cfg.FeatureGates = map[string]bool{}
+			}
+			for name, enabled := range gates {
+				cfg.FeatureGates[name] = enabled
+			}
+		}
+		if setFlags["watch-namespace-selector"] {
+			selector, err := metav1.ParseToLabelSelector(watchNamespaceSelector)
+			if err != nil {
+				return err
+			}
+			cfg.Cache.NamespaceSelector = selector
+		}
+		return config.Validate(cfg)
+	}
+
+	cfg := config.New()
+	if configFile != "" {
+		var err error
+		if cfg, err = config.LoadFile(configFile); err != nil {
+			setupLog.Error(err, "unable to load config", "file", configFile)
+			os.Exit(1)
+		}
+	}
+	if err := applyFlags(cfg); err != nil {
+		setupLog.Error(err, "invalid config")
+		os.Exit(1)
+	}
+
+	// 特性开关在启动时生效，修改后需要重启 Manager
+	if err := featuregate.Default.Set(cfg.FeatureGates); err != nil {
+		setupLog.Error(err, "invalid feature gates")
+		os.Exit(1)
+	}
+	for _, status := range featuregate.Default.Status() {
+		if status.Enabled != status.Default {
+			setupLog.Info("feature gate set", "feature", status.Name, "stage", status.Stage, "enabled", status.Enabled)
+		}
+	}
+
+	// 链路追踪在创建 Manager 之前设置，webhook 和控制器使用全局的 TracerProvider
+	shutdownTracing, err := tracing.Setup(cfg.TracingOptions())
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+
+	// 控制器和证书签发器发起的变更写入审计日志
+	auditLogger, err := cfg.AuditLogger()
+	if err != nil {
+		setupLog.Error(err, "unable to open audit log")
+		os.Exit(1)
+	}
+
+	webhookOptions, err := cfg.Admission.WebhookOptions()
+	if err != nil {
+		setupLog.Error(err, "unable to load admission config")
+		os.Exit(1)
+	}
+	webhookConfig := webhookappsv1.NewApplicationWebhookConfig(webhookOptions)
+
 	// 实例化了一个 Manager 对象
 	// Manager 负责跟踪维护和运行所有的 Controllers，同时也设置了共享缓存以及和 kube-apiserver 通信用的各种 Clients
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:                 scheme,
-		MetricsBindAddress:     metricsAddr,
-		Port:                   9443,
-		HealthProbeBindAddress: probeAddr,
-		LeaderElection:         enableLeaderElection,
-		LeaderElectionID:       "55451705.clusterops.io",
-		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
-		// when the Manager ends. This requires the binary to immediately end when the
-		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
-		// speeds up voluntary leader transitions as the new leader don't have to wait
-		// LeaseDuration time first.
-		//
-		// In the default scaffold provided, the program ends immediately after
-		// the manager stops, so would be fine to enable this option. However,
-		// if you are doing or is intended to do any operation such as perform cleanups
-		// after the manager stops then its usage might be unsafe.
-		// LeaderElectionReleaseOnCancel: true,
-	})
+	restConfig := ctrl.GetConfigOrDie()
+
+	// Manager 启动前的准备工作直接访问 apiserver
+	directClient, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		setupLog.Error(err, "unable to create client")
+		os.Exit(1)
+	}
+
+	// 只监听部分命名空间时，Manager 的缓存只包含这些命名空间中的对象，配合 config/namespaced 中的 Role 以最小权限运行
+	namespaces, err := watchedNamespaces(directClient, &cfg.Cache)
+	if err != nil {
+		setupLog.Error(err, "unable to resolve watched namespaces")
+		os.Exit(1)
+	}
+	if len(namespaces) > 0 {
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+	}
+
+	mgr, err := ctrl.NewManager(restConfig, cfg.ManagerOptions(scheme, namespaces))
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
-	if err = (&controller.ApplicationReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
-	}).SetupWithManager(mgr); err != nil {
+	// 启用分片时每个副本通过 Lease 加入分片组，只调谐分配给自己的 Application
+	shard, err := cfg.ShardMembership(mgr.GetClient(), mgr.GetAPIReader())
+	if err != nil {
+		setupLog.Error(err, "unable to set up sharding")
+		os.Exit(1)
+	}
+	if shard != nil {
+		if err = mgr.Add(shard); err != nil {
+			setupLog.Error(err, "unable to set up sharding")
+			os.Exit(1)
+		}
+		setupLog.Info("sharding enabled", "group", shard.Group, "identity", shard.Identity, "namespace", shard.Namespace)
+	}
+
+	// Manager 停止时先等待正在执行的调谐完成，再导出链路追踪和审计日志，随后 Manager 释放 Lease
+	drainer := shutdown.NewDrainer(cfg.GracefulShutdownTimeout.Duration,
+		shutdown.Flusher{Name: "tracing", Flush: tracing.Flush},
+		shutdown.Flusher{Name: "audit", Flush: func(context.Context) error { return auditLogger.Flush() }},
+	)
+	if err = mgr.Add(drainer); err != nil {
+		setupLog.Error(err, "unable to set up graceful shutdown")
+		os.Exit(1)
+	}
+
+	// 启用管理端点时记录每次调谐的结果
+	var tracker *debug.Tracker
+	if cfg.Debug.BindAddress != "" {
+		tracker = debug.NewTracker()
+	}
+
+	applicationReconciler := &controller.ApplicationReconciler{
+		Client:            tracing.WrapClient(audit.WrapClient(mgr.GetClient(), auditLogger)),
+		Scheme:            mgr.GetScheme(),
+		Options:           cfg.Controllers.Application.ControllerOptions(),
+		Shard:             shard,
+		Tracker:           tracker,
+		Drainer:           drainer,
+		Recorder:          mgr.GetEventRecorderFor("application-controller"),
+		WatchedNamespaces: namespaces,
+	}
+	if err = applicationReconciler.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Application")
 		os.Exit(1)
 	}
-	if err = (&appsv1.Application{}).SetupWebhookWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create webhook", "webhook", "Application")
+	// ApplicationSet 是集群级别的资源，会在任意命名空间中生成 Application，只监听部分命名空间时不启动
+	if len(namespaces) == 0 {
+		if err = (&controller.ApplicationSetReconciler{
+			Client:  tracing.WrapClient(audit.WrapClient(mgr.GetClient(), auditLogger)),
+			Scheme:  mgr.GetScheme(),
+			Options: cfg.Controllers.ApplicationSet.ControllerOptions(),
+			Tracker: tracker,
+			Drainer: drainer,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create controller", "controller", "ApplicationSet")
+			os.Exit(1)
+		}
+	}
+	// 租户以命名空间模式运行时通常无权注册集群级别的 webhook 配置，可以通过 ENABLE_WEBHOOKS=false 关闭 webhook
+	webhooksEnabled := os.Getenv("ENABLE_WEBHOOKS") != "false"
+	// 内置的证书签发器代替 cert-manager，webhook server 启动时需要读取证书，因此在 Manager 启动前先签发一次
+	if webhooksEnabled {
+		provisioner, err := cfg.CertProvisioner(audit.WrapClient(directClient, auditLogger))
+		if err != nil {
+			setupLog.Error(err, "unable to set up webhook certificates")
+			os.Exit(1)
+		}
+		if provisioner != nil {
+			if err := provisionWebhookCertificates(provisioner); err != nil {
+				setupLog.Error(err, "unable to provision webhook certificates")
+				os.Exit(1)
+			}
+			if err = mgr.Add(provisioner); err != nil {
+				setupLog.Error(err, "unable to set up webhook certificates")
+				os.Exit(1)
+			}
+		}
+		if err = webhookappsv1.SetupApplicationWebhookWithManager(mgr, webhookConfig); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Application")
+			os.Exit(1)
+		}
+	}
+	debugServer, err := cfg.DebugServer(tracker, applicationReconciler, controller.ApplicationControllerName)
+	if err != nil {
+		setupLog.Error(err, "unable to set up debug endpoints")
 		os.Exit(1)
 	}
+	if debugServer != nil {
+		if err = mgr.Add(debugServer); err != nil {
+			setupLog.Error(err, "unable to set up debug endpoints")
+			os.Exit(1)
+		}
+	}
+	if configFile != "" {
+		if err = mgr.Add(&config.Watcher{
+			Path:     configFile,
+			Current:  cfg,
+			Override: applyFlags,
+			OnAdmissionChange: func(admission *config.AdmissionConfig) error {
+				webhookOptions, err := admission.WebhookOptions()
+				if err != nil {
+					return err
+				}
+				webhookConfig.Store(webhookOptions)
+				return nil
+			},
+		}); err != nil {
+			setupLog.Error(err, "unable to set up config watcher")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
+	// 与 metrics 端点共用同一个 HTTP server
+	if err := mgr.AddMetricsExtraHandler("/debug/feature-gates", featuregate.Default); err != nil {
+		setupLog.Error(err, "unable to set up feature gates endpoint")
+		os.Exit(1)
+	}
+
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
 		setupLog.Error(err, "unable to set up health check")
 		os.Exit(1)
 	}
-	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
+	// 就绪检查分项注册，/readyz?verbose 列出每一项的结果，/readyz/<name> 返回失败的原因
+	checks, diagnostics, err := healthChecks(mgr, restConfig, cfg, webhooksEnabled)
+	if err != nil {
 		setupLog.Error(err, "unable to set up ready check")
 		os.Exit(1)
 	}
+	checks["shutdown"] = drainer.Check
+	for _, name := range sortedKeys(checks) {
+		if err := mgr.AddReadyzCheck(name, checks[name]); err != nil {
+			setupLog.Error(err, "unable to set up ready check", "check", name)
+			os.Exit(1)
+		}
+	}
+	// 诊断检查不影响就绪状态，与 metrics 端点共用同一个 HTTP server，/debug/health/<name> 返回单项检查的结果
+	diagnosticsHandler := http.StripPrefix("/debug/health", &healthz.Handler{Checks: diagnostics})
+	for _, path := range []string{"/debug/health", "/debug/health/"} {
+		if err := mgr.AddMetricsExtraHandler(path, diagnosticsHandler); err != nil {
+			setupLog.Error(err, "unable to set up diagnostic checks")
+			os.Exit(1)
+		}
+	}
 
 	setupLog.Info("starting manager")
 	// 通过 Start() 方法启动 Manager，当 Manager 运行后，会启动所有的 Controller 和 Webhook
 	// Manager 会一直运行在后台，直到接收到 "优雅停止" 信号
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	ctx := ctrl.SetupSignalHandler()
+	// 收到停止信号后就绪检查立即失败，未当选 leader 的副本同样如此
+	go func() {
+		<-ctx.Done()
+		drainer.Stopping()
+	}()
+	err = mgr.Start(ctx)
+
+	// 未当选 leader 的副本不运行 drainer，webhook 产生的 span 和证书签发器的审计日志在这里导出
+	flushCtx, cancel := context.WithTimeout(context.Background(), shutdown.FlushTimeout)
+	if flushErr := shutdownTracing(flushCtx); flushErr != nil {
+		setupLog.Error(flushErr, "unable to flush traces")
+	}
+	cancel()
+	if closeErr := auditLogger.Close(); closeErr != nil {
+		setupLog.Error(closeErr, "unable to flush audit log")
+	}
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
 }
+
+// watchedNamespaces 在 Manager 启动前解析需要监听的命名空间，监听整个集群时返回 nil
+func watchedNamespaces(reader client.Reader, cache *config.CacheConfig) ([]string, error) {
+	if !cache.NamespaceScoped() {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return cache.WatchedNamespaces(ctx, reader)
+}
+
+// provisionWebhookCertificates 签发 webhook 证书，多个副本同时启动时可能需要重试
+func provisionWebhookCertificates(provisioner *certs.Provisioner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return wait.ExponentialBackoffWithContext(ctx, wait.Backoff{Duration: time.Second, Factor: 2, Steps: 5},
+		func(ctx context.Context) (bool, error) {
+			if err := provisioner.Provision(ctx); err != nil {
+				setupLog.Error(err, "unable to provision webhook certificates, retrying")
+				return false, nil
+			}
+			return true, nil
+		})
+}
+
+// healthChecks 返回 Manager 的就绪检查和诊断检查，只包含已启用的组件
+// 就绪检查只关注本副本能否处理请求：缓存已同步、webhook 正在服务。apiserver 和 leader election 的状态对所有副本都相同，
+// 纳入就绪检查会在 apiserver 短暂故障或 Lease 无人持有时让所有副本同时下线，webhook 随之不可用，因此只作为诊断检查
+func healthChecks(mgr ctrl.Manager, restConfig *rest.Config, cfg *config.ManagerConfig, webhooksEnabled bool) (
+	readyz, diagnostics map[string]healthz.Checker, err error) {
+	readyz = map[string]healthz.Checker{
+		"informer-cache": health.CacheSynced(mgr.GetCache()),
+	}
+	if webhooksEnabled {
+		readyz["webhook"] = health.WebhookServing(mgr.GetWebhookServer(), cfg.Webhook.CertDir)
+	}
+
+	apiserver, err := health.APIServerReachable(restConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	diagnostics = map[string]healthz.Checker{
+		"apiserver": apiserver,
+	}
+	if cfg.LeaderElection.LeaderElect {
+		lease, err := cfg.LeaderElectionLease()
+		if err != nil {
+			return nil, nil, err
+		}
+		diagnostics["leader-election"] = health.LeaderElection(mgr.Elected(), mgr.GetAPIReader(), lease)
+	}
+	return readyz, diagnostics, nil
+}
+
+func sortedKeys(m map[string]healthz.Checker) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
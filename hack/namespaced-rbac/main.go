@@ -0,0 +1,207 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// namespaced-rbac 根据 controller-gen 生成的 ClusterRole 生成命名空间模式使用的 RBAC:
+// 命名空间级别资源的规则写入 Role，在每个被监听的命名空间中绑定；集群级别资源(集群级别的 CRD、Namespace 等)的规则写入 ClusterRole
+// 命名空间模式下不修改集群级别的资源，ClusterRole 只保留只读权限
+//
+//	go run ./hack/namespaced-rbac -role config/rbac/role.yaml -crds config/crd/bases -output config/rbac-namespaced
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"sigs.k8s.io/yaml"
+)
+
+// builtinClusterResources 是 Operator 可能用到的内置集群级别资源，key 为 API group
+var builtinClusterResources = map[string][]string{
+	"":                             {"namespaces", "nodes", "persistentvolumes"},
+	"storage.k8s.io":               {"storageclasses"},
+	"apiextensions.k8s.io":         {"customresourcedefinitions"},
+	"admissionregistration.k8s.io": {"mutatingwebhookconfigurations", "validatingwebhookconfigurations"},
+	"authorization.k8s.io":         {"subjectaccessreviews"},
+}
+
+// reviewResources 是只用于查询的集群级别资源，create 不修改集群状态，命名空间模式下同样保留
+var reviewResources = map[string]map[string]bool{
+	"authorization.k8s.io": {"subjectaccessreviews": true},
+}
+
+// role 只保留 controller-gen 输出中的字段，避免序列化出 creationTimestamp 等空字段
+type role struct {
+	APIVersion string              `json:"apiVersion"`
+	Kind       string              `json:"kind"`
+	Metadata   roleMetadata        `json:"metadata"`
+	Rules      []rbacv1.PolicyRule `json:"rules"`
+}
+
+type roleMetadata struct {
+	Name string `json:"name"`
+}
+
+func main() {
+	rolePath := flag.String("role", "config/rbac/role.yaml", "The ClusterRole generated by controller-gen.")
+	crdDir := flag.String("crds", "config/crd/bases", "The directory of the generated CustomResourceDefinitions.")
+	output := flag.String("output", "config/rbac-namespaced", "The directory the namespaced RBAC is written to.")
+	flag.Parse()
+
+	if err := run(*rolePath, *crdDir, *output); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(rolePath, crdDir, output string) error {
+	data, err := os.ReadFile(rolePath)
+	if err != nil {
+		return err
+	}
+	// 带有 namespace 的规则生成为单独的 Role(例如证书 Secret 的权限)，命名空间模式不注册 webhook，不需要这些权限
+	var clusterRole *role
+	for _, doc := range bytes.Split(data, []byte("---\n")) {
+		obj := &role{}
+		if err := yaml.Unmarshal(doc, obj); err != nil {
+			return fmt.Errorf("parse %s: %w", rolePath, err)
+		}
+		if obj.Kind == "ClusterRole" {
+			clusterRole = obj
+			break
+		}
+	}
+	if clusterRole == nil {
+		return fmt.Errorf("no ClusterRole in %s", rolePath)
+	}
+
+	clusterResources, err := clusterScopedResources(crdDir)
+	if err != nil {
+		return err
+	}
+
+	namespaced, clusterScoped := splitRules(clusterRole.Rules, clusterResources)
+	if err := write(filepath.Join(output, "role.yaml"), role{
+		APIVersion: rbacv1.SchemeGroupVersion.String(),
+		Kind:       "Role",
+		Metadata:   roleMetadata{Name: clusterRole.Metadata.Name},
+		Rules:      namespaced,
+	}); err != nil {
+		return err
+	}
+	return write(filepath.Join(output, "cluster_role.yaml"), role{
+		APIVersion: rbacv1.SchemeGroupVersion.String(),
+		Kind:       "ClusterRole",
+		Metadata:   roleMetadata{Name: clusterRole.Metadata.Name + "-cluster"},
+		Rules:      clusterScoped,
+	})
+}
+
+// clusterScopedResources 返回集群级别的资源，key 为 API group，value 为资源复数名称的集合
+func clusterScopedResources(crdDir string) (map[string]map[string]bool, error) {
+	resources := map[string]map[string]bool{}
+	add := func(group, resource string) {
+		if resources[group] == nil {
+			resources[group] = map[string]bool{}
+		}
+		resources[group][resource] = true
+	}
+	for group, names := range builtinClusterResources {
+		for _, name := range names {
+			add(group, name)
+		}
+	}
+
+	files, err := filepath.Glob(filepath.Join(crdDir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := yaml.Unmarshal(bytes.TrimPrefix(data, []byte("---\n")), crd); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", file, err)
+		}
+		if crd.Spec.Scope == apiextensionsv1.ClusterScoped {
+			add(crd.Spec.Group, crd.Spec.Names.Plural)
+		}
+	}
+	return resources, nil
+}
+
+// readOnlyVerbs 是集群级别资源保留的权限
+var readOnlyVerbs = map[string]bool{"get": true, "list": true, "watch": true}
+
+// splitRules 按资源是否为集群级别拆分规则，子资源(例如 applications/status)跟随其所属资源
+func splitRules(rules []rbacv1.PolicyRule, clusterResources map[string]map[string]bool) (namespaced, clusterScoped []rbacv1.PolicyRule) {
+	for _, rule := range rules {
+		for _, group := range rule.APIGroups {
+			var nsResources, clusterRes []string
+			for _, resource := range rule.Resources {
+				base, _, _ := strings.Cut(resource, "/")
+				if clusterResources[group][base] {
+					clusterRes = append(clusterRes, resource)
+				} else {
+					nsResources = append(nsResources, resource)
+				}
+			}
+			if len(nsResources) > 0 {
+				namespaced = append(namespaced, ruleFor(rule, group, nsResources))
+			}
+			if len(clusterRes) == 0 {
+				continue
+			}
+			clusterRule := ruleFor(rule, group, clusterRes)
+			clusterRule.Verbs = nil
+			review := true
+			for _, resource := range clusterRes {
+				review = review && reviewResources[group][resource]
+			}
+			for _, verb := range rule.Verbs {
+				if readOnlyVerbs[verb] || review && verb == "create" {
+					clusterRule.Verbs = append(clusterRule.Verbs, verb)
+				}
+			}
+			if len(clusterRule.Verbs) > 0 {
+				clusterScoped = append(clusterScoped, clusterRule)
+			}
+		}
+	}
+	return namespaced, clusterScoped
+}
+
+func ruleFor(rule rbacv1.PolicyRule, group string, resources []string) rbacv1.PolicyRule {
+	out := *rule.DeepCopy()
+	out.APIGroups = []string{group}
+	out.Resources = resources
+	return out
+}
+
+func write(path string, obj role) error {
+	data, err := yaml.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte("---\n"), data...), 0o644)
+}
@@ -0,0 +1,210 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package audit 以 JSON Lines 的形式记录 Operator 对集群对象发起的每一次变更，包括子资源的创建、更新、删除以及 status 的写入，
+// 每条记录带有触发变更的 Application、reconcile ID、变更原因和变更前后的字段差异，便于合规审计时还原工作负载的变更过程
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+)
+
+// Sink is where audit events are written to.
+type Sink string
+
+const (
+	// SinkNone disables the audit log.
+	SinkNone Sink = "none"
+	// SinkStdout writes audit events to the standard output.
+	SinkStdout Sink = "stdout"
+	// SinkFile appends audit events to a file.
+	SinkFile Sink = "file"
+)
+
+// ObjectRef identifies an object, and optionally one of its subresources.
+type ObjectRef struct {
+	APIVersion  string `json:"apiVersion,omitempty"`
+	Kind        string `json:"kind"`
+	Namespace   string `json:"namespace,omitempty"`
+	Name        string `json:"name"`
+	Subresource string `json:"subresource,omitempty"`
+}
+
+// FieldChange is a field that differs before and after a mutation. The values are only
+// recorded for scalar fields.
+type FieldChange struct {
+	Path   string      `json:"path"`
+	Before interface{} `json:"before,omitempty"`
+	After  interface{} `json:"after,omitempty"`
+}
+
+// Event is a mutation of a cluster object made by the operator.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Controller 和 Application 是发起变更的控制器及其正在调谐的对象
+	Controller  string     `json:"controller,omitempty"`
+	Application *ObjectRef `json:"application,omitempty"`
+	ReconcileID string     `json:"reconcileID,omitempty"`
+	TraceID     string     `json:"traceID,omitempty"`
+	// Verb 是 create、update、patch、delete 或 deletecollection
+	Verb     string    `json:"verb"`
+	Resource ObjectRef `json:"resource"`
+	Reason   string    `json:"reason,omitempty"`
+	// Changes 是 update 和 patch 变更前后不同的字段，超过 MaxChanges 时截断
+	Changes          []FieldChange `json:"changes,omitempty"`
+	ChangesTruncated bool          `json:"changesTruncated,omitempty"`
+	// Error 不为空时变更失败
+	Error string `json:"error,omitempty"`
+}
+
+// Logger writes audit events as JSON lines. A nil Logger records nothing.
+type Logger struct {
+	mu sync.Mutex
+	w  io.Writer
+	// file 不为 nil 时 Flush 将其同步到磁盘，Close 时关闭
+	file *os.File
+	now  func() time.Time
+}
+
+// NewLogger 返回写入 w 的 Logger
+func NewLogger(w io.Writer) *Logger {
+	return &Logger{w: w, now: time.Now}
+}
+
+// Open 根据 sink 返回 Logger，sink 为空或 none 时返回 nil
+func Open(sink Sink, path string) (*Logger, error) {
+	switch sink {
+	case "", SinkNone:
+		return nil, nil
+	case SinkStdout:
+		return &Logger{w: os.Stdout, file: os.Stdout, now: time.Now}, nil
+	case SinkFile:
+		// 审计日志可能包含敏感字段的变更，只允许 Operator 自身读取
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return &Logger{w: f, file: f, now: time.Now}, nil
+	default:
+		return nil, fmt.Errorf("unsupported audit sink %q", sink)
+	}
+}
+
+// Log 写入一条审计记录，并从 ctx 中补充控制器、正在调谐的对象、reconcile ID、trace ID 和变更原因
+func (l *Logger) Log(ctx context.Context, event Event) {
+	if l == nil {
+		return
+	}
+	if event.Time.IsZero() {
+		event.Time = l.now().UTC()
+	}
+	if info, ok := ctx.Value(reconcileKey{}).(*reconcileInfo); ok {
+		event.Controller = info.controller
+		application := info.object
+		event.Application = &application
+	}
+	if id := controller.ReconcileIDFromContext(ctx); id != "" {
+		event.ReconcileID = string(id)
+	}
+	event.TraceID = tracing.TraceID(ctx)
+	if event.Reason == "" {
+		event.Reason = ReasonFrom(ctx)
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		ctrl.Log.WithName("audit").Error(err, "Failed to encode the audit event.")
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.w.Write(append(data, '\n')); err != nil {
+		ctrl.Log.WithName("audit").Error(err, "Failed to write the audit event.", "verb", event.Verb, "resource", event.Resource)
+	}
+}
+
+// Flush 将已写入的审计记录同步到磁盘
+func (l *Logger) Flush() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// 标准输出可能是管道，不支持 fsync
+	if l.file == os.Stdout {
+		return nil
+	}
+	return l.file.Sync()
+}
+
+// Close 同步并关闭审计日志文件
+func (l *Logger) Close() error {
+	if err := l.Flush(); err != nil {
+		return err
+	}
+	if l == nil || l.file == nil || l.file == os.Stdout {
+		return nil
+	}
+	return l.file.Close()
+}
+
+type reconcileKey struct{}
+
+type reconcileInfo struct {
+	controller string
+	object     ObjectRef
+}
+
+// Reconciler 返回在 ctx 中记录控制器名称和正在调谐的对象的 Reconciler，kind 是控制器调谐的对象类型
+func Reconciler(controllerName, kind string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		ctx = context.WithValue(ctx, reconcileKey{}, &reconcileInfo{
+			controller: controllerName,
+			object:     objectRef(kind, req.NamespacedName),
+		})
+		return r.Reconcile(ctx, req)
+	})
+}
+
+func objectRef(kind string, key types.NamespacedName) ObjectRef {
+	return ObjectRef{Kind: kind, Namespace: key.Namespace, Name: key.Name}
+}
+
+type reasonKey struct{}
+
+// WithReason 返回带有变更原因的 ctx，通过该 ctx 发起的变更以 reason 记录
+func WithReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, reasonKey{}, reason)
+}
+
+// ReasonFrom 返回 ctx 中的变更原因
+func ReasonFrom(ctx context.Context) string {
+	reason, _ := ctx.Value(reasonKey{}).(string)
+	return reason
+}
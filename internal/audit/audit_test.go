@@ -0,0 +1,197 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/utils/pointer"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func decodeEvents(t *testing.T, buf *bytes.Buffer) []Event {
+	t.Helper()
+	var events []Event
+	decoder := json.NewDecoder(buf)
+	for decoder.More() {
+		var event Event
+		if err := decoder.Decode(&event); err != nil {
+			t.Fatalf("decode audit event: %v", err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestWrapClient(t *testing.T) {
+	buf := &bytes.Buffer{}
+	logger := NewLogger(buf)
+	logger.now = func() time.Time { return time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC) }
+
+	existing := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web", Annotations: map[string]string{"clusterops.io/spec-hash": "a"}},
+		Spec:       appsv1.DeploymentSpec{Replicas: pointer.Int32(1)},
+	}
+	cl := WrapClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).
+		WithObjects(existing).WithStatusSubresource(existing).Build(), logger)
+
+	r := Reconciler("application", "Application", reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		dp := &appsv1.Deployment{}
+		if err := cl.Get(ctx, req.NamespacedName, dp); err != nil {
+			return ctrl.Result{}, err
+		}
+		dp.Spec.Replicas = pointer.Int32(3)
+		dp.Annotations["clusterops.io/spec-hash"] = "b"
+		if err := cl.Update(WithReason(ctx, "DesiredStateChanged"), dp); err != nil {
+			return ctrl.Result{}, err
+		}
+		dp.Status.ReadyReplicas = 3
+		if err := cl.Status().Update(ctx, dp); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{}, cl.Delete(WithReason(ctx, "Pruned"), dp)
+	}))
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}); err != nil {
+		t.Fatalf("reconcile: %v", err)
+	}
+	// 调谐以外发起的变更没有 Application
+	if err := cl.Create(context.Background(), &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+
+	events := decodeEvents(t, buf)
+	if len(events) != 4 {
+		t.Fatalf("expected 4 audit events, got %d: %+v", len(events), events)
+	}
+
+	update := events[0]
+	if update.Verb != "update" || update.Reason != "DesiredStateChanged" || update.Controller != "application" ||
+		update.Application == nil || *update.Application != (ObjectRef{Kind: "Application", Namespace: "default", Name: "web"}) {
+		t.Errorf("unexpected update event: %+v", update)
+	}
+	if update.Resource != (ObjectRef{APIVersion: "apps/v1", Kind: "Deployment", Namespace: "default", Name: "web"}) {
+		t.Errorf("unexpected resource: %+v", update.Resource)
+	}
+	want := []FieldChange{
+		{Path: "metadata.annotations[clusterops.io/spec-hash]", Before: "a", After: "b"},
+		{Path: "spec.replicas", Before: float64(1), After: float64(3)},
+	}
+	if len(update.Changes) != len(want) || update.Changes[0] != want[0] || update.Changes[1] != want[1] {
+		t.Errorf("expected changes %+v, got %+v", want, update.Changes)
+	}
+	if !update.Time.Equal(time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("unexpected time: %s", update.Time)
+	}
+
+	status := events[1]
+	if status.Resource.Subresource != "status" || len(status.Changes) != 1 || status.Changes[0].Path != "status.readyReplicas" {
+		t.Errorf("expected only the status changes of the status write, got %+v", status)
+	}
+	if events[2].Verb != "delete" || events[2].Reason != "Pruned" || events[2].Changes != nil {
+		t.Errorf("unexpected delete event: %+v", events[2])
+	}
+	if create := events[3]; create.Verb != "create" || create.Error != "" || create.Application != nil {
+		t.Errorf("unexpected create event: %+v", create)
+	}
+}
+
+func TestWrapClientRecordsErrors(t *testing.T) {
+	buf := &bytes.Buffer{}
+	cl := WrapClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build(), NewLogger(buf))
+
+	dp := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "missing"}}
+	if err := cl.Update(context.Background(), dp); err == nil {
+		t.Fatalf("expected updating a missing Deployment to fail")
+	}
+	events := decodeEvents(t, buf)
+	if len(events) != 1 || events[0].Error == "" || events[0].Changes != nil {
+		t.Errorf("expected the failed update recorded with its error, got %+v", events)
+	}
+}
+
+func TestDiffTruncated(t *testing.T) {
+	before := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{}, ResourceVersion: "1"}}
+	after := before.DeepCopy()
+	after.ResourceVersion = "2"
+	for i := 0; i < MaxChanges+10; i++ {
+		after.Labels[string(rune('a'+i%26))+string(rune('a'+i/26))] = "x"
+	}
+	changes, truncated := diff(before, after, scopeObject)
+	if len(changes) != MaxChanges || !truncated {
+		t.Errorf("expected %d changes and truncated, got %d %v", MaxChanges, len(changes), truncated)
+	}
+	for _, change := range changes {
+		if change.Path == "metadata.resourceVersion" {
+			t.Errorf("expected resourceVersion to be ignored")
+		}
+	}
+}
+
+func TestWrapClientRedactsSecretData(t *testing.T) {
+	buf := &bytes.Buffer{}
+	existing := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "webhook-server-cert"},
+		Data:       map[string][]byte{"tls.key": []byte("old-private-key")},
+	}
+	cl := WrapClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(existing).Build(), NewLogger(buf))
+
+	secret := &corev1.Secret{}
+	if err := cl.Get(context.Background(), client.ObjectKeyFromObject(existing), secret); err != nil {
+		t.Fatal(err)
+	}
+	secret.Data["tls.key"] = []byte("new-private-key")
+	secret.StringData = map[string]string{"ca.key": "new-ca-key"}
+	secret.Labels = map[string]string{"rotated": "true"}
+	if err := cl.Update(context.Background(), secret); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+
+	logged := buf.String()
+	for _, value := range []string{"old-private-key", "new-private-key", "new-ca-key"} {
+		for _, encoded := range []string{value, base64.StdEncoding.EncodeToString([]byte(value))} {
+			if strings.Contains(logged, encoded) {
+				t.Errorf("the audit log contains the Secret value %q: %s", encoded, logged)
+			}
+		}
+	}
+
+	events := decodeEvents(t, bytes.NewBufferString(logged))
+	paths := map[string]FieldChange{}
+	for _, change := range events[0].Changes {
+		paths[change.Path] = change
+	}
+	if _, ok := paths["data[tls.key]"]; !ok {
+		t.Errorf("changes = %+v, want the changed data key recorded by path", events[0].Changes)
+	}
+	if change := paths["metadata.labels.rotated"]; change.After != "true" {
+		t.Errorf("changes = %+v, want the values of other fields kept", events[0].Changes)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// WrapClient 返回将每一次变更写入 logger 的 client，logger 为 nil 时直接返回 c。
+// update 和 patch 之前从 c 中读取对象的当前状态，用于计算变更前后的字段差异
+func WrapClient(c client.Client, logger *Logger) client.Client {
+	if logger == nil {
+		return c
+	}
+	return &auditedClient{Client: c, logger: logger}
+}
+
+type auditedClient struct {
+	client.Client
+	logger *Logger
+}
+
+var _ client.Client = &auditedClient{}
+
+func (c *auditedClient) ref(obj runtime.Object, namespace, name, subresource string) ObjectRef {
+	ref := ObjectRef{Namespace: namespace, Name: name, Subresource: subresource}
+	if gvk, err := apiutil.GVKForObject(obj, c.Scheme()); err == nil {
+		ref.APIVersion, ref.Kind = gvk.GroupVersion().String(), gvk.Kind
+	}
+	return ref
+}
+
+func (c *auditedClient) objectRef(obj client.Object, subresource string) ObjectRef {
+	return c.ref(obj, obj.GetNamespace(), obj.GetName(), subresource)
+}
+
+// current 读取 obj 的当前状态，读取失败时返回 nil，此时不记录字段差异
+func (c *auditedClient) current(ctx context.Context, obj client.Object) client.Object {
+	current, ok := obj.DeepCopyObject().(client.Object)
+	if !ok {
+		return nil
+	}
+	if err := c.Client.Get(ctx, client.ObjectKeyFromObject(obj), current); err != nil {
+		return nil
+	}
+	return current
+}
+
+func (c *auditedClient) log(ctx context.Context, verb string, ref ObjectRef, before, after client.Object, scope diffScope, err error) {
+	event := Event{Verb: verb, Resource: ref}
+	if before != nil && after != nil {
+		event.Changes, event.ChangesTruncated = diff(before, after, scope)
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	c.logger.Log(ctx, event)
+}
+
+func (c *auditedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	err := c.Client.Create(ctx, obj, opts...)
+	c.log(ctx, "create", c.objectRef(obj, ""), nil, nil, scopeObject, err)
+	return err
+}
+
+func (c *auditedClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	before := c.current(ctx, obj)
+	err := c.Client.Update(ctx, obj, opts...)
+	c.log(ctx, "update", c.objectRef(obj, ""), before, obj, scopeObject, err)
+	return err
+}
+
+func (c *auditedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	before := c.current(ctx, obj)
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	c.log(ctx, "patch", c.objectRef(obj, ""), before, obj, scopeObject, err)
+	return err
+}
+
+func (c *auditedClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	err := c.Client.Delete(ctx, obj, opts...)
+	c.log(ctx, "delete", c.objectRef(obj, ""), nil, nil, scopeObject, err)
+	return err
+}
+
+func (c *auditedClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	err := c.Client.DeleteAllOf(ctx, obj, opts...)
+	deleteOpts := (&client.DeleteAllOfOptions{}).ApplyOptions(opts)
+	c.log(ctx, "deletecollection", c.ref(obj, deleteOpts.Namespace, "", ""), nil, nil, scopeObject, err)
+	return err
+}
+
+func (c *auditedClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *auditedClient) SubResource(subResource string) client.SubResourceClient {
+	return &auditedSubResourceClient{
+		SubResourceClient: c.Client.SubResource(subResource),
+		client:            c,
+		subResource:       subResource,
+	}
+}
+
+type auditedSubResourceClient struct {
+	client.SubResourceClient
+	client      *auditedClient
+	subResource string
+}
+
+// scope 只有 status 子资源记录字段差异，其他子资源(例如 scale)的请求体与对象本身的结构不同
+func (c *auditedSubResourceClient) scope() diffScope {
+	if c.subResource == "status" {
+		return scopeStatus
+	}
+	return scopeNone
+}
+
+func (c *auditedSubResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	err := c.SubResourceClient.Create(ctx, obj, subResource, opts...)
+	c.client.log(ctx, "create", c.client.objectRef(obj, c.subResource), nil, nil, scopeNone, err)
+	return err
+}
+
+func (c *auditedSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	before := c.client.current(ctx, obj)
+	err := c.SubResourceClient.Update(ctx, obj, opts...)
+	c.client.log(ctx, "update", c.client.objectRef(obj, c.subResource), before, obj, c.scope(), err)
+	return err
+}
+
+func (c *auditedSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	before := c.client.current(ctx, obj)
+	err := c.SubResourceClient.Patch(ctx, obj, patch, opts...)
+	c.client.log(ctx, "patch", c.client.objectRef(obj, c.subResource), before, obj, c.scope(), err)
+	return err
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package audit
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// MaxChanges 是一条审计记录中保留的字段差异数量
+const MaxChanges = 50
+
+type diffScope int
+
+const (
+	// scopeObject 比较 status 以外的字段
+	scopeObject diffScope = iota
+	// scopeStatus 只比较 status
+	scopeStatus
+	// scopeNone 不比较
+	scopeNone
+)
+
+// ignoredMetadata 是每次写入都会变化或由 apiserver 维护的字段，不计入差异
+var ignoredMetadata = map[string]bool{
+	"resourceVersion":   true,
+	"generation":        true,
+	"managedFields":     true,
+	"uid":               true,
+	"creationTimestamp": true,
+	"selfLink":          true,
+}
+
+// diff 返回 before 和 after 之间不同的字段，路径以 . 分隔，例如 spec.replicas
+func diff(before, after runtime.Object, scope diffScope) ([]FieldChange, bool) {
+	if scope == scopeNone {
+		return nil, false
+	}
+	beforeMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(before)
+	if err != nil {
+		return nil, false
+	}
+	afterMap, err := runtime.DefaultUnstructuredConverter.ToUnstructured(after)
+	if err != nil {
+		return nil, false
+	}
+
+	for _, m := range []map[string]interface{}{beforeMap, afterMap} {
+		if scope == scopeStatus {
+			for key := range m {
+				if key != "status" {
+					delete(m, key)
+				}
+			}
+			continue
+		}
+		delete(m, "status")
+		if metadata, ok := m["metadata"].(map[string]interface{}); ok {
+			for key := range ignoredMetadata {
+				delete(metadata, key)
+			}
+		}
+	}
+
+	var changes []FieldChange
+	diffValue("", beforeMap, afterMap, &changes)
+	redact(after, changes)
+	if len(changes) > MaxChanges {
+		return changes[:MaxChanges], true
+	}
+	return changes, false
+}
+
+// secretFields 是 Secret 中包含敏感内容的字段，审计记录只保留其路径
+// last-applied-configuration 注解中同样包含 data 的完整内容
+var secretFields = []string{
+	"data",
+	"stringData",
+	"metadata.annotations[kubectl.kubernetes.io/last-applied-configuration]",
+}
+
+// redact 清除 Secret 敏感字段的变更前后的值，例如证书轮换时的私钥
+func redact(obj runtime.Object, changes []FieldChange) {
+	_, secret := obj.(*corev1.Secret)
+	if !secret && obj.GetObjectKind().GroupVersionKind().GroupKind() != (schema.GroupKind{Kind: "Secret"}) {
+		return
+	}
+	for i := range changes {
+		for _, field := range secretFields {
+			if path := changes[i].Path; path == field || strings.HasPrefix(path, field+".") || strings.HasPrefix(path, field+"[") {
+				changes[i].Before, changes[i].After = nil, nil
+			}
+		}
+	}
+}
+
+func diffValue(path string, before, after interface{}, changes *[]FieldChange) {
+	beforeMap, beforeIsMap := before.(map[string]interface{})
+	afterMap, afterIsMap := after.(map[string]interface{})
+	// 新增或删除整个对象时(例如第一次添加 labels)，逐个记录其中的字段
+	if beforeIsMap && after == nil || afterIsMap && before == nil {
+		beforeIsMap, afterIsMap = true, true
+	}
+	if beforeIsMap && afterIsMap {
+		keys := make([]string, 0, len(beforeMap)+len(afterMap))
+		for key := range beforeMap {
+			keys = append(keys, key)
+		}
+		for key := range afterMap {
+			if _, ok := beforeMap[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			diffValue(joinPath(path, key), beforeMap[key], afterMap[key], changes)
+		}
+		return
+	}
+
+	if reflect.DeepEqual(before, after) {
+		return
+	}
+	change := FieldChange{Path: path}
+	// 列表和对象只记录路径，避免审计记录中出现整个 Pod 模板
+	if isScalar(before) && isScalar(after) {
+		change.Before, change.After = before, after
+	}
+	*changes = append(*changes, change)
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case nil, string, bool, int64, float64:
+		return true
+	}
+	return false
+}
+
+// joinPath 对包含 . 的键(例如注解 clusterops.io/spec-hash)加上方括号
+func joinPath(path, key string) string {
+	if strings.Contains(key, ".") {
+		key = "[" + key + "]"
+		return path + key
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
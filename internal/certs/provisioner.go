@@ -0,0 +1,289 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// 证书 Secret 的权限只授予 Operator 所在的命名空间，create 无法通过 resourceNames 限制
+// 修改 certProvisioner 中的 Secret、webhook 配置或 CRD 名称时需要同步修改这里的 resourceNames
+//+kubebuilder:rbac:groups="",namespace=system,resources=secrets,verbs=create
+//+kubebuilder:rbac:groups="",namespace=system,resources=secrets,resourceNames=clusterops-operator-webhook-server-cert,verbs=get;update
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=mutatingwebhookconfigurations,resourceNames=clusterops-operator-mutating-webhook-configuration,verbs=get;patch
+//+kubebuilder:rbac:groups=admissionregistration.k8s.io,resources=validatingwebhookconfigurations,resourceNames=clusterops-operator-validating-webhook-configuration,verbs=get;patch
+//+kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,resourceNames=applications.apps.clusterops.io,verbs=get;patch
+
+const (
+	// CAKey 保存 CA 证书，当前 CA 在前，轮换前的 CA 在过期前继续保留，使新旧服务证书在轮换期间都能通过校验
+	CAKey = "ca.crt"
+	// CAPrivateKey 保存当前 CA 的私钥
+	CAPrivateKey = "ca.key"
+
+	// DefaultCheckInterval 是检查证书是否需要轮换的默认间隔
+	DefaultCheckInterval = time.Hour
+)
+
+// Provisioner generates a self-signed CA and the serving certificate of the webhook
+// server, stores them in a Secret shared by all replicas, writes the serving
+// certificate to CertDir and injects the CA into the webhook configurations and
+// the conversion webhook of the CRDs. Certificates are rotated before they expire.
+type Provisioner struct {
+	// Client 直接访问 apiserver，避免为 Secret、webhook 配置和 CRD 建立缓存
+	Client client.Client
+
+	// SecretKey is the Secret holding the CA and the serving certificate.
+	SecretKey client.ObjectKey
+	// CertDir is the directory the webhook server loads tls.crt and tls.key from.
+	CertDir string
+	// DNSNames of the webhook Service the serving certificate is issued for.
+	DNSNames []string
+	// MutatingWebhookConfiguration to inject the CA into, skipped when empty.
+	MutatingWebhookConfiguration string
+	// ValidatingWebhookConfiguration to inject the CA into, skipped when empty.
+	ValidatingWebhookConfiguration string
+	// CRDs whose conversion webhook the CA is injected into.
+	CRDs []string
+
+	// CAValidity is how long a generated CA is valid.
+	CAValidity time.Duration
+	// CertValidity is how long a generated serving certificate is valid.
+	CertValidity time.Duration
+	// RotateBefore is how long before expiry certificates are rotated.
+	RotateBefore time.Duration
+	// Interval is how often certificates are checked, DefaultCheckInterval when zero.
+	Interval time.Duration
+
+	// now 用于测试
+	now func() time.Time
+}
+
+var _ manager.Runnable = &Provisioner{}
+var _ manager.LeaderElectionRunnable = &Provisioner{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. 每个副本都需要将服务证书写入本地目录
+func (p *Provisioner) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable. Manager 启动前需要先调用一次 Provision，否则 webhook server 找不到证书
+func (p *Provisioner) Start(ctx context.Context) error {
+	logger := ctrl.Log.WithName("certs")
+	interval := p.Interval
+	if interval == 0 {
+		interval = DefaultCheckInterval
+	}
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := p.Provision(ctx); err != nil {
+			logger.Error(err, "Failed to provision webhook certificates, will retry after a short time.")
+		}
+	}, interval)
+	return nil
+}
+
+// Provision 确保 Secret 中的证书有效，并将其注入 webhook 配置和 CRD、写入 CertDir
+// 多个副本同时签发证书时只有一个能写入 Secret，其余副本在冲突后重新读取
+func (p *Provisioner) Provision(ctx context.Context) error {
+	var secret *corev1.Secret
+	err := retry.OnError(retry.DefaultRetry, func(err error) bool {
+		return errors.IsConflict(err) || errors.IsAlreadyExists(err)
+	}, func() error {
+		var err error
+		secret, err = p.ensureSecret(ctx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// 先注入 CA 再写入服务证书：轮换 CA 后 apiserver 必须先信任新的 CA，webhook server 才能加载由它签发的证书
+	if err := p.injectCABundle(ctx, secret.Data[CAKey]); err != nil {
+		return err
+	}
+	return p.writeCertDir(secret)
+}
+
+// ensureSecret 读取 Secret 并在证书缺失、无效或即将过期时重新签发
+func (p *Provisioner) ensureSecret(ctx context.Context) (*corev1.Secret, error) {
+	logger := ctrl.Log.WithName("certs").WithValues("secret", p.SecretKey)
+	now := p.clock()
+
+	secret := &corev1.Secret{}
+	exists := true
+	if err := p.Client.Get(ctx, p.SecretKey, secret); err != nil {
+		if !errors.IsNotFound(err) {
+			return nil, err
+		}
+		exists = false
+		secret = &corev1.Secret{}
+		secret.Namespace, secret.Name = p.SecretKey.Namespace, p.SecretKey.Name
+		secret.Type = corev1.SecretTypeTLS
+	}
+
+	data := secret.Data
+	if data == nil {
+		data = map[string][]byte{}
+	}
+
+	// CA 无效或即将过期时签发新的 CA，仍在有效期内的旧 CA 继续保留在 ca.crt 中
+	ca, err := parseKeyPair(data[CAKey], data[CAPrivateKey])
+	rotateCA := err != nil || !ca.Cert.IsCA || needsRotation(ca.Cert, p.RotateBefore, now)
+	if rotateCA {
+		if ca, err = newCA(p.SecretKey.Name+"-ca", p.CAValidity, now); err != nil {
+			return nil, fmt.Errorf("generate CA: %w", err)
+		}
+		logger.Info("A new webhook CA has been generated.", "notAfter", ca.Cert.NotAfter)
+	}
+	bundle := []*x509.Certificate{ca.Cert}
+	for _, cert := range parseCertificates(data[CAKey]) {
+		if !cert.Equal(ca.Cert) && cert.IsCA && now.Before(cert.NotAfter) {
+			bundle = append(bundle, cert)
+		}
+	}
+
+	serving, err := parseKeyPair(data[corev1.TLSCertKey], data[corev1.TLSPrivateKeyKey])
+	rotateCert := rotateCA || err != nil || needsRotation(serving.Cert, p.RotateBefore, now) ||
+		verifyServingCert(serving.Cert, ca.Cert, p.DNSNames, now) != nil
+	if rotateCert {
+		if serving, err = newServingCert(ca, p.DNSNames, p.CertValidity, now); err != nil {
+			return nil, fmt.Errorf("generate serving certificate: %w", err)
+		}
+		logger.Info("A new webhook serving certificate has been generated.", "notAfter", serving.Cert.NotAfter)
+	}
+
+	caPEM := encodeCertificates(bundle)
+	if exists && !rotateCert && bytes.Equal(caPEM, data[CAKey]) {
+		return secret, nil
+	}
+
+	secret.Data = map[string][]byte{
+		CAKey:                   caPEM,
+		CAPrivateKey:            ca.KeyPEM,
+		corev1.TLSCertKey:       serving.CertPEM,
+		corev1.TLSPrivateKeyKey: serving.KeyPEM,
+	}
+	if !exists {
+		return secret, p.Client.Create(ctx, secret)
+	}
+	return secret, p.Client.Update(ctx, secret)
+}
+
+// writeCertDir 将服务证书写入 CertDir，webhook server 监听文件变化并自动加载新证书
+// 先写私钥再写证书，证书写入完成后触发的事件读取到的总是匹配的一对
+func (p *Provisioner) writeCertDir(secret *corev1.Secret) error {
+	if err := os.MkdirAll(p.CertDir, 0o700); err != nil {
+		return err
+	}
+	for _, key := range []string{corev1.TLSPrivateKeyKey, corev1.TLSCertKey} {
+		path := filepath.Join(p.CertDir, key)
+		if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, secret.Data[key]) {
+			continue
+		}
+		if err := os.WriteFile(path, secret.Data[key], 0o600); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// injectCABundle 将 CA 写入 webhook 配置和 CRD 的 conversion webhook
+func (p *Provisioner) injectCABundle(ctx context.Context, caBundle []byte) error {
+	if name := p.MutatingWebhookConfiguration; name != "" {
+		config := &admissionregistrationv1.MutatingWebhookConfiguration{}
+		if err := p.Client.Get(ctx, client.ObjectKey{Name: name}, config); err != nil {
+			return err
+		}
+		patch := client.MergeFrom(config.DeepCopy())
+		for i := range config.Webhooks {
+			config.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if err := p.patchIfChanged(ctx, config, patch); err != nil {
+			return err
+		}
+	}
+
+	if name := p.ValidatingWebhookConfiguration; name != "" {
+		config := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+		if err := p.Client.Get(ctx, client.ObjectKey{Name: name}, config); err != nil {
+			return err
+		}
+		patch := client.MergeFrom(config.DeepCopy())
+		for i := range config.Webhooks {
+			config.Webhooks[i].ClientConfig.CABundle = caBundle
+		}
+		if err := p.patchIfChanged(ctx, config, patch); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range p.CRDs {
+		crd := &apiextensionsv1.CustomResourceDefinition{}
+		if err := p.Client.Get(ctx, client.ObjectKey{Name: name}, crd); err != nil {
+			return err
+		}
+		conversion := crd.Spec.Conversion
+		if conversion == nil || conversion.Webhook == nil || conversion.Webhook.ClientConfig == nil {
+			continue
+		}
+		patch := client.MergeFrom(crd.DeepCopy())
+		conversion.Webhook.ClientConfig.CABundle = caBundle
+		if err := p.patchIfChanged(ctx, crd, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchIfChanged 只在 CA 发生变化时发送 patch
+func (p *Provisioner) patchIfChanged(ctx context.Context, obj client.Object, patch client.Patch) error {
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	if string(data) == "{}" {
+		return nil
+	}
+	if err := p.Client.Patch(ctx, obj, patch); err != nil {
+		return fmt.Errorf("inject CA into %T %s: %w", obj, obj.GetName(), err)
+	}
+	ctrl.Log.WithName("certs").Info("The webhook CA has been injected.", "kind", fmt.Sprintf("%T", obj), "name", obj.GetName())
+	return nil
+}
+
+func (p *Provisioner) clock() time.Time {
+	if p.now != nil {
+		return p.now()
+	}
+	return time.Now()
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package certs
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func newTestProvisioner(t *testing.T, now *time.Time) *Provisioner {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := apiextensionsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	objects := []client.Object{
+		&admissionregistrationv1.MutatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "mutating"},
+			Webhooks:   []admissionregistrationv1.MutatingWebhook{{Name: "mapplication.kb.io"}},
+		},
+		&admissionregistrationv1.ValidatingWebhookConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "validating"},
+			Webhooks:   []admissionregistrationv1.ValidatingWebhook{{Name: "vapplication.kb.io"}},
+		},
+		&apiextensionsv1.CustomResourceDefinition{
+			ObjectMeta: metav1.ObjectMeta{Name: "applications.apps.clusterops.io"},
+			Spec: apiextensionsv1.CustomResourceDefinitionSpec{
+				Conversion: &apiextensionsv1.CustomResourceConversion{
+					Strategy: apiextensionsv1.WebhookConverter,
+					Webhook:  &apiextensionsv1.WebhookConversion{ClientConfig: &apiextensionsv1.WebhookClientConfig{}},
+				},
+			},
+		},
+	}
+
+	return &Provisioner{
+		Client:                         fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).Build(),
+		SecretKey:                      client.ObjectKey{Namespace: "system", Name: "webhook-server-cert"},
+		CertDir:                        t.TempDir(),
+		DNSNames:                       []string{"webhook-service.system.svc", "webhook-service.system.svc.cluster.local"},
+		MutatingWebhookConfiguration:   "mutating",
+		ValidatingWebhookConfiguration: "validating",
+		CRDs:                           []string{"applications.apps.clusterops.io"},
+		CAValidity:                     10 * 365 * 24 * time.Hour,
+		CertValidity:                   365 * 24 * time.Hour,
+		RotateBefore:                   30 * 24 * time.Hour,
+		now:                            func() time.Time { return *now },
+	}
+}
+
+func getSecret(t *testing.T, p *Provisioner) *corev1.Secret {
+	t.Helper()
+	secret := &corev1.Secret{}
+	if err := p.Client.Get(context.Background(), p.SecretKey, secret); err != nil {
+		t.Fatalf("get Secret: %v", err)
+	}
+	return secret
+}
+
+// checkInjected 确认 CertDir 中的证书与 Secret 一致，且所有 webhook 配置和 CRD 都注入了 CA
+func checkInjected(t *testing.T, p *Provisioner, secret *corev1.Secret) {
+	t.Helper()
+	ctx := context.Background()
+	for _, key := range []string{corev1.TLSCertKey, corev1.TLSPrivateKeyKey} {
+		data, err := os.ReadFile(filepath.Join(p.CertDir, key))
+		if err != nil || !bytes.Equal(data, secret.Data[key]) {
+			t.Fatalf("%s in CertDir does not match the Secret: %v", key, err)
+		}
+	}
+
+	caBundle := secret.Data[CAKey]
+	mutating := &admissionregistrationv1.MutatingWebhookConfiguration{}
+	if err := p.Client.Get(ctx, client.ObjectKey{Name: "mutating"}, mutating); err != nil {
+		t.Fatal(err)
+	}
+	validating := &admissionregistrationv1.ValidatingWebhookConfiguration{}
+	if err := p.Client.Get(ctx, client.ObjectKey{Name: "validating"}, validating); err != nil {
+		t.Fatal(err)
+	}
+	crd := &apiextensionsv1.CustomResourceDefinition{}
+	if err := p.Client.Get(ctx, client.ObjectKey{Name: "applications.apps.clusterops.io"}, crd); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(mutating.Webhooks[0].ClientConfig.CABundle, caBundle) ||
+		!bytes.Equal(validating.Webhooks[0].ClientConfig.CABundle, caBundle) ||
+		!bytes.Equal(crd.Spec.Conversion.Webhook.ClientConfig.CABundle, caBundle) {
+		t.Fatalf("the CA bundle has not been injected everywhere")
+	}
+}
+
+func TestProvision(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	p := newTestProvisioner(t, &now)
+
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	secret := getSecret(t, p)
+	checkInjected(t, p, secret)
+
+	serving, err := parseKeyPair(secret.Data[corev1.TLSCertKey], secret.Data[corev1.TLSPrivateKeyKey])
+	if err != nil {
+		t.Fatalf("parse serving certificate: %v", err)
+	}
+	cas := parseCertificates(secret.Data[CAKey])
+	if len(cas) != 1 {
+		t.Fatalf("expected 1 CA, got %d", len(cas))
+	}
+	if err := verifyServingCert(serving.Cert, cas[0], p.DNSNames, now); err != nil {
+		t.Fatalf("invalid serving certificate: %v", err)
+	}
+
+	// 证书有效时不重新签发
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision again: %v", err)
+	}
+	if again := getSecret(t, p); !bytes.Equal(again.Data[corev1.TLSCertKey], secret.Data[corev1.TLSCertKey]) {
+		t.Fatalf("the serving certificate was rotated although it is valid")
+	}
+}
+
+func TestProvisionRotatesServingCert(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	p := newTestProvisioner(t, &now)
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	before := getSecret(t, p)
+
+	now = now.Add(p.CertValidity - p.RotateBefore + time.Hour)
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	after := getSecret(t, p)
+	checkInjected(t, p, after)
+
+	if bytes.Equal(after.Data[corev1.TLSCertKey], before.Data[corev1.TLSCertKey]) {
+		t.Fatalf("the serving certificate was not rotated before expiry")
+	}
+	if !bytes.Equal(after.Data[CAKey], before.Data[CAKey]) {
+		t.Fatalf("the CA must not change while it is valid")
+	}
+}
+
+func TestProvisionRotatesCA(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	p := newTestProvisioner(t, &now)
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	oldCA := parseCertificates(getSecret(t, p).Data[CAKey])[0]
+
+	// 新旧 CA 在旧 CA 过期前同时保留，旧证书在轮换期间仍然可以通过校验
+	now = now.Add(p.CAValidity - p.RotateBefore + time.Hour)
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	secret := getSecret(t, p)
+	checkInjected(t, p, secret)
+	cas := parseCertificates(secret.Data[CAKey])
+	if len(cas) != 2 || cas[0].Equal(oldCA) || !cas[1].Equal(oldCA) {
+		t.Fatalf("expected the new CA followed by the old CA, got %d CAs", len(cas))
+	}
+
+	// 旧 CA 过期后从 CA bundle 中移除
+	now = oldCA.NotAfter.Add(time.Hour)
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	if cas := parseCertificates(getSecret(t, p).Data[CAKey]); len(cas) != 1 || cas[0].Equal(oldCA) {
+		t.Fatalf("the expired CA was not removed from the CA bundle")
+	}
+}
+
+func TestProvisionInjectsCABeforeWritingCertDir(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	p := newTestProvisioner(t, &now)
+	if err := p.Provision(ctx); err != nil {
+		t.Fatalf("provision: %v", err)
+	}
+	servingCert, err := os.ReadFile(filepath.Join(p.CertDir, corev1.TLSCertKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 轮换 CA 时注入失败，webhook server 必须继续使用 apiserver 信任的旧证书
+	p.Client = interceptor.NewClient(p.Client.(client.WithWatch), interceptor.Funcs{
+		Patch: func(ctx context.Context, c client.WithWatch, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+			return errors.New("patch is not allowed")
+		},
+	})
+	now = now.Add(p.CAValidity - p.RotateBefore + time.Hour)
+	if err := p.Provision(ctx); err == nil {
+		t.Fatalf("provision must fail when the CA cannot be injected")
+	}
+	data, err := os.ReadFile(filepath.Join(p.CertDir, corev1.TLSCertKey))
+	if err != nil || !bytes.Equal(data, servingCert) {
+		t.Fatalf("the serving certificate was replaced before the new CA was injected: %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/sharding"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+	webhookappsv1 "github.com/ahwhy/clusterops-operator/internal/webhook/v1"
+)
+
+const (
+	// DefaultLeaderElectionID 是默认的 leader election Lease 名称
+	DefaultLeaderElectionID = "55451705.clusterops.io"
+	// DefaultWebhookPort 是 webhook server 默认监听的端口
+	DefaultWebhookPort = 9443
+	// DefaultShardGroup 是默认的分片组名称
+	DefaultShardGroup = "clusterops-operator"
+)
+
+// New 返回全部使用默认值的配置
+func New() *ManagerConfig {
+	cfg := &ManagerConfig{}
+	SetDefaults(cfg)
+	return cfg
+}
+
+// SetDefaults 为未设置的字段填充默认值，默认值与引入配置文件之前的命令行参数和 controller-runtime 的默认值保持一致
+func SetDefaults(cfg *ManagerConfig) {
+	if cfg.APIVersion == "" {
+		cfg.APIVersion = APIVersion
+	}
+	if cfg.Kind == "" {
+		cfg.Kind = Kind
+	}
+
+	if cfg.Metrics.BindAddress == "" {
+		cfg.Metrics.BindAddress = ":8080"
+	}
+	if cfg.Health.BindAddress == "" {
+		cfg.Health.BindAddress = ":8081"
+	}
+	if cfg.Webhook.Port == 0 {
+		cfg.Webhook.Port = DefaultWebhookPort
+	}
+
+	provisioner := &cfg.Webhook.CertProvisioner
+	if provisioner.SecretName == "" {
+		provisioner.SecretName = "clusterops-operator-webhook-server-cert"
+	}
+	if provisioner.ServiceName == "" {
+		provisioner.ServiceName = "clusterops-operator-webhook-service"
+	}
+	if provisioner.MutatingWebhookConfiguration == "" {
+		provisioner.MutatingWebhookConfiguration = "clusterops-operator-mutating-webhook-configuration"
+	}
+	if provisioner.ValidatingWebhookConfiguration == "" {
+		provisioner.ValidatingWebhookConfiguration = "clusterops-operator-validating-webhook-configuration"
+	}
+	if provisioner.CRDs == nil {
+		provisioner.CRDs = []string{"applications.apps.clusterops.io"}
+	}
+	setDefaultDuration(&provisioner.CAValidity, 10*365*24*time.Hour)
+	setDefaultDuration(&provisioner.CertValidity, 365*24*time.Hour)
+	setDefaultDuration(&provisioner.RotateBefore, 30*24*time.Hour)
+
+	traces := &cfg.Tracing
+	if traces.Exporter == "" {
+		traces.Exporter = string(tracing.ExporterNone)
+	}
+	if traces.Endpoint == "" {
+		traces.Endpoint = tracing.DefaultOTLPEndpoint
+	}
+	if traces.ServiceName == "" {
+		traces.ServiceName = "clusterops-operator"
+	}
+	if traces.SampleRatio == nil {
+		ratio := 1.0
+		traces.SampleRatio = &ratio
+	}
+
+	if cfg.Audit.Sink == "" {
+		cfg.Audit.Sink = string(audit.SinkNone)
+	}
+
+	election := &cfg.LeaderElection
+	if election.ResourceName == "" {
+		election.ResourceName = DefaultLeaderElectionID
+	}
+	setDefaultDuration(&election.LeaseDuration, 15*time.Second)
+	setDefaultDuration(&election.RenewDeadline, 10*time.Second)
+	setDefaultDuration(&election.RetryPeriod, 2*time.Second)
+	if election.ReleaseOnCancel == nil {
+		releaseOnCancel := true
+		election.ReleaseOnCancel = &releaseOnCancel
+	}
+	setDefaultDuration(&cfg.GracefulShutdownTimeout, 30*time.Second)
+
+	setControllerDefaults(&cfg.Controllers.Application)
+	setControllerDefaults(&cfg.Controllers.ApplicationSet)
+
+	shard := &cfg.Sharding
+	if shard.Group == "" {
+		shard.Group = DefaultShardGroup
+	}
+	setDefaultDuration(&shard.LeaseDuration, 15*time.Second)
+	setDefaultDuration(&shard.RenewInterval, 5*time.Second)
+	if shard.VirtualNodes == 0 {
+		shard.VirtualNodes = sharding.DefaultVirtualNodes
+	}
+
+	if cfg.Admission.Defaults == nil {
+		cfg.Admission.Defaults = webhookappsv1.NewApplicationDefaults()
+	}
+}
+
+// setControllerDefaults 与 workqueue.DefaultControllerRateLimiter 的取值保持一致
+func setControllerDefaults(c *ControllerConfig) {
+	if c.MaxConcurrentReconciles == 0 {
+		c.MaxConcurrentReconciles = 1
+	}
+	setDefaultDuration(&c.RateLimiter.BaseDelay, 5*time.Millisecond)
+	setDefaultDuration(&c.RateLimiter.MaxDelay, 1000*time.Second)
+	if c.RateLimiter.QPS == 0 {
+		c.RateLimiter.QPS = 10
+	}
+	if c.RateLimiter.Burst == 0 {
+		c.RateLimiter.Burst = 100
+	}
+}
+
+func setDefaultDuration(d *metav1.Duration, value time.Duration) {
+	if d.Duration == 0 {
+		d.Duration = value
+	}
+}
@@ -0,0 +1,333 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+
+	metav1validation "k8s.io/apimachinery/pkg/apis/meta/v1/validation"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/featuregate"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+)
+
+// Load 解析 YAML 格式的配置，未声明的字段使用默认值，配置不合法时返回所有错误
+func Load(data []byte) (*ManagerConfig, error) {
+	// 在默认配置之上解析，未声明的嵌套字段(例如 admission.defaults 中的部分字段)同样保留默认值
+	cfg := New()
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, fmt.Errorf("parse manager config: %w", err)
+	}
+	SetDefaults(cfg)
+	if err := Validate(cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// LoadFile 读取并解析配置文件
+func LoadFile(path string) (*ManagerConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Load(data)
+}
+
+// Validate 校验配置，返回的错误包含每个不合法字段的路径
+func Validate(cfg *ManagerConfig) error {
+	var allErrs field.ErrorList
+
+	if cfg.APIVersion != APIVersion {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("apiVersion"), cfg.APIVersion, []string{APIVersion}))
+	}
+	if cfg.Kind != Kind {
+		allErrs = append(allErrs, field.NotSupported(field.NewPath("kind"), cfg.Kind, []string{Kind}))
+	}
+
+	if cfg.Health.BindAddress == "0" {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("health", "bindAddress"), cfg.Health.BindAddress,
+			"the health probe endpoint must not be disabled"))
+	}
+	if port := cfg.Webhook.Port; port < 1 || port > 65535 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("webhook", "port"), port, "must be between 1 and 65535"))
+	}
+
+	allErrs = append(allErrs, validateDebug(&cfg.Debug, field.NewPath("debug"))...)
+	allErrs = append(allErrs, validateTracing(&cfg.Tracing, field.NewPath("tracing"))...)
+	allErrs = append(allErrs, validateAudit(&cfg.Audit, field.NewPath("audit"))...)
+	allErrs = append(allErrs, validateCertProvisioner(&cfg.Webhook.CertProvisioner, field.NewPath("webhook", "certProvisioner"))...)
+	allErrs = append(allErrs, validateLeaderElection(&cfg.LeaderElection, field.NewPath("leaderElection"))...)
+	if cfg.GracefulShutdownTimeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(field.NewPath("gracefulShutdownTimeout"), cfg.GracefulShutdownTimeout.Duration.String(),
+			"must not be negative"))
+	}
+
+	namespacesPath := field.NewPath("cache", "namespaces")
+	seen := sets.New[string]()
+	for i, namespace := range cfg.Cache.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			allErrs = append(allErrs, field.Invalid(namespacesPath.Index(i), namespace, msg))
+		}
+		if seen.Has(namespace) {
+			allErrs = append(allErrs, field.Duplicate(namespacesPath.Index(i), namespace))
+		}
+		seen.Insert(namespace)
+	}
+
+	allErrs = append(allErrs, metav1validation.ValidateLabelSelector(cfg.Cache.NamespaceSelector,
+		metav1validation.LabelSelectorValidationOptions{}, field.NewPath("cache", "namespaceSelector"))...)
+
+	controllersPath := field.NewPath("controllers")
+	allErrs = append(allErrs, validateController(&cfg.Controllers.Application, controllersPath.Child("application"))...)
+	allErrs = append(allErrs, validateController(&cfg.Controllers.ApplicationSet, controllersPath.Child("applicationSet"))...)
+
+	allErrs = append(allErrs, validateSharding(&cfg.Sharding, cfg.LeaderElection.LeaderElect, field.NewPath("sharding"))...)
+	allErrs = append(allErrs, validateAdmission(&cfg.Admission, field.NewPath("admission"))...)
+
+	allErrs = append(allErrs, featuregate.Default.Validate(cfg.FeatureGates, field.NewPath("featureGates"))...)
+
+	return allErrs.ToAggregate()
+}
+
+// validateLeaderElection 与 client-go leaderelection 的要求保持一致：leaseDuration > renewDeadline > 1.2 * retryPeriod
+func validateLeaderElection(c *LeaderElectionConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if !c.LeaderElect {
+		return allErrs
+	}
+
+	if c.ResourceName == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("resourceName"), "required when leader election is enabled"))
+	}
+	if c.LeaseDuration.Duration <= c.RenewDeadline.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("leaseDuration"), c.LeaseDuration.Duration.String(),
+			"must be greater than renewDeadline"))
+	}
+	if float64(c.RenewDeadline.Duration) <= 1.2*float64(c.RetryPeriod.Duration) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("renewDeadline"), c.RenewDeadline.Duration.String(),
+			"must be greater than 1.2 times retryPeriod"))
+	}
+	if c.RetryPeriod.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("retryPeriod"), c.RetryPeriod.Duration.String(), "must be greater than 0"))
+	}
+	return allErrs
+}
+
+func validateController(c *ControllerConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if c.MaxConcurrentReconciles < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxConcurrentReconciles"), c.MaxConcurrentReconciles, "must be greater than 0"))
+	}
+
+	limiterPath := fldPath.Child("rateLimiter")
+	limiter := &c.RateLimiter
+	if limiter.BaseDelay.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(limiterPath.Child("baseDelay"), limiter.BaseDelay.Duration.String(), "must be greater than 0"))
+	}
+	if limiter.MaxDelay.Duration < limiter.BaseDelay.Duration {
+		allErrs = append(allErrs, field.Invalid(limiterPath.Child("maxDelay"), limiter.MaxDelay.Duration.String(),
+			"must be greater than or equal to baseDelay"))
+	}
+	if limiter.QPS <= 0 {
+		allErrs = append(allErrs, field.Invalid(limiterPath.Child("qps"), limiter.QPS, "must be greater than 0"))
+	}
+	if limiter.Burst < 1 {
+		allErrs = append(allErrs, field.Invalid(limiterPath.Child("burst"), limiter.Burst, "must be greater than 0"))
+	}
+	return allErrs
+}
+
+// validateDebug 要求管理端点只绑定到 localhost，或者通过 token 认证，pprof 和期望状态中可能包含敏感信息
+func validateDebug(c *DebugConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if c.BindAddress == "" {
+		return allErrs
+	}
+
+	host, _, err := net.SplitHostPort(c.BindAddress)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath.Child("bindAddress"), c.BindAddress, err.Error()))
+	}
+	ip := net.ParseIP(host)
+	if host != "localhost" && (ip == nil || !ip.IsLoopback()) && c.TokenFile == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("tokenFile"),
+			"required when the debug endpoints bind to an address other than localhost"))
+	}
+	return allErrs
+}
+
+// validateTracing 检查导出器所需的字段，OTLP 地址需要包含协议，例如 http://otel-collector:4318
+func validateTracing(c *TracingConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	switch tracing.Exporter(c.Exporter) {
+	case tracing.ExporterNone, tracing.ExporterStdout:
+	case tracing.ExporterFile:
+		if c.File == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("file"), "required by the file exporter"))
+		}
+	case tracing.ExporterOTLP:
+		if u, err := url.Parse(c.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("endpoint"), c.Endpoint, "must be an http or https URL"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("exporter"), c.Exporter, []string{
+			string(tracing.ExporterNone), string(tracing.ExporterStdout), string(tracing.ExporterFile), string(tracing.ExporterOTLP),
+		}))
+	}
+	if c.SampleRatio != nil && (*c.SampleRatio < 0 || *c.SampleRatio > 1) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("sampleRatio"), *c.SampleRatio, "must be between 0 and 1"))
+	}
+	return allErrs
+}
+
+// validateAudit 要求 file 类型的 sink 指定文件路径
+func validateAudit(c *AuditConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	switch audit.Sink(c.Sink) {
+	case audit.SinkNone, audit.SinkStdout:
+	case audit.SinkFile:
+		if c.Path == "" {
+			allErrs = append(allErrs, field.Required(fldPath.Child("path"), "required by the file sink"))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("sink"), c.Sink, []string{
+			string(audit.SinkNone), string(audit.SinkStdout), string(audit.SinkFile),
+		}))
+	}
+	return allErrs
+}
+
+// validateCertProvisioner 要求证书在轮换前有足够的有效期，否则每次检查都会重新签发
+func validateCertProvisioner(c *CertProvisionerConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if !c.Enabled {
+		return allErrs
+	}
+
+	for _, name := range []struct {
+		path  string
+		value string
+	}{
+		{path: "secretName", value: c.SecretName},
+		{path: "serviceName", value: c.ServiceName},
+	} {
+		for _, msg := range validation.IsDNS1123Label(name.value) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(name.path), name.value, msg))
+		}
+	}
+	if c.RotateBefore.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("rotateBefore"), c.RotateBefore.Duration.String(), "must be greater than 0"))
+	}
+	if c.CertValidity.Duration <= c.RotateBefore.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("certValidity"), c.CertValidity.Duration.String(),
+			"must be greater than rotateBefore"))
+	}
+	if c.CAValidity.Duration < c.CertValidity.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("caValidity"), c.CAValidity.Duration.String(),
+			"must be greater than or equal to certValidity"))
+	}
+	return allErrs
+}
+
+// validateSharding 校验分片配置，成员的 Lease 以 <group>-<identity> 命名，两者组合后必须是合法的资源名称
+// 分片只作用于 Application 控制器，ApplicationSet 控制器等仍依赖选主保证只有一个副本运行，因此必须同时启用选主
+func validateSharding(c *ShardingConfig, leaderElect bool, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if !c.Enabled {
+		return allErrs
+	}
+
+	if !leaderElect {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("enabled"), c.Enabled,
+			"requires leaderElection.leaderElect, controllers other than the Application controller are not sharded"))
+	}
+
+	for _, msg := range validation.IsDNS1123Label(c.Group) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("group"), c.Group, msg))
+	}
+	if c.Identity != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(c.Group + "-" + c.Identity) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("identity"), c.Identity, msg))
+		}
+	}
+	if c.LeaseNamespace != "" {
+		for _, msg := range validation.IsDNS1123Label(c.LeaseNamespace) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("leaseNamespace"), c.LeaseNamespace, msg))
+		}
+	}
+	if c.RenewInterval.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("renewInterval"), c.RenewInterval.Duration.String(), "must be greater than 0"))
+	}
+	if c.LeaseDuration.Duration <= c.RenewInterval.Duration {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("leaseDuration"), c.LeaseDuration.Duration.String(),
+			"must be greater than renewInterval"))
+	}
+	if c.VirtualNodes < 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("virtualNodes"), c.VirtualNodes, "must be greater than 0"))
+	}
+	return allErrs
+}
+
+func validateAdmission(c *AdmissionConfig, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if c.Defaults != nil && c.Defaults.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("defaults", "replicas"), c.Defaults.Replicas,
+			"must be greater than or equal to 0"))
+	}
+	for i, registry := range c.AllowedRegistries {
+		if registry == "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("allowedRegistries").Index(i), registry, "must not be empty"))
+		}
+	}
+	for i, user := range c.DeletionProtectionExemptUsers {
+		if user == "" {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("deletionProtectionExemptUsers").Index(i), user, "must not be empty"))
+		}
+	}
+	for i, pattern := range c.AllowedEnvironmentNamespaces {
+		if _, err := path.Match(pattern, ""); pattern == "" || err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("allowedEnvironmentNamespaces").Index(i), pattern,
+				"must be a non-empty path.Match pattern"))
+		}
+	}
+
+	if policy := c.DefaultPolicy; policy != nil {
+		policyPath := fldPath.Child("defaultPolicy")
+		if policy.MaxReplicas != nil && *policy.MaxReplicas < 0 {
+			allErrs = append(allErrs, field.Invalid(policyPath.Child("maxReplicas"), *policy.MaxReplicas,
+				"must be greater than or equal to 0"))
+		}
+		switch policy.EnforcementMode {
+		case "", appsv1.EnforcementModeEnforce, appsv1.EnforcementModeWarn, appsv1.EnforcementModeAudit:
+		default:
+			allErrs = append(allErrs, field.NotSupported(policyPath.Child("enforcementMode"), policy.EnforcementMode, []string{
+				string(appsv1.EnforcementModeEnforce), string(appsv1.EnforcementModeWarn), string(appsv1.EnforcementModeAudit)}))
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := Load([]byte(`
+apiVersion: config.clusterops.io/v1alpha1
+kind: ManagerConfig
+controllers:
+  application:
+    maxConcurrentReconciles: 4
+admission:
+  defaults:
+    replicas: 2
+`))
+	if err != nil {
+		t.Fatalf("load config: %v", err)
+	}
+
+	if cfg.Webhook.Port != DefaultWebhookPort || cfg.LeaderElection.ResourceName != DefaultLeaderElectionID {
+		t.Errorf("expected defaults for unset fields, got %+v", cfg)
+	}
+	if cfg.Controllers.Application.MaxConcurrentReconciles != 4 || cfg.Controllers.ApplicationSet.MaxConcurrentReconciles != 1 {
+		t.Errorf("unexpected controllers config: %+v", cfg.Controllers)
+	}
+	if cfg.Controllers.Application.RateLimiter.MaxDelay.Duration != 1000*time.Second {
+		t.Errorf("expected the default maxDelay, got %s", cfg.Controllers.Application.RateLimiter.MaxDelay.Duration)
+	}
+	if cfg.LeaderElection.ReleaseOnCancel == nil || !*cfg.LeaderElection.ReleaseOnCancel || cfg.GracefulShutdownTimeout.Duration != 30*time.Second {
+		t.Errorf("expected the Lease to be released after a graceful shutdown by default, got %+v", cfg.LeaderElection)
+	}
+	// admission.defaults 中未声明的字段沿用内置的默认值
+	if defaults := cfg.Admission.Defaults; defaults.Replicas != 2 || defaults.Strategy == nil {
+		t.Errorf("unexpected admission defaults: %+v", defaults)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "unknown field",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\nunknown: true\n",
+			want: "unknown field",
+		},
+		{
+			name: "unsupported version",
+			data: "apiVersion: config.clusterops.io/v1\nkind: ManagerConfig\n",
+			want: "apiVersion",
+		},
+		{
+			name: "leader election timings",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\nleaderElection:\n  leaderElect: true\n  leaseDuration: 5s\n",
+			want: "leaderElection.leaseDuration",
+		},
+		{
+			name: "invalid namespace",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\ncache:\n  namespaces: [Team_A]\n",
+			want: "cache.namespaces[0]",
+		},
+		{
+			name: "invalid namespace selector",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\ncache:\n  namespaceSelector:\n    matchExpressions:\n    - {key: team, operator: Has}\n",
+			want: "cache.namespaceSelector",
+		},
+		{
+			name: "sharding without leader election",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\nsharding:\n  enabled: true\n",
+			want: "sharding.enabled",
+		},
+		{
+			name: "sharding timings",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\nsharding:\n  enabled: true\n  leaseDuration: 5s\n",
+			want: "sharding.leaseDuration",
+		},
+		{
+			name: "debug endpoints without authentication",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\ndebug:\n  bindAddress: :8082\n",
+			want: "debug.tokenFile",
+		},
+		{
+			name: "file exporter without a file",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\ntracing:\n  exporter: file\n",
+			want: "tracing.file",
+		},
+		{
+			name: "audit file sink without a path",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\naudit:\n  sink: file\n",
+			want: "audit.path",
+		},
+		{
+			name: "unknown feature gate",
+			data: "apiVersion: config.clusterops.io/v1alpha1\nkind: ManagerConfig\nfeatureGates:\n  Unknown: true\n",
+			want: "featureGates[Unknown]",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	if _, err := LoadFile("../../config/manager/manager_config.yaml"); err != nil {
+		t.Fatalf("load the config shipped with the manifests: %v", err)
+	}
+}
@@ -0,0 +1,284 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/certs"
+	"github.com/ahwhy/clusterops-operator/internal/debug"
+	"github.com/ahwhy/clusterops-operator/internal/image"
+	"github.com/ahwhy/clusterops-operator/internal/sharding"
+	"github.com/ahwhy/clusterops-operator/internal/shutdown"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+	webhookappsv1 "github.com/ahwhy/clusterops-operator/internal/webhook/v1"
+)
+
+// ManagerOptions 将配置转换为 Manager 的参数，namespaces 为 Cache.WatchedNamespaces 解析出的命名空间
+func (c *ManagerConfig) ManagerOptions(scheme *runtime.Scheme, namespaces []string) ctrl.Options {
+	election := c.LeaderElection
+	// shutdown.Drainer 最多等待 gracefulShutdownTimeout 后还要导出链路追踪和审计日志，Manager 的等待时间需要包含导出的时间，
+	// 否则等待超时时 Manager 先于 Drainer 返回错误，导出与 main 的退出流程相互竞争
+	gracePeriod := c.GracefulShutdownTimeout.Duration + shutdown.FlushTimeout
+	return ctrl.Options{
+		Scheme:                  scheme,
+		MetricsBindAddress:      c.Metrics.BindAddress,
+		HealthProbeBindAddress:  c.Health.BindAddress,
+		LeaderElection:          election.LeaderElect,
+		LeaderElectionID:        election.ResourceName,
+		LeaderElectionNamespace: election.ResourceNamespace,
+		LeaseDuration:           &election.LeaseDuration.Duration,
+		RenewDeadline:           &election.RenewDeadline.Duration,
+		RetryPeriod:             &election.RetryPeriod.Duration,
+		// Manager 在所有 leader election runnable(包括控制器和 shutdown.Drainer)停止后才释放 Lease，
+		// main 在 Manager 退出后只导出剩余的 span 和审计日志，不再发起变更，因此可以安全地提前释放
+		LeaderElectionReleaseOnCancel: election.ReleaseOnCancel == nil || *election.ReleaseOnCancel,
+		GracefulShutdownTimeout:       &gracePeriod,
+		WebhookServer: webhook.NewServer(webhook.Options{
+			Host:    c.Webhook.Host,
+			Port:    c.Webhook.Port,
+			CertDir: c.Webhook.CertDir,
+		}),
+		Cache: cache.Options{
+			Namespaces: namespaces,
+		},
+	}
+}
+
+// NamespaceScoped 判断 Manager 是否只监听部分命名空间
+func (c *CacheConfig) NamespaceScoped() bool {
+	return len(c.Namespaces) > 0 || c.NamespaceSelector != nil
+}
+
+// WatchedNamespaces 返回 Manager 监听的命名空间，合并 namespaces 与当前匹配 namespaceSelector 的命名空间
+// 监听整个集群时返回 nil；只监听部分命名空间却没有匹配到任何命名空间时返回错误，避免退化为监听整个集群
+func (c *CacheConfig) WatchedNamespaces(ctx context.Context, reader client.Reader) ([]string, error) {
+	if !c.NamespaceScoped() {
+		return nil, nil
+	}
+
+	namespaces := sets.New(c.Namespaces...)
+	if c.NamespaceSelector != nil {
+		selector, err := metav1.LabelSelectorAsSelector(c.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		list := &corev1.NamespaceList{}
+		if err := reader.List(ctx, list, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+			return nil, fmt.Errorf("list namespaces matching %s: %w", selector, err)
+		}
+		for _, namespace := range list.Items {
+			namespaces.Insert(namespace.Name)
+		}
+	}
+
+	if namespaces.Len() == 0 {
+		return nil, fmt.Errorf("no namespace matches the namespaces and namespaceSelector of the cache")
+	}
+	return sets.List(namespaces), nil
+}
+
+// ControllerOptions 将控制器配置转换为控制器的参数，限速器同时按对象指数退避并限制整体的入队速率
+func (c *ControllerConfig) ControllerOptions() controller.Options {
+	limiter := c.RateLimiter
+	return controller.Options{
+		MaxConcurrentReconciles: c.MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(limiter.BaseDelay.Duration, limiter.MaxDelay.Duration),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(limiter.QPS), limiter.Burst)},
+		),
+	}
+}
+
+// inClusterNamespaceFile 保存 Pod 所在的命名空间
+const inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// ShardMembership 根据分片配置返回本副本的分片成员，未启用分片时返回 nil
+// 未配置 identity 时使用主机名(即 Pod 名称)，未配置 leaseNamespace 时依次使用 leader election 的命名空间和 Pod 所在的命名空间
+func (c *ManagerConfig) ShardMembership(cl client.Client, reader client.Reader) (*sharding.Membership, error) {
+	shard := c.Sharding
+	if !shard.Enabled {
+		return nil, nil
+	}
+
+	identity := shard.Identity
+	if identity == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("get shard identity: %w", err)
+		}
+		identity = strings.ToLower(hostname)
+	}
+	if msgs := validation.IsDNS1123Subdomain(shard.Group + "-" + identity); len(msgs) > 0 {
+		return nil, fmt.Errorf("invalid shard identity %q: %s", identity, strings.Join(msgs, ", "))
+	}
+
+	namespace, err := podNamespace(shard.LeaseNamespace, c.LeaderElection.ResourceNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("sharding.leaseNamespace must be set when not running in a cluster: %w", err)
+	}
+
+	membership := sharding.NewMembership()
+	membership.Client = cl
+	membership.Reader = reader
+	membership.Namespace = namespace
+	membership.Group = shard.Group
+	membership.Identity = identity
+	membership.LeaseDuration = shard.LeaseDuration.Duration
+	membership.RenewInterval = shard.RenewInterval.Duration
+	membership.VirtualNodes = shard.VirtualNodes
+	return membership, nil
+}
+
+// DebugServer 根据配置返回管理端点，未启用时返回 nil
+func (c *ManagerConfig) DebugServer(tracker *debug.Tracker, renderer debug.DesiredStateRenderer, controller string) (*debug.Server, error) {
+	if c.Debug.BindAddress == "" {
+		return nil, nil
+	}
+	server := &debug.Server{
+		BindAddress: c.Debug.BindAddress,
+		Tracker:     tracker,
+		Renderer:    renderer,
+		Controller:  controller,
+	}
+	if c.Debug.TokenFile != "" {
+		data, err := os.ReadFile(c.Debug.TokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("read debug token: %w", err)
+		}
+		if server.Token = strings.TrimSpace(string(data)); server.Token == "" {
+			return nil, fmt.Errorf("debug token file %s is empty", c.Debug.TokenFile)
+		}
+	}
+	return server, nil
+}
+
+// TracingOptions 将配置转换为 tracing.Setup 的参数
+func (c *ManagerConfig) TracingOptions() tracing.Options {
+	opts := tracing.Options{
+		Exporter:    tracing.Exporter(c.Tracing.Exporter),
+		Endpoint:    c.Tracing.Endpoint,
+		File:        c.Tracing.File,
+		ServiceName: c.Tracing.ServiceName,
+		SampleRatio: 1,
+	}
+	if c.Tracing.SampleRatio != nil {
+		opts.SampleRatio = *c.Tracing.SampleRatio
+	}
+	return opts
+}
+
+// AuditLogger 根据配置打开审计日志，未启用时返回 nil
+func (c *ManagerConfig) AuditLogger() (*audit.Logger, error) {
+	return audit.Open(audit.Sink(c.Audit.Sink), c.Audit.Path)
+}
+
+// CertProvisioner 根据配置返回 webhook 证书的签发器，未启用时返回 nil
+func (c *ManagerConfig) CertProvisioner(cl client.Client) (*certs.Provisioner, error) {
+	provisioner := c.Webhook.CertProvisioner
+	if !provisioner.Enabled {
+		return nil, nil
+	}
+	namespace, err := podNamespace(provisioner.Namespace)
+	if err != nil {
+		return nil, fmt.Errorf("webhook.certProvisioner.namespace must be set when not running in a cluster: %w", err)
+	}
+
+	certDir := c.Webhook.CertDir
+	if certDir == "" {
+		certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+	}
+	service := provisioner.ServiceName + "." + namespace
+	return &certs.Provisioner{
+		Client:                         cl,
+		SecretKey:                      client.ObjectKey{Namespace: namespace, Name: provisioner.SecretName},
+		CertDir:                        certDir,
+		DNSNames:                       []string{service + ".svc", service + ".svc.cluster.local", service},
+		MutatingWebhookConfiguration:   provisioner.MutatingWebhookConfiguration,
+		ValidatingWebhookConfiguration: provisioner.ValidatingWebhookConfiguration,
+		CRDs:                           provisioner.CRDs,
+		CAValidity:                     provisioner.CAValidity.Duration,
+		CertValidity:                   provisioner.CertValidity.Duration,
+		RotateBefore:                   provisioner.RotateBefore.Duration,
+	}, nil
+}
+
+// LeaderElectionLease 返回 leader election 使用的 Lease，未配置命名空间时与 controller-runtime 一样使用 Pod 所在的命名空间
+func (c *ManagerConfig) LeaderElectionLease() (client.ObjectKey, error) {
+	namespace, err := podNamespace(c.LeaderElection.ResourceNamespace)
+	if err != nil {
+		return client.ObjectKey{}, fmt.Errorf("leaderElection.resourceNamespace must be set when not running in a cluster: %w", err)
+	}
+	return client.ObjectKey{Namespace: namespace, Name: c.LeaderElection.ResourceName}, nil
+}
+
+// podNamespace 返回第一个非空的命名空间，都为空时返回 Pod 所在的命名空间
+func podNamespace(namespaces ...string) (string, error) {
+	for _, namespace := range namespaces {
+		if namespace != "" {
+			return namespace, nil
+		}
+	}
+	data, err := os.ReadFile(inClusterNamespaceFile)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// WebhookOptions 将 admission 配置转换为 Application webhook 的参数，配置了 imageDigestsFile 时读取镜像 digest 表
+func (c *AdmissionConfig) WebhookOptions() (webhookappsv1.ApplicationWebhookOptions, error) {
+	opts := webhookappsv1.ApplicationWebhookOptions{
+		Defaults:                      c.Defaults,
+		AllowedRegistries:             c.AllowedRegistries,
+		AllowedEnvironmentNamespaces:  c.AllowedEnvironmentNamespaces,
+		DeletionProtectionExemptUsers: c.DeletionProtectionExemptUsers,
+		DefaultPolicy:                 c.DefaultPolicy,
+	}
+	if c.ImageDigestsFile == "" {
+		return opts, nil
+	}
+
+	data, err := os.ReadFile(c.ImageDigestsFile)
+	if err != nil {
+		return opts, fmt.Errorf("read image digests: %w", err)
+	}
+	resolver, err := image.LoadStaticResolver(data)
+	if err != nil {
+		return opts, fmt.Errorf("load image digests %s: %w", c.ImageDigestsFile, err)
+	}
+	opts.ImageResolver = resolver
+	return opts, nil
+}
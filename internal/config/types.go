@@ -0,0 +1,250 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package config 定义 Manager 的版本化配置文件，通过 --config 加载
+package config
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	webhookappsv1 "github.com/ahwhy/clusterops-operator/internal/webhook/v1"
+)
+
+const (
+	// APIVersion 是当前配置文件的版本
+	APIVersion = "config.clusterops.io/v1alpha1"
+	// Kind 是配置文件的类型
+	Kind = "ManagerConfig"
+)
+
+// ManagerConfig is the configuration of the manager.
+type ManagerConfig struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Metrics configures the metrics endpoint.
+	Metrics MetricsConfig `json:"metrics,omitempty"`
+	// Health configures the health probe endpoint.
+	Health HealthConfig `json:"health,omitempty"`
+	// Webhook configures the webhook server.
+	Webhook WebhookConfig `json:"webhook,omitempty"`
+	// Debug configures the debug endpoints.
+	Debug DebugConfig `json:"debug,omitempty"`
+	// Tracing configures OpenTelemetry tracing.
+	Tracing TracingConfig `json:"tracing,omitempty"`
+	// Audit configures the audit log of the changes the manager makes to cluster objects.
+	Audit AuditConfig `json:"audit,omitempty"`
+	// LeaderElection configures leader election.
+	LeaderElection LeaderElectionConfig `json:"leaderElection,omitempty"`
+	// Cache configures which objects the manager watches.
+	Cache CacheConfig `json:"cache,omitempty"`
+	// Controllers configures the concurrency and rate limits of each controller.
+	Controllers ControllersConfig `json:"controllers,omitempty"`
+	// Sharding spreads Applications across replicas of the manager.
+	Sharding ShardingConfig `json:"sharding,omitempty"`
+	// Admission configures the Application webhook. It is reloaded without restarting the manager.
+	Admission AdmissionConfig `json:"admission,omitempty"`
+	// GracefulShutdownTimeout is how long the manager waits for in-flight reconciles and
+	// requests when it stops, before flushing the tracing and audit sinks. The manager allows
+	// shutdown.FlushTimeout on top of it for the flush, the sum should be shorter than the
+	// terminationGracePeriodSeconds of the Pod.
+	GracefulShutdownTimeout metav1.Duration `json:"gracefulShutdownTimeout,omitempty"`
+	// FeatureGates enables or disables experimental features by name, see --feature-gates for the known features.
+	FeatureGates map[string]bool `json:"featureGates,omitempty"`
+}
+
+// MetricsConfig configures the metrics endpoint.
+type MetricsConfig struct {
+	// BindAddress is the address the metrics endpoint binds to, "0" disables it.
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// HealthConfig configures the health probe endpoint.
+type HealthConfig struct {
+	// BindAddress is the address the health probe endpoint binds to.
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// DebugConfig configures the debug endpoints serving pprof, the work queues, the last
+// reconcile of each object and the rendered desired state of Applications.
+type DebugConfig struct {
+	// BindAddress is the address the debug endpoints bind to, they are disabled when empty.
+	// Addresses other than localhost require tokenFile.
+	BindAddress string `json:"bindAddress,omitempty"`
+	// TokenFile holds the bearer token requests to the debug endpoints must carry.
+	TokenFile string `json:"tokenFile,omitempty"`
+}
+
+// TracingConfig configures OpenTelemetry tracing of reconciles, API calls and admission requests.
+type TracingConfig struct {
+	// Exporter is where spans are exported to: none, stdout, file or otlp. Tracing is disabled when none.
+	Exporter string `json:"exporter,omitempty"`
+	// Endpoint is the OTLP/HTTP endpoint of the collector used by the otlp exporter, e.g. http://otel-collector:4318.
+	Endpoint string `json:"endpoint,omitempty"`
+	// File is the file the file exporter appends spans to.
+	File string `json:"file,omitempty"`
+	// ServiceName is the service.name resource attribute of the spans.
+	ServiceName string `json:"serviceName,omitempty"`
+	// SampleRatio is the fraction of reconciles and admission requests traced, between 0 and 1.
+	SampleRatio *float64 `json:"sampleRatio,omitempty"`
+}
+
+// AuditConfig configures the audit log. Every create, update, patch and delete the controllers
+// make, including status writes, is written as a JSON line with the Application being reconciled,
+// the reconcile ID, the reason and the fields changed.
+type AuditConfig struct {
+	// Sink is where audit events are written to: none, stdout or file. The audit log is disabled when none.
+	Sink string `json:"sink,omitempty"`
+	// Path is the file the file sink appends audit events to.
+	Path string `json:"path,omitempty"`
+}
+
+// WebhookConfig configures the webhook server.
+type WebhookConfig struct {
+	// Host is the address the webhook server binds to, all addresses when empty.
+	Host string `json:"host,omitempty"`
+	// Port is the port the webhook server serves at.
+	Port int `json:"port,omitempty"`
+	// CertDir is the directory holding tls.crt and tls.key.
+	CertDir string `json:"certDir,omitempty"`
+	// CertProvisioner generates and rotates the certificates of the webhook server
+	// instead of cert-manager.
+	CertProvisioner CertProvisionerConfig `json:"certProvisioner,omitempty"`
+}
+
+// CertProvisionerConfig configures the built-in certificate provisioner. It generates a
+// self-signed CA and the serving certificate, stores them in a Secret, writes the
+// serving certificate to certDir and injects the CA into the webhook configurations
+// and the conversion webhook of the CRDs.
+type CertProvisionerConfig struct {
+	// Enabled enables the provisioner, certDir must be writable.
+	Enabled bool `json:"enabled,omitempty"`
+	// SecretName is the Secret holding the CA and the serving certificate.
+	SecretName string `json:"secretName,omitempty"`
+	// Namespace of the Secret and the webhook Service, the namespace of the manager when empty.
+	Namespace string `json:"namespace,omitempty"`
+	// ServiceName is the webhook Service the serving certificate is issued for.
+	ServiceName string `json:"serviceName,omitempty"`
+	// MutatingWebhookConfiguration is the name of the MutatingWebhookConfiguration to inject the CA into.
+	MutatingWebhookConfiguration string `json:"mutatingWebhookConfiguration,omitempty"`
+	// ValidatingWebhookConfiguration is the name of the ValidatingWebhookConfiguration to inject the CA into.
+	ValidatingWebhookConfiguration string `json:"validatingWebhookConfiguration,omitempty"`
+	// CRDs are the CustomResourceDefinitions whose conversion webhook the CA is injected into.
+	CRDs []string `json:"crds,omitempty"`
+	// CAValidity is how long a generated CA is valid.
+	CAValidity metav1.Duration `json:"caValidity,omitempty"`
+	// CertValidity is how long a generated serving certificate is valid.
+	CertValidity metav1.Duration `json:"certValidity,omitempty"`
+	// RotateBefore is how long before expiry certificates are rotated.
+	RotateBefore metav1.Duration `json:"rotateBefore,omitempty"`
+}
+
+// LeaderElectionConfig configures leader election.
+type LeaderElectionConfig struct {
+	// LeaderElect enables leader election.
+	LeaderElect bool `json:"leaderElect,omitempty"`
+	// ResourceName is the name of the Lease used for leader election.
+	ResourceName string `json:"resourceName,omitempty"`
+	// ResourceNamespace is the namespace of the Lease, the namespace of the manager when empty.
+	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+	// LeaseDuration is how long non-leaders wait before trying to acquire the Lease.
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	// RenewDeadline is how long the leader retries renewing the Lease before giving up.
+	RenewDeadline metav1.Duration `json:"renewDeadline,omitempty"`
+	// RetryPeriod is how long clients wait between tries of actions.
+	RetryPeriod metav1.Duration `json:"retryPeriod,omitempty"`
+	// ReleaseOnCancel releases the Lease when the manager stops so another replica takes over
+	// without waiting for leaseDuration. Defaults to true.
+	ReleaseOnCancel *bool `json:"releaseOnCancel,omitempty"`
+}
+
+// CacheConfig configures which objects the manager watches. The whole cluster is
+// watched when neither namespaces nor namespaceSelector is set.
+type CacheConfig struct {
+	// Namespaces restricts the watched namespaces.
+	Namespaces []string `json:"namespaces,omitempty"`
+	// NamespaceSelector adds the namespaces matching the selector when the manager starts,
+	// namespaces labeled afterwards are watched after the manager restarts.
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// ControllersConfig configures each controller.
+type ControllersConfig struct {
+	// Application configures the Application controller.
+	Application ControllerConfig `json:"application,omitempty"`
+	// ApplicationSet configures the ApplicationSet controller.
+	ApplicationSet ControllerConfig `json:"applicationSet,omitempty"`
+}
+
+// ControllerConfig configures the concurrency and rate limit of a controller.
+type ControllerConfig struct {
+	// MaxConcurrentReconciles is the number of objects reconciled in parallel.
+	MaxConcurrentReconciles int `json:"maxConcurrentReconciles,omitempty"`
+	// RateLimiter limits how often objects are requeued.
+	RateLimiter RateLimiterConfig `json:"rateLimiter,omitempty"`
+}
+
+// RateLimiterConfig combines a per-object exponential backoff with an overall token bucket.
+type RateLimiterConfig struct {
+	// BaseDelay is the backoff of the first retry of an object.
+	BaseDelay metav1.Duration `json:"baseDelay,omitempty"`
+	// MaxDelay caps the backoff of an object.
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+	// QPS is the overall rate objects are requeued at.
+	QPS float64 `json:"qps,omitempty"`
+	// Burst is the overall burst of requeues.
+	Burst int `json:"burst,omitempty"`
+}
+
+// ShardingConfig spreads Applications across replicas. Every replica joins the group with a
+// Lease and reconciles only the Applications the consistent hash of their namespace/name, or
+// of the apps.clusterops.io/shard annotation, assigns to it. Leader election does not apply to the
+// Application controller while sharding is enabled, it must still be enabled for the other controllers.
+type ShardingConfig struct {
+	// Enabled enables sharding.
+	Enabled bool `json:"enabled,omitempty"`
+	// Group is the name of the shard group, replicas sharing a group split the Applications.
+	Group string `json:"group,omitempty"`
+	// Identity of this replica, the hostname when empty.
+	Identity string `json:"identity,omitempty"`
+	// LeaseNamespace is the namespace of the membership Leases, the namespace of the manager when empty.
+	LeaseNamespace string `json:"leaseNamespace,omitempty"`
+	// LeaseDuration is how long a replica stays a member after its last renewal.
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	// RenewInterval is how often replicas renew their Lease and refresh the members.
+	RenewInterval metav1.Duration `json:"renewInterval,omitempty"`
+	// VirtualNodes is the number of points of each replica on the hash ring.
+	VirtualNodes int `json:"virtualNodes,omitempty"`
+}
+
+// AdmissionConfig configures the Application webhook.
+type AdmissionConfig struct {
+	// Defaults are filled into Applications that leave them unset.
+	Defaults *webhookappsv1.ApplicationDefaults `json:"defaults,omitempty"`
+	// AllowedRegistries restricts the registries container images may come from.
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// AllowedEnvironmentNamespaces lists the namespaces, as path.Match patterns, environments may render into.
+	// When empty the requester must be allowed to create Deployments and Services in the namespaces instead.
+	AllowedEnvironmentNamespaces []string `json:"allowedEnvironmentNamespaces,omitempty"`
+	// DeletionProtectionExemptUsers may delete protected Applications without confirming the deletion,
+	// in addition to the garbage collector and the namespace controller.
+	DeletionProtectionExemptUsers []string `json:"deletionProtectionExemptUsers,omitempty"`
+	// ImageDigestsFile is the YAML file mapping image references to digests, images are pinned when set.
+	ImageDigestsFile string `json:"imageDigestsFile,omitempty"`
+	// DefaultPolicy is evaluated for every Application in addition to the policies in scope.
+	// Its maxReplicas only applies when no enforced policy in scope declares one, so a namespace can raise the ceiling.
+	DefaultPolicy *appsv1.ApplicationPolicySpec `json:"defaultPolicy,omitempty"`
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"time"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// DefaultReloadInterval 是检查配置文件是否变化的默认间隔
+const DefaultReloadInterval = 10 * time.Second
+
+// Watcher reloads the config file periodically and applies the part of it that
+// is safe to change at runtime, i.e. the admission section. Changes to other
+// sections are logged and take effect after the manager restarts.
+type Watcher struct {
+	// Path is the config file.
+	Path string
+	// Interval between checks, DefaultReloadInterval when zero.
+	Interval time.Duration
+	// Current is the config the manager was started with.
+	Current *ManagerConfig
+	// Override is applied to every reloaded config before it is compared, e.g. to keep command line flags.
+	Override func(cfg *ManagerConfig) error
+	// OnAdmissionChange applies a changed admission section, the previous section is kept when it fails.
+	OnAdmissionChange func(admission *AdmissionConfig) error
+
+	// data 是最近一次读取到的文件内容，内容不变时跳过解析
+	data []byte
+}
+
+var _ manager.Runnable = &Watcher{}
+var _ manager.LeaderElectionRunnable = &Watcher{}
+
+// Start implements manager.Runnable.
+func (w *Watcher) Start(ctx context.Context) error {
+	interval := w.Interval
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	wait.UntilWithContext(ctx, w.reload, interval)
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. webhook 在所有副本上运行，每个副本都需要重新加载配置
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// reload 重新读取配置文件，文件不存在或不合法时保持当前配置
+func (w *Watcher) reload(_ context.Context) {
+	logger := ctrl.Log.WithName("config").WithValues("file", w.Path)
+
+	data, err := os.ReadFile(w.Path)
+	if err != nil {
+		logger.Error(err, "Failed to read the config file, keeping the current config.")
+		return
+	}
+	if w.data == nil {
+		w.data = data
+		return
+	}
+	if bytes.Equal(data, w.data) {
+		return
+	}
+	w.data = data
+
+	cfg, err := Load(data)
+	if err == nil && w.Override != nil {
+		err = w.Override(cfg)
+	}
+	if err != nil {
+		logger.Error(err, "Failed to load the config file, keeping the current config.")
+		return
+	}
+
+	if !apiequality.Semantic.DeepEqual(cfg.Admission, w.Current.Admission) {
+		if err := w.OnAdmissionChange(&cfg.Admission); err != nil {
+			logger.Error(err, "Failed to apply the admission config, keeping the current config.")
+			return
+		}
+		w.Current.Admission = cfg.Admission
+		logger.Info("The admission config has been reloaded.")
+	}
+
+	// 其他部分只能在重启后生效
+	restartOnly, current := *cfg, *w.Current
+	restartOnly.Admission, current.Admission = AdmissionConfig{}, AdmissionConfig{}
+	if !apiequality.Semantic.DeepEqual(restartOnly, current) {
+		logger.Info("The config file has changes that take effect after the manager restarts.")
+	}
+}
@@ -24,19 +24,34 @@ import (
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/debug"
+	"github.com/ahwhy/clusterops-operator/internal/sharding"
+	"github.com/ahwhy/clusterops-operator/internal/shutdown"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
 )
 
 const (
 	GenericRequeueDuraiton = 1 * time.Minute
+
+	// ApplicationControllerName 是 Application 控制器的名称，也是工作队列指标中的 name
+	ApplicationControllerName = "application"
+	// ApplicationSetControllerName 是 ApplicationSet 控制器的名称
+	ApplicationSetControllerName = "applicationset"
 )
 
 var (
@@ -47,16 +62,32 @@ var (
 type ApplicationReconciler struct {
 	client.Client
 	Scheme *runtime.Scheme
+	// Options 配置控制器的并发数和限速器
+	Options controller.Options
+	// Shard 不为 nil 时只调谐分配给本副本的 Application，各副本无需 leader election 即可同时运行
+	Shard *sharding.Membership
+	// Tracker 不为 nil 时记录每次调谐的结果，供管理端点查询
+	Tracker *debug.Tracker
+	// Drainer 不为 nil 时 Manager 停止前等待正在执行的调谐完成
+	Drainer *shutdown.Drainer
+	// Recorder 不为 nil 时在子资源创建、更新后记录 Event，Event 的注解中带有 trace ID
+	Recorder record.EventRecorder
+	// WatchedNamespaces 是 Manager 缓存监听的命名空间，为空表示监听整个集群
+	// 渲染到其他命名空间的环境无法调谐，Application 会通过 ReconcileError Condition 报告这些环境
+	WatchedNamespaces []string
 }
 
 //+kubebuilder:rbac:groups=apps.clusterops.io,resources=applications,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps.clusterops.io,resources=applications/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=apps.clusterops.io,resources=applications/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps.clusterops.io,resources=applicationtemplates,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.clusterops.io,resources=clusterapplicationtemplates,verbs=get;list;watch
 
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments/status,verbs=get
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=core,resources=services/status,verbs=get
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -82,6 +113,8 @@ func (r *ApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		// 当 Application 不存在，结束本轮调谐
 		if errors.IsNotFound(err) {
 			logger.Info("Application not found.")
+			applicationPaused.DeleteLabelValues(req.Namespace, req.Name)
+			applicationPausedReconciles.DeleteLabelValues(req.Namespace, req.Name)
 			return ctrl.Result{}, nil
 		}
 		// 其他错误情况，通过重试来处理
@@ -89,31 +122,172 @@ func (r *ApplicationReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 	}
 
+	// 分片成员变化期间，队列中可能残留已经分配给其他副本的 Application
+	if !r.ownsApplication(app) {
+		logger.Info("The Application belongs to another shard, skipping.")
+		return ctrl.Result{}, nil
+	}
+
+	// Application 正在删除时，清理位于其他命名空间中的环境子资源
+	if !app.DeletionTimestamp.IsZero() {
+		return r.finalizeEnvironments(ctx, app)
+	}
+	if err := r.reconcileEnvironmentsFinalizer(ctx, app); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	// 维护 Paused Condition，暂停状态下子资源调谐函数只同步状态，不再变更子资源
+	if err := r.reconcilePaused(ctx, app); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
 	// reconcile sub-resource
 	var result ctrl.Result
 	var err error
 
-	result, err = r.reconcileDeployment(ctx, app)
+	// 检查 dependsOn 中的依赖是否就绪，未就绪时暂缓发布
+	result, err = r.reconcileDependencies(ctx, app)
 	if err != nil {
-		logger.Error(err, "Fail to reconcile Deployment.")
+		logger.Error(err, "Fail to reconcile dependencies.")
 		return result, err
 	}
 
-	result, err = r.reconcileService(ctx, app)
+	// 检查 selector 是否与其他 Application 的 Pod 重叠
+	if err := r.reconcileSelectorConflicts(ctx, app); err != nil {
+		logger.Error(err, "Failed to check selector conflicts, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	// 合并引用的模板并叠加环境配置，渲染期望的子资源
+	states, err := r.renderDesiredStates(ctx, app)
 	if err != nil {
-		logger.Error(err, "")
-		return result, err
+		logger.Error(err, "Failed to render the desired state, will requeue after a short time.")
+		if condErr := r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeTemplateResolved,
+			Status:  metav1.ConditionFalse,
+			Reason:  "RenderFailed",
+			Message: err.Error(),
+		}); condErr != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, condErr
+		}
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+	if app.Spec.TemplateRef != nil {
+		if err := r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeTemplateResolved,
+			Status:  metav1.ConditionTrue,
+			Reason:  "TemplateMerged",
+			Message: "Template " + templateRefKey(app) + " has been merged",
+		}); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+		}
+	}
+
+	// 只监听部分命名空间时，渲染到其他命名空间的环境无法通过缓存读写，修改监听范围需要重启 Manager，因此不再重试
+	if unwatched := r.unwatchedEnvironments(states); len(unwatched) > 0 {
+		logger.Info("Environments render into namespaces the manager does not watch.", "environments", unwatched)
+		if err := r.reportUnwatchedEnvironments(ctx, app, unwatched); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+		}
+		return ctrl.Result{}, nil
+	}
+
+	// 删除重建子资源时返回非空的 result，需要在重建完成后再次调谐
+	var recreating ctrl.Result
+	for _, state := range states {
+		result, err = r.reconcileDeployment(ctx, app, state)
+		if err != nil {
+			logger.Error(err, "Fail to reconcile Deployment.")
+			return result, err
+		}
+		if !result.IsZero() {
+			recreating = result
+		}
+
+		result, err = r.reconcileService(ctx, app, state)
+		if err != nil {
+			logger.Error(err, "")
+			return result, err
+		}
+		if !result.IsZero() {
+			recreating = result
+		}
+	}
+
+	// 清理不再需要的子资源和环境状态，暂停或等待依赖期间不做删除
+	if !rolloutHeld(app) {
+		if err := r.pruneChildren(ctx, app, states); err != nil {
+			logger.Error(err, "Failed to prune child resources, will requeue after a short time.")
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+		}
+	}
+	if err := r.pruneEnvironmentStatus(ctx, app); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	if err := r.reconcileReady(ctx, app, states); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+	if err := r.reconcileImages(ctx, app); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+	if err := r.setCondition(ctx, app, metav1.Condition{
+		Type:    v1.ConditionTypeReconcileError,
+		Status:  metav1.ConditionFalse,
+		Reason:  "Reconciled",
+		Message: "All child resources have been reconciled",
+	}); err != nil {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	if !recreating.IsZero() {
+		logger.Info("Child resources are being recreated.")
+		return recreating, nil
+	}
+	// 允许修改不可变字段的注解只对一次变更生效，重建完成后移除
+	if !rolloutHeld(app) {
+		if err := r.clearAllowImmutableChanges(ctx, app); err != nil {
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+		}
 	}
 
 	logger.Info("All resources have been reconciled.")
 	return ctrl.Result{}, nil
 }
 
+// instrument 为调谐加上审计日志、链路追踪、优雅停止和调试记录
+func instrument(name, kind string, r reconcile.Reconciler, tracker *debug.Tracker, drainer *shutdown.Drainer) reconcile.Reconciler {
+	return tracker.Wrap(name, drainer.Wrap(tracing.Reconciler(name, audit.Reconciler(name, kind, r))))
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	setupLog := ctrl.Log.WithName("setup")
 
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.Application{}, dependsOnIndexField, indexDependsOn); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.Application{}, templateRefIndexField, indexTemplateRef); err != nil {
+		return err
+	}
+	// 准入 webhook 检查冲突时同样使用该索引
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &v1.Application{}, v1.ChildNamespacesIndexField, indexChildNamespaces); err != nil {
+		return err
+	}
+
+	bldr := ctrl.NewControllerManagedBy(mgr)
+	if r.Shard != nil {
+		needLeaderElection := false
+		r.Options.NeedLeaderElection = &needLeaderElection
+
+		rebalancer, rebalanceSource := newShardRebalancer(r)
+		if err := mgr.Add(rebalancer); err != nil {
+			return err
+		}
+		bldr = bldr.WatchesRawSource(rebalanceSource, &handler.EnqueueRequestForObject{})
+	}
+
+	return bldr.
 		For(&v1.Application{}, builder.WithPredicates(predicate.Funcs{
 			CreateFunc: func(event event.CreateEvent) bool {
 				return true
@@ -126,6 +300,22 @@ func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				if event.ObjectNew.GetResourceVersion() == event.ObjectOld.GetResourceVersion() {
 					return false
 				}
+				// 进入删除流程时需要执行 Finalizer 的清理逻辑
+				if !event.ObjectNew.GetDeletionTimestamp().IsZero() {
+					return true
+				}
+				// paused 注解的变化同样需要触发调谐，以便及时暂停或恢复
+				if event.ObjectNew.GetAnnotations()[v1.PausedAnnotation] != event.ObjectOld.GetAnnotations()[v1.PausedAnnotation] {
+					return true
+				}
+				// 分片注解变化后由新的副本接管，需要触发其调谐
+				if event.ObjectNew.GetAnnotations()[v1.ShardAnnotation] != event.ObjectOld.GetAnnotations()[v1.ShardAnnotation] {
+					return true
+				}
+				// metadata.labels 作为 Service 的 selector 和 Pod 标签，变化时需要重新渲染子资源
+				if !reflect.DeepEqual(event.ObjectNew.GetLabels(), event.ObjectOld.GetLabels()) {
+					return true
+				}
 				if reflect.DeepEqual(event.ObjectNew.(*v1.Application).Spec, event.ObjectOld.(*v1.Application).Spec) {
 					return false
 				}
@@ -134,7 +324,7 @@ func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			GenericFunc: func(event event.GenericEvent) bool {
 				return true
 			},
-		})).
+		}, r.ownedByShard())).
 		// Deployment
 		Owns(&appsv1.Deployment{}, builder.WithPredicates(predicate.Funcs{
 			CreateFunc: func(event event.CreateEvent) bool {
@@ -148,7 +338,9 @@ func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 				if event.ObjectNew.GetResourceVersion() == event.ObjectOld.GetResourceVersion() {
 					return false
 				}
-				if reflect.DeepEqual(event.ObjectNew.(*appsv1.Deployment).Spec, event.ObjectOld.(*appsv1.Deployment).Spec) {
+				// 副本可用情况的变化会影响 Ready Condition，需要同步到 Application
+				if reflect.DeepEqual(event.ObjectNew.(*appsv1.Deployment).Spec, event.ObjectOld.(*appsv1.Deployment).Spec) &&
+					reflect.DeepEqual(event.ObjectNew.(*appsv1.Deployment).Status, event.ObjectOld.(*appsv1.Deployment).Status) {
 					return false
 				}
 				return true
@@ -175,5 +367,37 @@ func (r *ApplicationReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			},
 			GenericFunc: nil,
 		})).
-		Complete(r)
+		// 环境子资源位于其他命名空间，无法通过 OwnerReference 关联，改为根据 owner 标签反查
+		Watches(&appsv1.Deployment{},
+			handler.EnqueueRequestsFromMapFunc(r.findOwnerByLabels),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc: func(event event.CreateEvent) bool {
+					return false
+				},
+			})).
+		Watches(&corev1.Service{},
+			handler.EnqueueRequestsFromMapFunc(r.findOwnerByLabels),
+			builder.WithPredicates(predicate.Funcs{
+				CreateFunc: func(event event.CreateEvent) bool {
+					return false
+				},
+			})).
+		// 被依赖的 Application 就绪状态变化时，重新调谐依赖它的 Application
+		Watches(&v1.Application{},
+			handler.EnqueueRequestsFromMapFunc(r.findDependents),
+			builder.WithPredicates(dependencyReadyChanged)).
+		// Application 的标签或 spec 变化时，重新检查与其 selector 重叠的 Application
+		Watches(&v1.Application{},
+			handler.EnqueueRequestsFromMapFunc(r.findSelectorConflicts),
+			builder.WithPredicates(selectorChanged)).
+		// 模板变化时，重新调谐所有引用该模板的 Application
+		Watches(&v1.ApplicationTemplate{},
+			handler.EnqueueRequestsFromMapFunc(r.findTemplateReferrers),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Watches(&v1.ClusterApplicationTemplate{},
+			handler.EnqueueRequestsFromMapFunc(r.findTemplateReferrers),
+			builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		Named(ApplicationControllerName).
+		WithOptions(r.Options).
+		Complete(instrument(ApplicationControllerName, "Application", r, r.Tracker, r.Drainer))
 }
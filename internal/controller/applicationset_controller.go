@@ -0,0 +1,452 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/debug"
+	"github.com/ahwhy/clusterops-operator/internal/shutdown"
+)
+
+// ApplicationSetReconciler reconciles a ApplicationSet object
+type ApplicationSetReconciler struct {
+	client.Client
+	Scheme *runtime.Scheme
+	// Options 配置控制器的并发数和限速器
+	Options controller.Options
+	// Tracker 不为 nil 时记录每次调谐的结果，供管理端点查询
+	Tracker *debug.Tracker
+	// Drainer 不为 nil 时 Manager 停止前等待正在执行的调谐完成
+	Drainer *shutdown.Drainer
+}
+
+//+kubebuilder:rbac:groups=apps.clusterops.io,resources=applicationsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=apps.clusterops.io,resources=applicationsets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=apps.clusterops.io,resources=applicationsets/finalizers,verbs=update
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+
+// Reconcile 根据生成器产生的参数渲染 Application，创建或更新生成的 Application，
+// 按照 prunePolicy 处理不再生成的 Application，并汇总所有 Application 的就绪情况
+func (r *ApplicationSetReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	set := &v1.ApplicationSet{}
+	if err := r.Get(ctx, req.NamespacedName, set); err != nil {
+		if errors.IsNotFound(err) {
+			logger.Info("ApplicationSet not found.")
+			return ctrl.Result{}, nil
+		}
+		logger.Error(err, "Failed to get the ApplicationSet, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+	// ApplicationSet 删除后，生成的 Application 由垃圾回收根据 OwnerReference 清理
+	if !set.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	// 生成参数并渲染 Application
+	params, err := r.generateParams(ctx, set)
+	if err != nil {
+		logger.Error(err, "Failed to generate parameters, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, r.setStatusError(ctx, set, "GenerateFailed", err)
+	}
+	apps, err := renderApplications(set, params)
+	if err != nil {
+		logger.Error(err, "Failed to render Applications.")
+		return ctrl.Result{}, r.setStatusError(ctx, set, "RenderFailed", err)
+	}
+
+	// 创建或更新生成的 Application
+	for _, app := range apps {
+		if err := r.applyApplication(ctx, set, app); err != nil {
+			logger.Error(err, "Failed to apply the generated Application, will requeue after a short time.",
+				"application", client.ObjectKeyFromObject(app))
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, r.setStatusError(ctx, set, "ApplyFailed", err)
+		}
+	}
+
+	// 按照 prunePolicy 处理不再生成的 Application
+	blocked, err := r.pruneApplications(ctx, set, apps)
+	if err != nil {
+		logger.Error(err, "Failed to prune Applications, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, r.setStatusError(ctx, set, "PruneFailed", err)
+	}
+
+	if err := r.updateStatus(ctx, set, apps, blocked); err != nil {
+		logger.Error(err, "Failed to update ApplicationSet status.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+	// 被拒绝删除的 Application 需要用户确认，记录在 PruneBlocked Condition 中并定期重试，而不是按错误快速重试
+	if len(blocked) > 0 {
+		logger.Info("The deletion of some Applications was rejected, will requeue after a short time.", "applications", blocked)
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, nil
+	}
+
+	logger.Info("All Applications have been reconciled.", "applications", len(apps))
+	return ctrl.Result{}, nil
+}
+
+// generateParams 依次执行所有生成器，汇总生成的参数
+func (r *ApplicationSetReconciler) generateParams(ctx context.Context, set *v1.ApplicationSet) ([]map[string]string, error) {
+	var params []map[string]string
+	for i, generator := range set.Spec.Generators {
+		switch {
+		case generator.List != nil:
+			params = append(params, generator.List.Elements...)
+		case generator.Namespaces != nil:
+			nsParams, err := r.namespaceParams(ctx, generator.Namespaces)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, nsParams...)
+		case generator.Matrix != nil:
+			nsParams, err := r.namespaceParams(ctx, &generator.Matrix.Namespaces)
+			if err != nil {
+				return nil, err
+			}
+			for _, element := range generator.Matrix.List.Elements {
+				for _, nsParam := range nsParams {
+					params = append(params, mergeStringMap(element, nsParam))
+				}
+			}
+		default:
+			return nil, fmt.Errorf("generators[%d] does not declare any generator", i)
+		}
+	}
+	return params, nil
+}
+
+// namespaceParams 为每个匹配的命名空间生成 namespace 和 namespace.labels.<key> 参数
+func (r *ApplicationSetReconciler) namespaceParams(ctx context.Context, generator *v1.NamespacesGenerator) ([]map[string]string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&generator.Selector)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaces, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+
+	params := make([]map[string]string, 0, len(namespaces.Items))
+	for _, ns := range namespaces.Items {
+		if !ns.DeletionTimestamp.IsZero() {
+			continue
+		}
+		param := map[string]string{"namespace": ns.Name}
+		for k, v := range ns.Labels {
+			param["namespace.labels."+k] = v
+		}
+		params = append(params, param)
+	}
+	return params, nil
+}
+
+// renderApplications 使用每一组参数渲染模板，相同 namespace/name 的 Application 只保留第一份
+func renderApplications(set *v1.ApplicationSet, params []map[string]string) ([]*v1.Application, error) {
+	data, err := json.Marshal(set.Spec.Template)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[types.NamespacedName]bool{}
+	apps := make([]*v1.Application, 0, len(params))
+	for _, param := range params {
+		rendered, err := substituteParams(string(data), param)
+		if err != nil {
+			return nil, err
+		}
+		tpl := v1.ApplicationSetTemplate{}
+		if err := json.Unmarshal([]byte(rendered), &tpl); err != nil {
+			return nil, err
+		}
+		if tpl.Metadata.Name == "" || tpl.Metadata.Namespace == "" {
+			return nil, fmt.Errorf("rendered Application has an empty name or namespace for parameters %v", param)
+		}
+
+		app := &v1.Application{}
+		app.SetName(tpl.Metadata.Name)
+		app.SetNamespace(tpl.Metadata.Namespace)
+		app.SetLabels(mergeStringMap(tpl.Metadata.Labels, map[string]string{v1.ApplicationSetLabel: set.Name}))
+		app.SetAnnotations(tpl.Metadata.Annotations)
+		app.Spec = tpl.Spec
+		setManagedMetadata(app)
+		// 摘要基于 webhook 填充默认值之前的渲染结果，与已存在的 Application 比较摘要而不是 spec
+		if err := setSpecHash(app, app.Spec); err != nil {
+			return nil, err
+		}
+
+		key := client.ObjectKeyFromObject(app)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		apps = append(apps, app)
+	}
+	return apps, nil
+}
+
+// substituteParams 将模板中的 {{key}} 替换为参数值，参数值按 JSON 字符串转义，避免破坏模板结构
+func substituteParams(template string, param map[string]string) (string, error) {
+	pairs := make([]string, 0, len(param)*2)
+	for k, v := range param {
+		escaped, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		// 去掉 JSON 字符串两端的引号
+		pairs = append(pairs, "{{"+k+"}}", string(escaped[1:len(escaped)-1]))
+	}
+	return strings.NewReplacer(pairs...).Replace(template), nil
+}
+
+// applyApplication 创建生成的 Application，已存在时仅在内容变化时更新
+func (r *ApplicationSetReconciler) applyApplication(ctx context.Context, set *v1.ApplicationSet, desired *v1.Application) error {
+	logger := log.FromContext(ctx)
+
+	app := &v1.Application{}
+	err := r.Get(ctx, client.ObjectKeyFromObject(desired), app)
+	if errors.IsNotFound(err) {
+		if err := ctrl.SetControllerReference(set, desired, r.Scheme); err != nil {
+			return err
+		}
+		if err := r.Create(audit.WithReason(ctx, "Generated"), desired); err != nil {
+			return err
+		}
+		logger.Info("The Application has been generated.", "application", client.ObjectKeyFromObject(desired))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// 不接管非本 ApplicationSet 生成的同名 Application
+	if owner := metav1.GetControllerOf(app); owner == nil || owner.UID != set.UID {
+		return fmt.Errorf("application %s already exists and is not managed by ApplicationSet %s",
+			client.ObjectKeyFromObject(app), set.Name)
+	}
+
+	// 生成的 Application 会被 webhook 填充默认值，直接比较 spec 总是不相等，因此只在渲染结果的摘要变化时更新
+	if app.Annotations[v1.SpecHashAnnotation] == desired.Annotations[v1.SpecHashAnnotation] {
+		return nil
+	}
+
+	syncManagedMetadata(app, desired)
+	app.Spec = desired.Spec
+	if err := r.Update(audit.WithReason(ctx, "GeneratedApplicationChanged"), app); err != nil {
+		return err
+	}
+	logger.Info("The generated Application has been updated.", "application", client.ObjectKeyFromObject(app))
+	return nil
+}
+
+// pruneApplications 处理不再生成的 Application：Delete 策略下直接删除，Orphan 策略下解除归属关系
+// 被 webhook 拒绝删除的 Application(例如受删除保护)不视为错误，以 namespace/name 的形式返回
+func (r *ApplicationSetReconciler) pruneApplications(ctx context.Context, set *v1.ApplicationSet, desired []*v1.Application) ([]string, error) {
+	logger := log.FromContext(ctx)
+
+	keep := map[types.NamespacedName]bool{}
+	for _, app := range desired {
+		keep[client.ObjectKeyFromObject(app)] = true
+	}
+
+	apps := &v1.ApplicationList{}
+	if err := r.List(ctx, apps, client.MatchingLabels{v1.ApplicationSetLabel: set.Name}); err != nil {
+		return nil, err
+	}
+	var blocked []string
+	for i := range apps.Items {
+		app := &apps.Items[i]
+		if keep[client.ObjectKeyFromObject(app)] {
+			continue
+		}
+		if owner := metav1.GetControllerOf(app); owner == nil || owner.UID != set.UID {
+			continue
+		}
+
+		if set.Spec.PrunePolicy == v1.PrunePolicyOrphan {
+			orphan(set, app)
+			if err := r.Update(audit.WithReason(ctx, "Orphaned"), app); err != nil {
+				return nil, err
+			}
+			logger.Info("The Application has been orphaned.", "application", client.ObjectKeyFromObject(app))
+			continue
+		}
+
+		if err := r.Delete(audit.WithReason(ctx, "Pruned"), app); err != nil && !errors.IsNotFound(err) {
+			if errors.IsForbidden(err) {
+				logger.Info("The deletion of the Application was rejected.", "application", client.ObjectKeyFromObject(app), "reason", err.Error())
+				blocked = append(blocked, client.ObjectKeyFromObject(app).String())
+				continue
+			}
+			return nil, err
+		}
+		logger.Info("The Application has been pruned.", "application", client.ObjectKeyFromObject(app))
+	}
+	return blocked, nil
+}
+
+// orphan 解除 Application 与 ApplicationSet 的归属关系，只移除本 ApplicationSet 的 ownerReference 和本控制器写入的元数据
+// 其他组件添加的 ownerReference、标签和注解保持不变
+func orphan(set *v1.ApplicationSet, app *v1.Application) {
+	var owners []metav1.OwnerReference
+	for _, owner := range app.GetOwnerReferences() {
+		if owner.UID != set.UID {
+			owners = append(owners, owner)
+		}
+	}
+	app.SetOwnerReferences(owners)
+	delete(app.Labels, v1.ApplicationSetLabel)
+	delete(app.Annotations, v1.SpecHashAnnotation)
+	delete(app.Annotations, v1.ManagedMetadataAnnotation)
+}
+
+// updateStatus 汇总生成的 Application 的就绪情况，blocked 不为空时设置 PruneBlocked Condition
+func (r *ApplicationSetReconciler) updateStatus(ctx context.Context, set *v1.ApplicationSet, desired []*v1.Application, blocked []string) error {
+	resources := make([]v1.ApplicationSetResource, 0, len(desired))
+	ready := int32(0)
+	for _, d := range desired {
+		app := &v1.Application{}
+		if err := r.Get(ctx, client.ObjectKeyFromObject(d), app); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		resource := v1.ApplicationSetResource{
+			Name:      d.Name,
+			Namespace: d.Namespace,
+			Ready:     meta.IsStatusConditionTrue(app.Status.Conditions, v1.ConditionTypeReady),
+		}
+		if resource.Ready {
+			ready++
+		}
+		resources = append(resources, resource)
+	}
+	sort.Slice(resources, func(i, j int) bool {
+		if resources[i].Namespace != resources[j].Namespace {
+			return resources[i].Namespace < resources[j].Namespace
+		}
+		return resources[i].Name < resources[j].Name
+	})
+
+	status := set.Status.DeepCopy()
+	status.Applications = int32(len(resources))
+	status.ReadyApplications = ready
+	status.Resources = resources
+	condition := metav1.Condition{
+		Type:               v1.ConditionTypeReady,
+		Status:             metav1.ConditionTrue,
+		Reason:             "ApplicationsReady",
+		Message:            fmt.Sprintf("%d/%d Applications are ready", ready, len(resources)),
+		ObservedGeneration: set.Generation,
+	}
+	if int(ready) < len(resources) {
+		condition.Status, condition.Reason = metav1.ConditionFalse, "ApplicationsNotReady"
+	}
+	meta.SetStatusCondition(&status.Conditions, condition)
+	if len(blocked) > 0 {
+		meta.SetStatusCondition(&status.Conditions, metav1.Condition{
+			Type:   v1.ConditionTypePruneBlocked,
+			Status: metav1.ConditionTrue,
+			Reason: "DeletionRejected",
+			Message: fmt.Sprintf("the deletion of %s was rejected, set the annotation %s to the name of each Application to confirm",
+				strings.Join(blocked, ", "), v1.ConfirmDeleteAnnotation),
+			ObservedGeneration: set.Generation,
+		})
+	} else {
+		meta.RemoveStatusCondition(&status.Conditions, v1.ConditionTypePruneBlocked)
+	}
+
+	if reflect.DeepEqual(status, &set.Status) {
+		return nil
+	}
+	set.Status = *status
+	return r.Status().Update(audit.WithReason(ctx, "StatusChanged"), set)
+}
+
+// setStatusError 将生成过程中的错误记录到 Ready Condition 中，并返回原始错误
+func (r *ApplicationSetReconciler) setStatusError(ctx context.Context, set *v1.ApplicationSet, reason string, err error) error {
+	meta.SetStatusCondition(&set.Status.Conditions, metav1.Condition{
+		Type:               v1.ConditionTypeReady,
+		Status:             metav1.ConditionFalse,
+		Reason:             reason,
+		Message:            err.Error(),
+		ObservedGeneration: set.Generation,
+	})
+	if updateErr := r.Status().Update(audit.WithReason(ctx, reason), set); updateErr != nil {
+		log.FromContext(ctx).Error(updateErr, "Failed to update ApplicationSet status.")
+	}
+	return err
+}
+
+// findApplicationSetsForNamespace 命名空间变化可能影响 namespaces/matrix 生成器的结果，重新调谐所有 ApplicationSet
+func (r *ApplicationSetReconciler) findApplicationSetsForNamespace(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+
+	sets := &v1.ApplicationSetList{}
+	if err := r.List(ctx, sets); err != nil {
+		logger.Error(err, "Failed to list ApplicationSets.")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(sets.Items))
+	for _, set := range sets.Items {
+		for _, generator := range set.Spec.Generators {
+			if generator.Namespaces != nil || generator.Matrix != nil {
+				requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&set)})
+				break
+			}
+		}
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ApplicationSetReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&v1.ApplicationSet{}, builder.WithPredicates(predicate.GenerationChangedPredicate{})).
+		// 生成的 Application 的 spec 或就绪状态变化时重新汇总，忽略其他 status 的变化
+		Owns(&v1.Application{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{}, dependencyReadyChanged))).
+		Watches(&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.findApplicationSetsForNamespace),
+			builder.WithPredicates(predicate.LabelChangedPredicate{})).
+		Named(ApplicationSetControllerName).
+		WithOptions(r.Options).
+		Complete(instrument(ApplicationSetControllerName, "ApplicationSet", r, r.Tracker, r.Drainer))
+}
@@ -0,0 +1,300 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func newTestApplicationSet() *v1.ApplicationSet {
+	set := &v1.ApplicationSet{}
+	set.Name, set.Namespace, set.UID = "web", "default", "uid-web"
+	set.Spec.Template.Metadata = v1.ApplicationSetTemplateMetadata{
+		Name:      "{{name}}",
+		Namespace: "default",
+		Labels:    map[string]string{"app": "{{name}}"},
+	}
+	return set
+}
+
+func TestSubstituteParams(t *testing.T) {
+	tests := []struct {
+		name     string
+		template string
+		param    map[string]string
+		want     string
+	}{
+		{
+			name:     "every occurrence is replaced",
+			template: `{"name":"{{name}}","labels":{"app":"{{name}}","env":"{{env}}"}}`,
+			param:    map[string]string{"name": "payments", "env": "prod"},
+			want:     `{"name":"payments","labels":{"app":"payments","env":"prod"}}`,
+		},
+		{
+			name:     "unknown keys are left untouched",
+			template: `{"name":"{{name}}","namespace":"{{namespace}}"}`,
+			param:    map[string]string{"name": "payments"},
+			want:     `{"name":"payments","namespace":"{{namespace}}"}`,
+		},
+		{
+			name:     "values are escaped as JSON strings",
+			template: `{"description":"{{description}}"}`,
+			param:    map[string]string{"description": `say "hi"` + "\n"},
+			want:     `{"description":"say \"hi\"\n"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := substituteParams(tt.template, tt.param)
+			if err != nil {
+				t.Fatalf("substitute params: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("substituteParams() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateParams(t *testing.T) {
+	newNamespace := func(name, tier string, terminating bool) *corev1.Namespace {
+		ns := &corev1.Namespace{}
+		ns.Name = name
+		ns.Labels = map[string]string{"tier": tier}
+		if terminating {
+			now := metav1.Now()
+			ns.DeletionTimestamp = &now
+			ns.Finalizers = []string{"kubernetes"}
+		}
+		return ns
+	}
+	scheme := newTestScheme(t)
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(
+		newNamespace("team-a", "web", false),
+		newNamespace("team-b", "web", false),
+		newNamespace("team-c", "web", true),
+		newNamespace("team-d", "db", false),
+	).Build()
+	r := &ApplicationSetReconciler{Client: c, Scheme: scheme}
+	web := v1.NamespacesGenerator{Selector: metav1.LabelSelector{MatchLabels: map[string]string{"tier": "web"}}}
+
+	tests := []struct {
+		name       string
+		generators []v1.ApplicationSetGenerator
+		want       []map[string]string
+	}{
+		{
+			name:       "list",
+			generators: []v1.ApplicationSetGenerator{{List: &v1.ListGenerator{Elements: []map[string]string{{"name": "payments"}}}}},
+			want:       []map[string]string{{"name": "payments"}},
+		},
+		{
+			name:       "namespaces skip terminating namespaces",
+			generators: []v1.ApplicationSetGenerator{{Namespaces: &web}},
+			want: []map[string]string{
+				{"namespace": "team-a", "namespace.labels.tier": "web"},
+				{"namespace": "team-b", "namespace.labels.tier": "web"},
+			},
+		},
+		{
+			name: "matrix combines every element with every namespace",
+			generators: []v1.ApplicationSetGenerator{{Matrix: &v1.MatrixGenerator{
+				List:       v1.ListGenerator{Elements: []map[string]string{{"name": "payments"}, {"name": "orders"}}},
+				Namespaces: web,
+			}}},
+			want: []map[string]string{
+				{"name": "payments", "namespace": "team-a", "namespace.labels.tier": "web"},
+				{"name": "payments", "namespace": "team-b", "namespace.labels.tier": "web"},
+				{"name": "orders", "namespace": "team-a", "namespace.labels.tier": "web"},
+				{"name": "orders", "namespace": "team-b", "namespace.labels.tier": "web"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := newTestApplicationSet()
+			set.Spec.Generators = tt.generators
+			params, err := r.generateParams(context.Background(), set)
+			if err != nil {
+				t.Fatalf("generate params: %v", err)
+			}
+			if !reflect.DeepEqual(params, tt.want) {
+				t.Errorf("generateParams() = %v, want %v", params, tt.want)
+			}
+		})
+	}
+
+	set := newTestApplicationSet()
+	set.Spec.Generators = []v1.ApplicationSetGenerator{{}}
+	if _, err := r.generateParams(context.Background(), set); err == nil {
+		t.Errorf("a generator without any type must be rejected")
+	}
+}
+
+func TestRenderApplicationsSkipsDuplicates(t *testing.T) {
+	set := newTestApplicationSet()
+	apps, err := renderApplications(set, []map[string]string{{"name": "payments"}, {"name": "payments"}, {"name": "orders"}})
+	if err != nil {
+		t.Fatalf("render Applications: %v", err)
+	}
+	if len(apps) != 2 || apps[0].Name != "payments" || apps[1].Name != "orders" {
+		t.Fatalf("expected payments and orders, got %d Applications", len(apps))
+	}
+	if apps[0].Labels["app"] != "payments" || apps[0].Labels[v1.ApplicationSetLabel] != set.Name {
+		t.Errorf("labels = %v, want the rendered labels and the ApplicationSet label", apps[0].Labels)
+	}
+
+	if _, err := renderApplications(set, []map[string]string{{"name": ""}}); err == nil {
+		t.Errorf("an empty rendered name must be rejected")
+	}
+}
+
+func TestApplyApplicationIgnoresDefaultedFields(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	r := &ApplicationSetReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).Build(), Scheme: scheme}
+	set := newTestApplicationSet()
+
+	render := func() *v1.Application {
+		apps, err := renderApplications(set, []map[string]string{{"name": "payments"}})
+		if err != nil {
+			t.Fatalf("render Applications: %v", err)
+		}
+		return apps[0]
+	}
+	if err := r.applyApplication(ctx, set, render()); err != nil {
+		t.Fatalf("create Application: %v", err)
+	}
+
+	// 模拟 webhook 填充的默认值
+	app := &v1.Application{}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: "default", Name: "payments"}, app); err != nil {
+		t.Fatal(err)
+	}
+	replicas := int32(1)
+	app.Spec.Deployment.Replicas = &replicas
+	if err := r.Update(ctx, app); err != nil {
+		t.Fatal(err)
+	}
+	resourceVersion := app.ResourceVersion
+
+	if err := r.applyApplication(ctx, set, render()); err != nil {
+		t.Fatalf("apply unchanged Application: %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(app), app); err != nil {
+		t.Fatal(err)
+	}
+	if app.ResourceVersion != resourceVersion {
+		t.Errorf("unchanged template must not update the Application")
+	}
+
+	set.Spec.Template.Metadata.Labels = map[string]string{"tier": "web"}
+	if err := r.applyApplication(ctx, set, render()); err != nil {
+		t.Fatalf("apply changed Application: %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(app), app); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := app.Labels["app"]; ok || app.Labels["tier"] != "web" {
+		t.Errorf("labels = %v, want the app label replaced by tier", app.Labels)
+	}
+}
+
+func TestPruneApplicationsReportsRejectedDeletions(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	set := newTestApplicationSet()
+	app := &v1.Application{}
+	app.Name, app.Namespace = "payments", "default"
+	app.Labels = map[string]string{v1.ApplicationSetLabel: set.Name}
+	if err := ctrl.SetControllerReference(set, app, scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	c := interceptor.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(set, app).WithStatusSubresource(set).Build(), interceptor.Funcs{
+		Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+			return errors.NewForbidden(v1.GroupVersion.WithResource("applications").GroupResource(), obj.GetName(),
+				fmt.Errorf("it is marked with %s=true", v1.ProtectedAnnotation))
+		},
+	})
+	r := &ApplicationSetReconciler{Client: c, Scheme: scheme}
+
+	blocked, err := r.pruneApplications(ctx, set, nil)
+	if err != nil {
+		t.Fatalf("rejected deletions must not fail the prune: %v", err)
+	}
+	if len(blocked) != 1 || blocked[0] != "default/payments" {
+		t.Errorf("blocked = %v, want [default/payments]", blocked)
+	}
+
+	if err := r.updateStatus(ctx, set, nil, blocked); err != nil {
+		t.Fatalf("update status: %v", err)
+	}
+	if !meta.IsStatusConditionTrue(set.Status.Conditions, v1.ConditionTypePruneBlocked) {
+		t.Errorf("conditions = %v, want PruneBlocked", set.Status.Conditions)
+	}
+}
+
+func TestPruneApplicationsOrphan(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	set := newTestApplicationSet()
+	set.Spec.PrunePolicy = v1.PrunePolicyOrphan
+	apps, err := renderApplications(set, []map[string]string{{"name": "payments"}})
+	if err != nil {
+		t.Fatalf("render Applications: %v", err)
+	}
+	app := apps[0]
+	app.Annotations["team"] = "payments"
+	foreign := metav1.OwnerReference{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: "uid-owner"}
+	app.SetOwnerReferences([]metav1.OwnerReference{foreign})
+	if err := ctrl.SetControllerReference(set, app, scheme); err != nil {
+		t.Fatal(err)
+	}
+	r := &ApplicationSetReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(app).Build(), Scheme: scheme}
+
+	if _, err := r.pruneApplications(ctx, set, nil); err != nil {
+		t.Fatalf("prune Applications: %v", err)
+	}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(app), app); err != nil {
+		t.Fatalf("the orphaned Application must be kept: %v", err)
+	}
+	if !reflect.DeepEqual(app.OwnerReferences, []metav1.OwnerReference{foreign}) {
+		t.Errorf("ownerReferences = %v, want only the foreign owner", app.OwnerReferences)
+	}
+	if _, ok := app.Labels[v1.ApplicationSetLabel]; ok {
+		t.Errorf("labels = %v, want the ApplicationSet label removed", app.Labels)
+	}
+	want := map[string]string{"team": "payments"}
+	if !reflect.DeepEqual(app.Annotations, want) {
+		t.Errorf("annotations = %v, want %v", app.Annotations, want)
+	}
+}
@@ -0,0 +1,38 @@
+package controller
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/debug"
+)
+
+var _ debug.DesiredStateRenderer = &ApplicationReconciler{}
+
+// DesiredState 渲染 Application 的期望子资源但不写入集群，供管理端点排查调谐结果与预期不符的 Application
+func (r *ApplicationReconciler) DesiredState(ctx context.Context, key types.NamespacedName) ([]client.Object, error) {
+	app := &v1.Application{}
+	if err := r.Get(ctx, key, app); err != nil {
+		return nil, err
+	}
+	states, err := r.renderDesiredStates(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []client.Object
+	for _, state := range states {
+		for _, obj := range []client.Object{state.Deployment, state.Service} {
+			// 渲染出的对象不带 apiVersion 和 kind，补全后便于阅读
+			if gvk, err := apiutil.GVKForObject(obj, r.Scheme); err == nil {
+				obj.GetObjectKind().SetGroupVersionKind(gvk)
+			}
+			objects = append(objects, obj)
+		}
+	}
+	return objects, nil
+}
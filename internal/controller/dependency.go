@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+const (
+	// dependsOnIndexField 是 spec.dependsOn 的索引字段，值为被依赖 Application 的 namespace/name
+	dependsOnIndexField = "spec.dependsOn"
+)
+
+// dependencyKey 返回 dependsOn 中引用的 Application，未指定 namespace 时默认与 app 相同
+func dependencyKey(app *v1.Application, ref v1.ApplicationReference) types.NamespacedName {
+	namespace := ref.Namespace
+	if namespace == "" {
+		namespace = app.Namespace
+	}
+	return types.NamespacedName{Namespace: namespace, Name: ref.Name}
+}
+
+// indexDependsOn 为 spec.dependsOn 建立索引，用于在依赖发生变化时反查依赖它的 Application
+func indexDependsOn(obj client.Object) []string {
+	app := obj.(*v1.Application)
+	keys := make([]string, 0, len(app.Spec.DependsOn))
+	for _, ref := range app.Spec.DependsOn {
+		keys = append(keys, dependencyKey(app, ref).String())
+	}
+	return keys
+}
+
+// findDependents 将被依赖 Application 的变化映射为对所有依赖方的调谐请求
+func (r *ApplicationReconciler) findDependents(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+
+	apps := &v1.ApplicationList{}
+	if err := r.List(ctx, apps, client.MatchingFields{dependsOnIndexField: client.ObjectKeyFromObject(obj).String()}); err != nil {
+		logger.Error(err, "Failed to list dependent Applications.")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(apps.Items))
+	for _, app := range apps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&app)})
+	}
+	return requests
+}
+
+// dependencyReadyChanged 只关注被依赖 Application 的创建、删除以及 Ready Condition 的变化
+var dependencyReadyChanged = predicate.Funcs{
+	UpdateFunc: func(event event.UpdateEvent) bool {
+		oldApp, newApp := event.ObjectOld.(*v1.Application), event.ObjectNew.(*v1.Application)
+		return meta.IsStatusConditionTrue(oldApp.Status.Conditions, v1.ConditionTypeReady) !=
+			meta.IsStatusConditionTrue(newApp.Status.Conditions, v1.ConditionTypeReady)
+	},
+	GenericFunc: func(event event.GenericEvent) bool {
+		return false
+	},
+}
+
+// findDependencyCycle 以深度优先的方式遍历依赖图，返回遇到的第一个环上的路径
+// 环不一定经过 app 自身：依赖之间成环时 app 同样永远等不到依赖就绪
+func (r *ApplicationReconciler) findDependencyCycle(ctx context.Context, app *v1.Application) ([]string, error) {
+	start := client.ObjectKeyFromObject(app)
+	// onPath 记录当前遍历路径上各节点在 path 中的位置，visited 记录已遍历完且不在环上的节点
+	onPath := map[types.NamespacedName]int{start: 0}
+	visited := map[types.NamespacedName]bool{}
+
+	var visit func(cur *v1.Application, path []string) ([]string, error)
+	visit = func(cur *v1.Application, path []string) ([]string, error) {
+		for _, ref := range cur.Spec.DependsOn {
+			key := dependencyKey(cur, ref)
+			if i, ok := onPath[key]; ok {
+				return append(append([]string{}, path[i:]...), key.String()), nil
+			}
+			if visited[key] {
+				continue
+			}
+
+			dep := &v1.Application{}
+			if err := r.Get(ctx, key, dep); err != nil {
+				if errors.IsNotFound(err) {
+					visited[key] = true
+					continue
+				}
+				return nil, err
+			}
+			onPath[key] = len(path)
+			if cycle, err := visit(dep, append(path, key.String())); err != nil || cycle != nil {
+				return cycle, err
+			}
+			delete(onPath, key)
+			visited[key] = true
+		}
+		return nil, nil
+	}
+
+	return visit(app, []string{start.String()})
+}
+
+// reconcileDependencies 检查 dependsOn 中的依赖是否均已就绪，并维护 WaitingForDependencies Condition
+// 依赖未就绪或存在循环依赖时，子资源的调谐函数将暂缓变更子资源
+func (r *ApplicationReconciler) reconcileDependencies(ctx context.Context, app *v1.Application) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if len(app.Spec.DependsOn) == 0 {
+		if meta.FindStatusCondition(app.Status.Conditions, v1.ConditionTypeWaitingForDependencies) == nil {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeWaitingForDependencies,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NoDependencies",
+			Message: "No dependencies are declared",
+		})
+	}
+
+	// 检测循环依赖，存在环时依赖永远无法就绪，需要用户介入
+	cycle, err := r.findDependencyCycle(ctx, app)
+	if err != nil {
+		logger.Error(err, "Failed to detect dependency cycles, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+	if cycle != nil {
+		logger.Info("A dependency cycle has been detected.", "cycle", cycle)
+		return ctrl.Result{}, r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeWaitingForDependencies,
+			Status:  metav1.ConditionTrue,
+			Reason:  "DependencyCycle",
+			Message: "Dependency cycle detected: " + strings.Join(cycle, " -> "),
+		})
+	}
+
+	// 收集尚未就绪的依赖
+	var blocking []string
+	for _, ref := range app.Spec.DependsOn {
+		key := dependencyKey(app, ref)
+		dep := &v1.Application{}
+		if err := r.Get(ctx, key, dep); err != nil {
+			if errors.IsNotFound(err) {
+				blocking = append(blocking, fmt.Sprintf("%s (not found)", key))
+				continue
+			}
+			logger.Error(err, "Failed to get dependency, will requeue after a short time.", "dependency", key)
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+		}
+		if !meta.IsStatusConditionTrue(dep.Status.Conditions, v1.ConditionTypeReady) {
+			blocking = append(blocking, key.String())
+		}
+	}
+
+	if len(blocking) > 0 {
+		logger.Info("Waiting for dependencies to become Ready.", "blocking", blocking)
+		return ctrl.Result{}, r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeWaitingForDependencies,
+			Status:  metav1.ConditionTrue,
+			Reason:  "DependenciesNotReady",
+			Message: "Waiting for dependencies: " + strings.Join(blocking, ", "),
+		})
+	}
+
+	return ctrl.Result{}, r.setCondition(ctx, app, metav1.Condition{
+		Type:    v1.ConditionTypeWaitingForDependencies,
+		Status:  metav1.ConditionFalse,
+		Reason:  "DependenciesReady",
+		Message: "All dependencies are Ready",
+	})
+}
@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// newDependentApplication 返回 default 命名空间中依赖 deps 的 Application
+func newDependentApplication(name string, deps ...v1.ApplicationReference) *v1.Application {
+	app := &v1.Application{}
+	app.Name, app.Namespace = name, "default"
+	app.Spec.DependsOn = deps
+	return app
+}
+
+func TestFindDependencyCycle(t *testing.T) {
+	tests := []struct {
+		name string
+		apps []client.Object
+		want []string
+	}{
+		{
+			name: "no dependencies",
+			apps: []client.Object{newDependentApplication("a")},
+		},
+		{
+			name: "a chain without a cycle",
+			apps: []client.Object{
+				newDependentApplication("a", v1.ApplicationReference{Name: "b"}),
+				newDependentApplication("b", v1.ApplicationReference{Name: "c"}),
+				newDependentApplication("c"),
+			},
+		},
+		{
+			name: "a missing dependency",
+			apps: []client.Object{newDependentApplication("a", v1.ApplicationReference{Name: "missing"})},
+		},
+		{
+			name: "a depends on itself",
+			apps: []client.Object{newDependentApplication("a", v1.ApplicationReference{Name: "a"})},
+			want: []string{"default/a", "default/a"},
+		},
+		{
+			name: "a cycle through another namespace",
+			apps: []client.Object{
+				newDependentApplication("a", v1.ApplicationReference{Name: "b", Namespace: "other"}),
+				func() client.Object {
+					app := newDependentApplication("b", v1.ApplicationReference{Name: "a", Namespace: "default"})
+					app.Namespace = "other"
+					return app
+				}(),
+			},
+			want: []string{"default/a", "other/b", "default/a"},
+		},
+		{
+			name: "a cycle that does not include the Application",
+			apps: []client.Object{
+				newDependentApplication("a", v1.ApplicationReference{Name: "b"}),
+				newDependentApplication("b", v1.ApplicationReference{Name: "c"}),
+				newDependentApplication("c", v1.ApplicationReference{Name: "b"}),
+			},
+			want: []string{"default/b", "default/c", "default/b"},
+		},
+		{
+			name: "a shared dependency is not a cycle",
+			apps: []client.Object{
+				newDependentApplication("a", v1.ApplicationReference{Name: "b"}, v1.ApplicationReference{Name: "c"}),
+				newDependentApplication("b", v1.ApplicationReference{Name: "c"}),
+				newDependentApplication("c"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scheme := newTestScheme(t)
+			r := &ApplicationReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(tt.apps...).Build(), Scheme: scheme}
+
+			cycle, err := r.findDependencyCycle(context.Background(), tt.apps[0].(*v1.Application))
+			if err != nil {
+				t.Fatalf("find dependency cycle: %v", err)
+			}
+			if !reflect.DeepEqual(cycle, tt.want) {
+				t.Errorf("findDependencyCycle() = %v, want %v", cycle, tt.want)
+			}
+		})
+	}
+}
@@ -4,28 +4,79 @@ import (
 	"context"
 	"reflect"
 
+	"go.opentelemetry.io/otel/attribute"
 	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/featuregate"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
 )
 
-func (r *ApplicationReconciler) reconcileDeployment(ctx context.Context, app *v1.Application) (
-	ctrl.Result, error) {
-	logger := log.FromContext(ctx)
+func (r *ApplicationReconciler) reconcileDeployment(ctx context.Context, app *v1.Application, state *desiredState) (
+	result ctrl.Result, err error) {
+	desired := state.Deployment
+	ctx, span := tracing.Start(ctx, "reconcileDeployment",
+		attribute.String("environment", state.Environment),
+		attribute.String("k8s.namespace.name", desired.Namespace),
+		attribute.String("k8s.object.name", desired.Name),
+	)
+	defer func() { tracing.End(span, err) }()
+	logger := log.FromContext(ctx).WithValues("environment", state.Environment)
 
 	// Get Deployment
 	var dp = &appsv1.Deployment{}
-	err := r.Get(ctx, types.NamespacedName{
-		Namespace: app.Namespace,
-		Name:      app.Name,
+	err = r.Get(ctx, types.NamespacedName{
+		Namespace: desired.Namespace,
+		Name:      desired.Name,
 	}, dp)
 
 	if err == nil {
 		logger.Info("The Deplyment has already exist.")
+		if err := checkOwnership(app, dp); err != nil {
+			logger.Error(err, "The Deployment is not managed by this Application, will requeue after a short time.")
+			if condErr := r.reportOwnershipConflict(ctx, app, err); condErr != nil {
+				return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, condErr
+			}
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+		}
+		// Application 或其引用的模板发生变化时，期望状态摘要随之变化，此时更新 Deployment
+		// 开启 DriftCorrection 时，Deployment 在 Operator 之外被修改后同样恢复为期望状态
+		if !rolloutHeld(app) && (dp.Annotations[v1.SpecHashAnnotation] != desired.Annotations[v1.SpecHashAnnotation] ||
+			featuregate.Enabled(featuregate.DriftCorrection) && drifted(desired.Spec, dp.Spec)) {
+			// 期望状态摘要未变化时，是 DriftCorrection 恢复了 Operator 之外的修改
+			reason := "DesiredStateChanged"
+			if dp.Annotations[v1.SpecHashAnnotation] == desired.Annotations[v1.SpecHashAnnotation] {
+				reason = "DriftCorrected"
+			}
+			// selector 不可变更，只有声明了允许修改不可变字段的注解时才删除重建
+			if !equality.Semantic.DeepEqual(dp.Spec.Selector, desired.Spec.Selector) {
+				return r.recreateChild(ctx, app, dp)
+			}
+			syncManagedMetadata(dp, desired)
+			dp.Spec = desired.Spec
+			if err := r.Update(audit.WithReason(ctx, reason), dp); err != nil {
+				logger.Error(err, "Failed to update Deployment, will requeue after a short time.")
+				return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+			}
+			logger.Info("The Deployment has been updated.")
+			tracing.Eventf(ctx, r.Recorder, app, corev1.EventTypeNormal, "DeploymentUpdated", "Updated Deployment %s/%s", dp.Namespace, dp.Name)
+		}
+
+		// 环境子资源的状态同步到 status.environments
+		if state.Environment != "" {
+			if err := r.updateEnvironmentStatus(ctx, app, state, dp.Status); err != nil {
+				return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+			}
+			return ctrl.Result{}, nil
+		}
+
 		// 判断 dp.Status 和 app.Status.Workflow 是否相等
 		if reflect.DeepEqual(dp.Status, app.Status.Workflow) {
 			return ctrl.Result{}, nil
@@ -33,7 +84,7 @@ func (r *ApplicationReconciler) reconcileDeployment(ctx context.Context, app *v1
 
 		app.Status.Workflow = dp.Status
 		// 若不相等，则触发更新
-		if err := r.Status().Update(ctx, app); err != nil {
+		if err := r.Status().Update(audit.WithReason(ctx, "DeploymentStatusChanged"), app); err != nil {
 			logger.Error(err, "Failed to update Application deployment status.")
 			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 		}
@@ -47,24 +98,26 @@ func (r *ApplicationReconciler) reconcileDeployment(ctx context.Context, app *v1
 		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 	}
 
+	// 处于暂停状态或依赖尚未就绪时不创建子资源
+	if rolloutHeld(app) {
+		logger.Info("The rollout of the Application is held, skip creating Deployment.")
+		return ctrl.Result{}, nil
+	}
+
 	// 若 NotFound，则触发 Create
-	newDp := &appsv1.Deployment{}
-	newDp.SetName(app.Name)
-	newDp.SetNamespace(app.Namespace)
-	newDp.SetLabels(app.Labels)
-	newDp.Spec = app.Spec.Deployment.DeploymentSpec
-	newDp.Spec.Template.SetLabels(app.Labels)
+	newDp := desired.DeepCopy()
 
 	// 将当前创建的 newDp 设置为 Application 类型的 app 资源的子资源
-	if err := ctrl.SetControllerReference(app, newDp, r.Scheme); err != nil {
+	if err := r.setOwner(app, newDp); err != nil {
 		logger.Error(err, "Failed to Set ControllerReference, will requeue after a short time.")
 		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 	}
-	if err := r.Create(ctx, newDp); err != nil {
+	if err := r.Create(audit.WithReason(ctx, "ChildMissing"), newDp); err != nil {
 		logger.Error(err, "Failed to Create Deployment, will requeue after a short time.")
 		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 	}
 
 	logger.Info("The Deployment has been created")
+	tracing.Eventf(ctx, r.Recorder, app, corev1.EventTypeNormal, "DeploymentCreated", "Created Deployment %s/%s", newDp.Namespace, newDp.Name)
 	return ctrl.Result{}, nil
 }
@@ -0,0 +1,296 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+)
+
+// ownerLabels 返回标识子资源归属于 app 的标签
+func ownerLabels(app *v1.Application) client.MatchingLabels {
+	return client.MatchingLabels{
+		v1.OwnerNameLabel:      app.Name,
+		v1.OwnerNamespaceLabel: app.Namespace,
+	}
+}
+
+// checkOwnership 确认已存在的子资源归属于 app，拒绝接管其他 Application 渲染出的或由其他方式创建的同名资源
+// 否则接管后的资源会被覆盖，并在环境移除或 Application 删除时被一并删除
+func checkOwnership(app *v1.Application, obj client.Object) error {
+	labels := obj.GetLabels()
+	name, namespace := labels[v1.OwnerNameLabel], labels[v1.OwnerNamespaceLabel]
+	if name == app.Name && namespace == app.Namespace {
+		return nil
+	}
+	if name != "" || namespace != "" {
+		return fmt.Errorf("%s is owned by Application %s/%s", client.ObjectKeyFromObject(obj), namespace, name)
+	}
+	// 引入 owner 标签之前创建的子资源只有指向 app 的 OwnerReference
+	if owner := metav1.GetControllerOf(obj); owner != nil && owner.UID == app.UID {
+		return nil
+	}
+	return fmt.Errorf("%s already exists and is not managed by Application %s/%s",
+		client.ObjectKeyFromObject(obj), app.Namespace, app.Name)
+}
+
+// reportOwnershipConflict 通过 ReconcileError Condition 告知用户子资源的名称已被占用
+func (r *ApplicationReconciler) reportOwnershipConflict(ctx context.Context, app *v1.Application, err error) error {
+	return r.setCondition(ctx, app, metav1.Condition{
+		Type:    v1.ConditionTypeReconcileError,
+		Status:  metav1.ConditionTrue,
+		Reason:  "ChildNotOwned",
+		Message: err.Error(),
+	})
+}
+
+// unwatchedEnvironments 返回渲染到 Manager 未监听的命名空间中的环境
+// Manager 的缓存只包含监听的命名空间，读取其他命名空间中的子资源会失败，这些环境无法调谐
+func (r *ApplicationReconciler) unwatchedEnvironments(states []*desiredState) []string {
+	if len(r.WatchedNamespaces) == 0 {
+		return nil
+	}
+	watched := sets.New(r.WatchedNamespaces...)
+	var unwatched []string
+	for _, state := range states {
+		if namespace := state.Deployment.Namespace; !watched.Has(namespace) {
+			unwatched = append(unwatched, fmt.Sprintf("%s (namespace %s)", state.Environment, namespace))
+		}
+	}
+	return unwatched
+}
+
+// reportUnwatchedEnvironments 通过 ReconcileError Condition 告知用户哪些环境的命名空间不在 Manager 的监听范围内
+func (r *ApplicationReconciler) reportUnwatchedEnvironments(ctx context.Context, app *v1.Application, unwatched []string) error {
+	return r.setCondition(ctx, app, metav1.Condition{
+		Type:   v1.ConditionTypeReconcileError,
+		Status: metav1.ConditionTrue,
+		Reason: "NamespaceNotWatched",
+		Message: fmt.Sprintf("Environments %s render into namespaces the manager does not watch, "+
+			"add the namespaces to the watched namespaces of the manager and restart it", strings.Join(unwatched, ", ")),
+	})
+}
+
+// setOwner 设置子资源的归属：同一命名空间内使用 OwnerReference，跨命名空间时依赖 owner 标签和 Finalizer
+func (r *ApplicationReconciler) setOwner(app *v1.Application, obj client.Object) error {
+	if obj.GetNamespace() != app.Namespace {
+		return nil
+	}
+	return ctrl.SetControllerReference(app, obj, r.Scheme)
+}
+
+// findOwnerByLabels 将跨命名空间子资源的变化映射为对所属 Application 的调谐请求
+// 同一命名空间内的子资源已经通过 Owns 监听，这里只处理没有 OwnerReference 的子资源
+func (r *ApplicationReconciler) findOwnerByLabels(ctx context.Context, obj client.Object) []reconcile.Request {
+	if metav1.GetControllerOf(obj) != nil {
+		return nil
+	}
+	labels := obj.GetLabels()
+	name, namespace := labels[v1.OwnerNameLabel], labels[v1.OwnerNamespaceLabel]
+	if name == "" || namespace == "" {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: types.NamespacedName{Namespace: namespace, Name: name}}}
+}
+
+// reconcileEnvironmentsFinalizer 声明了 environments 时为 Application 添加 Finalizer，以便删除时清理其他命名空间中的子资源
+func (r *ApplicationReconciler) reconcileEnvironmentsFinalizer(ctx context.Context, app *v1.Application) error {
+	logger := log.FromContext(ctx)
+
+	if len(app.Spec.Environments) == 0 || controllerutil.ContainsFinalizer(app, v1.EnvironmentsFinalizer) {
+		return nil
+	}
+
+	controllerutil.AddFinalizer(app, v1.EnvironmentsFinalizer)
+	if err := r.Update(audit.WithReason(ctx, "FinalizerAdded"), app); err != nil {
+		logger.Error(err, "Failed to add the environments finalizer.")
+		return err
+	}
+	logger.Info("The environments finalizer has been added.")
+	return nil
+}
+
+// finalizeEnvironments 在 Application 删除时清理所有环境子资源并移除 Finalizer
+func (r *ApplicationReconciler) finalizeEnvironments(ctx context.Context, app *v1.Application) (ctrl.Result, error) {
+	logger := log.FromContext(ctx)
+
+	if !controllerutil.ContainsFinalizer(app, v1.EnvironmentsFinalizer) {
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.pruneChildren(ctx, app, nil); err != nil {
+		logger.Error(err, "Failed to clean up environments, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	controllerutil.RemoveFinalizer(app, v1.EnvironmentsFinalizer)
+	if err := r.Update(audit.WithReason(ctx, "FinalizerRemoved"), app); err != nil {
+		logger.Error(err, "Failed to remove the environments finalizer.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	logger.Info("The environments have been cleaned up.")
+	return ctrl.Result{}, nil
+}
+
+// pruneChildren 删除归属于 app 但不在期望状态中的子资源，例如从 environments 中移除的环境
+func (r *ApplicationReconciler) pruneChildren(ctx context.Context, app *v1.Application, states []*desiredState) error {
+	logger := log.FromContext(ctx)
+	ctx = audit.WithReason(ctx, "Pruned")
+
+	desired := map[types.NamespacedName]bool{}
+	for _, state := range states {
+		desired[client.ObjectKeyFromObject(state.Deployment)] = true
+	}
+
+	deployments := &appsv1.DeploymentList{}
+	if err := r.List(ctx, deployments, ownerLabels(app)); err != nil {
+		return err
+	}
+	for i := range deployments.Items {
+		dp := &deployments.Items[i]
+		if desired[client.ObjectKeyFromObject(dp)] {
+			continue
+		}
+		if err := r.Delete(ctx, dp); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("The Deployment has been pruned.", "deployment", client.ObjectKeyFromObject(dp))
+	}
+
+	services := &corev1.ServiceList{}
+	if err := r.List(ctx, services, ownerLabels(app)); err != nil {
+		return err
+	}
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if desired[client.ObjectKeyFromObject(svc)] {
+			continue
+		}
+		if err := r.Delete(ctx, svc); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		logger.Info("The Service has been pruned.", "service", client.ObjectKeyFromObject(svc))
+	}
+
+	return nil
+}
+
+// updateEnvironmentStatus 将环境中 Deployment 的状态同步到 status.environments
+func (r *ApplicationReconciler) updateEnvironmentStatus(ctx context.Context, app *v1.Application, state *desiredState,
+	dpStatus appsv1.DeploymentStatus) error {
+	logger := log.FromContext(ctx)
+
+	desiredReplicas := int32(1)
+	if state.Deployment.Spec.Replicas != nil {
+		desiredReplicas = *state.Deployment.Spec.Replicas
+	}
+	envStatus := v1.EnvironmentStatus{
+		Name:              state.Environment,
+		Namespace:         state.Deployment.Namespace,
+		Replicas:          desiredReplicas,
+		UpdatedReplicas:   dpStatus.UpdatedReplicas,
+		AvailableReplicas: dpStatus.AvailableReplicas,
+		Ready:             dpStatus.AvailableReplicas >= desiredReplicas && dpStatus.UpdatedReplicas >= desiredReplicas,
+	}
+
+	found := false
+	for i := range app.Status.Environments {
+		if app.Status.Environments[i].Name != state.Environment {
+			continue
+		}
+		if app.Status.Environments[i] == envStatus {
+			return nil
+		}
+		app.Status.Environments[i] = envStatus
+		found = true
+	}
+	if !found {
+		app.Status.Environments = append(app.Status.Environments, envStatus)
+	}
+
+	if err := r.Status().Update(audit.WithReason(ctx, "EnvironmentStatusChanged"), app); err != nil {
+		logger.Error(err, "Failed to update Application environment status.", "environment", state.Environment)
+		return err
+	}
+	return nil
+}
+
+// pruneEnvironmentStatus 移除已不在 spec.environments 中的环境状态
+func (r *ApplicationReconciler) pruneEnvironmentStatus(ctx context.Context, app *v1.Application) error {
+	declared := map[string]bool{}
+	for _, env := range app.Spec.Environments {
+		declared[env.Name] = true
+	}
+
+	environments := make([]v1.EnvironmentStatus, 0, len(app.Status.Environments))
+	for _, envStatus := range app.Status.Environments {
+		if declared[envStatus.Name] {
+			environments = append(environments, envStatus)
+		}
+	}
+	if len(environments) == len(app.Status.Environments) {
+		return nil
+	}
+
+	app.Status.Environments = environments
+	return r.Status().Update(audit.WithReason(ctx, "EnvironmentRemoved"), app)
+}
+
+// recreateChild 删除不可变字段发生变化的子资源，删除事件会立即触发调谐并按期望状态重新创建
+// 只有 Application 带有允许修改不可变字段的注解时才会删除，否则等待用户处理
+// Deployment 以 Background 方式级联删除，对象立即消失，旧 Pod 由垃圾回收在新 Deployment 创建后陆续清理，缩短中断时间
+// 返回的 RequeueAfter 只是收不到删除事件时的兜底，同时让调用方推迟移除允许修改不可变字段的注解
+func (r *ApplicationReconciler) recreateChild(ctx context.Context, app *v1.Application, obj client.Object) (ctrl.Result, error) {
+	logger := log.FromContext(ctx).WithValues("object", client.ObjectKeyFromObject(obj))
+
+	// 正在删除中，等待删除事件触发重新创建
+	if !obj.GetDeletionTimestamp().IsZero() {
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, nil
+	}
+
+	if !app.AllowsImmutableChanges() {
+		err := fmt.Errorf("immutable fields of %s changed", client.ObjectKeyFromObject(obj))
+		logger.Error(err, "Immutable fields changed without the allow-immutable-changes annotation, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	if err := r.Delete(audit.WithReason(ctx, "ImmutableFieldsChanged"), obj, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "Failed to delete the child resource for recreation, will requeue after a short time.")
+		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+	}
+
+	logger.Info("The child resource has been deleted and will be recreated once the deletion is observed.")
+	return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, nil
+}
+
+// clearAllowImmutableChanges 移除允许修改不可变字段的注解，避免之后的变更在用户不知情的情况下删除重建子资源
+func (r *ApplicationReconciler) clearAllowImmutableChanges(ctx context.Context, app *v1.Application) error {
+	logger := log.FromContext(ctx)
+
+	if _, ok := app.Annotations[v1.AllowImmutableChangesAnnotation]; !ok {
+		return nil
+	}
+
+	delete(app.Annotations, v1.AllowImmutableChangesAnnotation)
+	if err := r.Update(audit.WithReason(ctx, "ImmutableChangesApplied"), app); err != nil {
+		logger.Error(err, "Failed to remove the allow-immutable-changes annotation.")
+		return err
+	}
+	logger.Info("The allow-immutable-changes annotation has been removed.")
+	return nil
+}
@@ -0,0 +1,229 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func TestCheckOwnership(t *testing.T) {
+	app := &v1.Application{}
+	app.Name, app.Namespace, app.UID = "demo", "default", "uid-demo"
+	controller := true
+
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		owner   *metav1.OwnerReference
+		wantErr bool
+	}{
+		{
+			name:   "owned through the owner labels",
+			labels: map[string]string{v1.OwnerNameLabel: "demo", v1.OwnerNamespaceLabel: "default"},
+		},
+		{
+			name:    "owned by another Application",
+			labels:  map[string]string{v1.OwnerNameLabel: "other", v1.OwnerNamespaceLabel: "default"},
+			wantErr: true,
+		},
+		{
+			name:  "created before the owner labels with a controller reference",
+			owner: &metav1.OwnerReference{Kind: "Application", Name: "demo", UID: "uid-demo", Controller: &controller},
+		},
+		{
+			name:    "created by something else",
+			labels:  map[string]string{"app": "demo"},
+			wantErr: true,
+		},
+		{
+			name:    "controlled by a recreated Application with the same name",
+			owner:   &metav1.OwnerReference{Kind: "Application", Name: "demo", UID: "uid-old", Controller: &controller},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dp := &appsv1.Deployment{}
+			dp.Name, dp.Namespace = "demo", "default"
+			dp.SetLabels(tt.labels)
+			if tt.owner != nil {
+				dp.SetOwnerReferences([]metav1.OwnerReference{*tt.owner})
+			}
+			if err := checkOwnership(app, dp); (err != nil) != tt.wantErr {
+				t.Errorf("checkOwnership() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplaceImageTag(t *testing.T) {
+	tests := []struct {
+		image string
+		want  string
+	}{
+		{image: "nginx", want: "nginx:1.26"},
+		{image: "nginx:1.25", want: "nginx:1.26"},
+		{image: "registry.example.com:5000/team/nginx", want: "registry.example.com:5000/team/nginx:1.26"},
+		{image: "registry.example.com:5000/team/nginx:1.25", want: "registry.example.com:5000/team/nginx:1.26"},
+		{image: "nginx:1.25@sha256:0123456789abcdef", want: "nginx:1.26"},
+		{image: "nginx@sha256:0123456789abcdef", want: "nginx:1.26"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.image, func(t *testing.T) {
+			if got := replaceImageTag(tt.image, "1.26"); got != tt.want {
+				t.Errorf("replaceImageTag(%q) = %q, want %q", tt.image, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyEnvironment(t *testing.T) {
+	replicas, envReplicas := int32(1), int32(3)
+	spec := v1.ApplicationSpec{}
+	spec.Deployment.Replicas = &replicas
+	spec.Deployment.Template.Spec.Containers = []corev1.Container{{
+		Name:  "web",
+		Image: "nginx:1.25",
+		Env:   []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}},
+		Resources: corev1.ResourceRequirements{
+			Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1"), corev1.ResourceMemory: resource.MustParse("1Gi")},
+		},
+	}}
+	env := v1.ApplicationEnvironment{
+		Name:      "prod",
+		Namespace: "team-a-prod",
+		Replicas:  &envReplicas,
+		ImageTag:  "1.26",
+		Resources: &corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")}},
+		Env:       []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "warn"}, {Name: "REGION", Value: "eu"}},
+	}
+
+	got := applyEnvironment(spec, env)
+	container := got.Deployment.Template.Spec.Containers[0]
+	if *got.Deployment.Replicas != 3 || container.Image != "nginx:1.26" {
+		t.Errorf("replicas = %d, image = %s, want the environment overrides", *got.Deployment.Replicas, container.Image)
+	}
+	if container.Resources.Limits.Cpu().String() != "2" || container.Resources.Limits.Memory().String() != "1Gi" {
+		t.Errorf("limits = %v, want the environment limits merged into the declared ones", container.Resources.Limits)
+	}
+	if len(container.Env) != 2 || container.Env[0].Value != "warn" || container.Env[1].Name != "REGION" {
+		t.Errorf("env = %v, want LOG_LEVEL replaced and REGION appended", container.Env)
+	}
+	// 叠加环境配置不修改 Application 自身声明的 spec
+	if original := spec.Deployment.Template.Spec.Containers[0]; *spec.Deployment.Replicas != 1 ||
+		original.Image != "nginx:1.25" || original.Env[0].Value != "info" {
+		t.Errorf("the declared spec was modified: %+v", spec.Deployment)
+	}
+}
+
+func TestClearAllowImmutableChanges(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	app := &v1.Application{}
+	app.Name, app.Namespace = "demo", "default"
+	app.Annotations = map[string]string{v1.AllowImmutableChangesAnnotation: "true", "team": "ops"}
+	r := &ApplicationReconciler{Client: fake.NewClientBuilder().WithScheme(scheme).WithObjects(app).Build(), Scheme: scheme}
+
+	if err := r.clearAllowImmutableChanges(ctx, app); err != nil {
+		t.Fatalf("clear annotation: %v", err)
+	}
+	stored := &v1.Application{}
+	if err := r.Get(ctx, client.ObjectKeyFromObject(app), stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored.AllowsImmutableChanges() || stored.Annotations["team"] != "ops" {
+		t.Errorf("annotations = %v, want only the allow-immutable-changes annotation removed", stored.Annotations)
+	}
+}
+
+func TestRecreateChild(t *testing.T) {
+	tests := []struct {
+		name        string
+		allowed     bool
+		terminating bool
+		wantErr     bool
+		wantDeleted bool
+	}{
+		{name: "immutable changes not allowed", wantErr: true},
+		{name: "allowed", allowed: true, wantDeleted: true},
+		{name: "already terminating", terminating: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			app := &v1.Application{}
+			app.Name, app.Namespace = "demo", "default"
+			if tt.allowed {
+				app.Annotations = map[string]string{v1.AllowImmutableChangesAnnotation: "true"}
+			}
+			dp := &appsv1.Deployment{}
+			dp.Name, dp.Namespace = "demo", "default"
+			if tt.terminating {
+				now := metav1.Now()
+				dp.DeletionTimestamp = &now
+				dp.Finalizers = []string{"foregroundDeletion"}
+			}
+			var propagation *metav1.DeletionPropagation
+			c := interceptor.NewClient(fake.NewClientBuilder().WithScheme(scheme).WithObjects(dp).Build(), interceptor.Funcs{
+				Delete: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+					deleteOpts := &client.DeleteOptions{}
+					deleteOpts.ApplyOptions(opts)
+					propagation = deleteOpts.PropagationPolicy
+					return c.Delete(ctx, obj, opts...)
+				},
+			})
+			r := &ApplicationReconciler{Client: c, Scheme: scheme}
+
+			result, err := r.recreateChild(ctx, app, dp)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("recreateChild() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if result.IsZero() {
+				t.Errorf("recreateChild() must return a non-zero result until the child is recreated")
+			}
+			if deleted := propagation != nil; deleted != tt.wantDeleted {
+				t.Fatalf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+			if tt.wantDeleted && *propagation != metav1.DeletePropagationBackground {
+				t.Errorf("propagation = %s, want Background so the child is gone at once", *propagation)
+			}
+		})
+	}
+}
+
+func TestUnwatchedEnvironments(t *testing.T) {
+	newState := func(env, namespace string) *desiredState {
+		dp := &appsv1.Deployment{}
+		dp.Name, dp.Namespace = "demo", namespace
+		return &desiredState{Environment: env, Deployment: dp}
+	}
+	states := []*desiredState{newState("", "team-a"), newState("prod", "team-a-prod")}
+
+	tests := []struct {
+		name    string
+		watched []string
+		want    int
+	}{
+		{name: "the whole cluster is watched"},
+		{name: "every namespace is watched", watched: []string{"team-a", "team-a-prod"}},
+		{name: "an environment renders into an unwatched namespace", watched: []string{"team-a"}, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ApplicationReconciler{WatchedNamespaces: tt.watched}
+			if got := r.unwatchedEnvironments(states); len(got) != tt.want {
+				t.Errorf("unwatchedEnvironments() = %v, want %d environments", got, tt.want)
+			}
+		})
+	}
+}
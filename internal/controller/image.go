@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/image"
+)
+
+// reconcileImages 维护 status.images，webhook 将镜像固定到 digest 后，仍可以从中看到各容器声明的 tag
+func (r *ApplicationReconciler) reconcileImages(ctx context.Context, app *v1.Application) error {
+	logger := log.FromContext(ctx)
+
+	images := imageStatus(app)
+	if apiequality.Semantic.DeepEqual(images, app.Status.Images) {
+		return nil
+	}
+
+	app.Status.Images = images
+	if err := r.Status().Update(audit.WithReason(ctx, "ImagesChanged"), app); err != nil {
+		logger.Error(err, "Failed to update Application images.")
+		return err
+	}
+	return nil
+}
+
+// imageStatus 根据 Deployment 模板中的镜像和 OriginalImagesAnnotation 注解生成 status.images
+func imageStatus(app *v1.Application) []v1.ImageStatus {
+	var images []v1.ImageStatus
+	originals := app.OriginalImages()
+	podSpec := &app.Spec.Deployment.Template.Spec
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for _, container := range containers {
+			if container.Image == "" {
+				continue
+			}
+			status := v1.ImageStatus{Container: container.Name, Image: container.Image}
+			if image.IsPinned(container.Image) {
+				status.Pinned = container.Image
+				if original, ok := originals[container.Name]; ok {
+					status.Image = original
+				}
+			}
+			images = append(images, status)
+		}
+	}
+	return images
+}
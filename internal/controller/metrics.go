@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// applicationPaused 记录每个 Application 是否处于暂停状态，1 为暂停，0 为正常调谐
+	applicationPaused = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "clusterops_application_paused",
+			Help: "Whether reconciliation of the Application's child resources is paused (1) or not (0).",
+		},
+		[]string{"namespace", "name"},
+	)
+
+	// applicationPausedReconciles 记录因暂停而跳过子资源变更的调谐次数
+	applicationPausedReconciles = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "clusterops_application_paused_reconciles_total",
+			Help: "Total number of reconciles that skipped mutating child resources because the Application is paused.",
+		},
+		[]string{"namespace", "name"},
+	)
+)
+
+func init() {
+	// 注册到 controller-runtime 的全局 Registry，随 Manager 的 metrics 端点一起暴露
+	metrics.Registry.MustRegister(
+		applicationPaused,
+		applicationPausedReconciles,
+	)
+}
@@ -0,0 +1,440 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/strategicpatch"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/security"
+)
+
+const (
+	// templateRefIndexField 是 spec.templateRef 的索引字段，值为 <Kind>/<namespace>/<name> 或 <Kind>/<name>
+	templateRefIndexField = "spec.templateRef"
+)
+
+// desiredState 是一次调谐中根据 Application(及其引用的模板、环境)渲染出的期望子资源
+type desiredState struct {
+	// Environment 为空表示渲染到 Application 自身所在的命名空间
+	Environment string
+	Deployment  *appsv1.Deployment
+	Service     *corev1.Service
+}
+
+// templateRefKey 返回 Application 引用模板的索引键，未引用模板时返回空字符串
+func templateRefKey(app *v1.Application) string {
+	ref := app.Spec.TemplateRef
+	if ref == nil {
+		return ""
+	}
+	if ref.Kind == "ClusterApplicationTemplate" {
+		return ref.Kind + "/" + ref.Name
+	}
+	return "ApplicationTemplate/" + types.NamespacedName{Namespace: app.Namespace, Name: ref.Name}.String()
+}
+
+// indexTemplateRef 为 spec.templateRef 建立索引，用于模板变化时反查引用它的 Application
+func indexTemplateRef(obj client.Object) []string {
+	if key := templateRefKey(obj.(*v1.Application)); key != "" {
+		return []string{key}
+	}
+	return nil
+}
+
+// getTemplate 获取 Application 引用的模板，未引用模板时返回 nil
+func (r *ApplicationReconciler) getTemplate(ctx context.Context, app *v1.Application) (*v1.ApplicationTemplateSpec, error) {
+	ref := app.Spec.TemplateRef
+	if ref == nil {
+		return nil, nil
+	}
+
+	if ref.Kind == "ClusterApplicationTemplate" {
+		tpl := &v1.ClusterApplicationTemplate{}
+		if err := r.Get(ctx, types.NamespacedName{Name: ref.Name}, tpl); err != nil {
+			return nil, err
+		}
+		return &tpl.Spec, nil
+	}
+
+	tpl := &v1.ApplicationTemplate{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: app.Namespace, Name: ref.Name}, tpl); err != nil {
+		return nil, err
+	}
+	return &tpl.Spec, nil
+}
+
+// renderDesiredStates 渲染 Application 的所有期望子资源
+// 未声明 environments 时渲染到 Application 自身所在的命名空间，否则为每个环境分别渲染一份
+func (r *ApplicationReconciler) renderDesiredStates(ctx context.Context, app *v1.Application) ([]*desiredState, error) {
+	tpl, err := r.getTemplate(ctx, app)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(app.Spec.Environments) == 0 {
+		state, err := renderDesiredState(app, tpl, app.Spec, app.Namespace, "")
+		if err != nil {
+			return nil, err
+		}
+		return []*desiredState{state}, nil
+	}
+
+	states := make([]*desiredState, 0, len(app.Spec.Environments))
+	for _, env := range app.Spec.Environments {
+		state, err := renderDesiredState(app, tpl, applyEnvironment(app.Spec, env), env.Namespace, env.Name)
+		if err != nil {
+			return nil, fmt.Errorf("render environment %s: %w", env.Name, err)
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// renderDesiredState 以模板为底、spec 为补丁合并出最终的 spec，并据此渲染 namespace 中期望的 Deployment 和 Service
+func renderDesiredState(app *v1.Application, tpl *v1.ApplicationTemplateSpec, spec v1.ApplicationSpec,
+	namespace, environment string) (*desiredState, error) {
+	var err error
+	deploymentSpec := spec.Deployment.DeploymentSpec
+	serviceSpec := spec.Service.ServiceSpec
+	labels := mergeStringMap(app.Labels, map[string]string{
+		v1.OwnerNameLabel:      app.Name,
+		v1.OwnerNamespaceLabel: app.Namespace,
+	})
+	if environment != "" {
+		labels[v1.EnvironmentLabel] = environment
+	}
+	var annotations map[string]string
+	if tpl != nil {
+		if deploymentSpec, err = mergeDeploymentSpec(tpl.Deployment.DeploymentSpec, deploymentSpec); err != nil {
+			return nil, fmt.Errorf("merge deployment template: %w", err)
+		}
+		if serviceSpec, err = mergeServiceSpec(tpl.Service.ServiceSpec, serviceSpec); err != nil {
+			return nil, fmt.Errorf("merge service template: %w", err)
+		}
+		labels = mergeStringMap(tpl.Metadata.Labels, labels)
+		annotations = tpl.Metadata.Annotations
+	}
+
+	// webhook 只能校验 Application 自身声明的 Pod 模板，合并模板之后按安全配置重新注入和校验，模板提供的容器同样受到约束
+	profile := security.Profile(app)
+	deploymentSpec = *deploymentSpec.DeepCopy()
+	security.Apply(profile, &deploymentSpec.Template)
+	if errs := security.Validate(profile, &deploymentSpec.Template, field.NewPath("spec", "deployment", "template")); len(errs) > 0 {
+		return nil, fmt.Errorf("rendered pod template violates the security profile: %w", errs.ToAggregate())
+	}
+
+	dp := &appsv1.Deployment{}
+	dp.SetName(app.Name)
+	dp.SetNamespace(namespace)
+	dp.SetLabels(copyStringMap(labels))
+	dp.SetAnnotations(copyStringMap(annotations))
+	dp.Spec = deploymentSpec
+	dp.Spec.Template.SetLabels(mergeStringMap(dp.Spec.Template.Labels, app.Labels))
+	setManagedMetadata(dp)
+	if err := setSpecHash(dp, dp.Spec); err != nil {
+		return nil, err
+	}
+
+	svc := &corev1.Service{}
+	svc.SetName(app.Name)
+	svc.SetNamespace(namespace)
+	svc.SetLabels(copyStringMap(labels))
+	svc.SetAnnotations(copyStringMap(annotations))
+	svc.Spec = serviceSpec
+	svc.Spec.Selector = app.Labels
+	setManagedMetadata(svc)
+	if err := setSpecHash(svc, svc.Spec); err != nil {
+		return nil, err
+	}
+
+	return &desiredState{Environment: environment, Deployment: dp, Service: svc}, nil
+}
+
+// applyEnvironment 将环境的差异化配置叠加到 Application 自身声明的 spec 上
+// 叠加发生在模板合并之前，因此不会影响模板中追加的 Sidecar 容器
+func applyEnvironment(spec v1.ApplicationSpec, env v1.ApplicationEnvironment) v1.ApplicationSpec {
+	spec = *spec.DeepCopy()
+	if env.Replicas != nil {
+		replicas := *env.Replicas
+		spec.Deployment.Replicas = &replicas
+	}
+
+	containers := spec.Deployment.Template.Spec.Containers
+	for i := range containers {
+		if env.ImageTag != "" {
+			containers[i].Image = replaceImageTag(containers[i].Image, env.ImageTag)
+		}
+		if env.Resources != nil {
+			containers[i].Resources.Limits = mergeResourceList(containers[i].Resources.Limits, env.Resources.Limits)
+			containers[i].Resources.Requests = mergeResourceList(containers[i].Resources.Requests, env.Resources.Requests)
+		}
+		for _, envVar := range env.Env {
+			containers[i].Env = upsertEnvVar(containers[i].Env, envVar)
+		}
+	}
+	return spec
+}
+
+// replaceImageTag 替换镜像的 tag，同时丢弃镜像中的 digest
+func replaceImageTag(image, tag string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	// 仅在最后一个 "/" 之后查找 ":"，避免把镜像仓库的端口误认为 tag
+	if i := strings.LastIndex(image, ":"); i > strings.LastIndex(image, "/") {
+		image = image[:i]
+	}
+	return image + ":" + tag
+}
+
+func mergeResourceList(base, override corev1.ResourceList) corev1.ResourceList {
+	if len(override) == 0 {
+		return base
+	}
+	merged := corev1.ResourceList{}
+	for name, quantity := range base {
+		merged[name] = quantity
+	}
+	for name, quantity := range override {
+		merged[name] = quantity.DeepCopy()
+	}
+	return merged
+}
+
+func upsertEnvVar(envs []corev1.EnvVar, envVar corev1.EnvVar) []corev1.EnvVar {
+	for i := range envs {
+		if envs[i].Name == envVar.Name {
+			envs[i] = *envVar.DeepCopy()
+			return envs
+		}
+	}
+	return append(envs, *envVar.DeepCopy())
+}
+
+// mergeDeploymentSpec 以 strategic merge 的方式将 patch 合并到 base 之上
+// 模板中名为 "*" 的容器作为默认值合并到 patch 的每一个容器中，而不会被追加为新容器
+func mergeDeploymentSpec(base, patch appsv1.DeploymentSpec) (appsv1.DeploymentSpec, error) {
+	var wildcard *corev1.Container
+	var containers []corev1.Container
+	for i := range base.Template.Spec.Containers {
+		if base.Template.Spec.Containers[i].Name == v1.TemplateWildcardContainerName {
+			wildcard = &base.Template.Spec.Containers[i]
+			continue
+		}
+		containers = append(containers, base.Template.Spec.Containers[i])
+	}
+	base.Template.Spec.Containers = containers
+
+	if wildcard != nil {
+		patch = *patch.DeepCopy()
+		for i, container := range patch.Template.Spec.Containers {
+			defaults := *wildcard.DeepCopy()
+			defaults.Name = container.Name
+			if err := strategicMerge(defaults, container, &patch.Template.Spec.Containers[i]); err != nil {
+				return patch, err
+			}
+		}
+	}
+
+	merged := appsv1.DeploymentSpec{}
+	err := strategicMerge(base, patch, &merged)
+	return merged, err
+}
+
+// mergeServiceSpec 以 strategic merge 的方式将 patch 合并到 base 之上，ports 按 port 合并
+func mergeServiceSpec(base, patch corev1.ServiceSpec) (corev1.ServiceSpec, error) {
+	merged := corev1.ServiceSpec{}
+	err := strategicMerge(base, patch, &merged)
+	return merged, err
+}
+
+// strategicMerge 将同类型的 base 和 patch 序列化后做 strategic merge，结果写入 out
+// patch 中的 null 值代表"未设置"，合并前会被剔除，避免把模板中的值删除
+func strategicMerge[T any](base, patch T, out *T) error {
+	baseJSON, err := json.Marshal(base)
+	if err != nil {
+		return err
+	}
+
+	var patchMap map[string]interface{}
+	patchJSON, err := json.Marshal(patch)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(patchJSON, &patchMap); err != nil {
+		return err
+	}
+	if patchJSON, err = json.Marshal(pruneNulls(patchMap)); err != nil {
+		return err
+	}
+
+	mergedJSON, err := strategicpatch.StrategicMergePatch(baseJSON, patchJSON, base)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(mergedJSON, out)
+}
+
+// pruneNulls 递归删除 map 中值为 null 的键
+func pruneNulls(in map[string]interface{}) map[string]interface{} {
+	for k, v := range in {
+		switch value := v.(type) {
+		case nil:
+			delete(in, k)
+		case map[string]interface{}:
+			in[k] = pruneNulls(value)
+		case []interface{}:
+			for i, item := range value {
+				if m, ok := item.(map[string]interface{}); ok {
+					value[i] = pruneNulls(m)
+				}
+			}
+		}
+	}
+	return in
+}
+
+// setSpecHash 计算子资源元数据和 spec 的摘要并记录在注解中
+func setSpecHash(obj client.Object, spec interface{}) error {
+	annotations := copyStringMap(obj.GetAnnotations())
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, v1.SpecHashAnnotation)
+
+	data, err := json.Marshal(struct {
+		Labels      map[string]string `json:"labels,omitempty"`
+		Annotations map[string]string `json:"annotations,omitempty"`
+		Spec        interface{}       `json:"spec"`
+	}{obj.GetLabels(), annotations, spec})
+	if err != nil {
+		return err
+	}
+	hasher := fnv.New32a()
+	hasher.Write(data)
+
+	annotations[v1.SpecHashAnnotation] = fmt.Sprintf("%x", hasher.Sum32())
+	obj.SetAnnotations(annotations)
+	return nil
+}
+
+// managedMetadata 是 ManagedMetadataAnnotation 中记录的标签和注解 key
+type managedMetadata struct {
+	Labels      []string `json:"labels,omitempty"`
+	Annotations []string `json:"annotations,omitempty"`
+}
+
+// setManagedMetadata 在期望状态上记录其声明的标签和注解 key，需要在 setSpecHash 之前调用
+func setManagedMetadata(obj client.Object) {
+	annotations := copyStringMap(obj.GetAnnotations())
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	delete(annotations, v1.ManagedMetadataAnnotation)
+	delete(annotations, v1.SpecHashAnnotation)
+
+	managed := managedMetadata{Labels: sortedKeys(obj.GetLabels()), Annotations: sortedKeys(annotations)}
+	data, _ := json.Marshal(managed)
+	annotations[v1.ManagedMetadataAnnotation] = string(data)
+	obj.SetAnnotations(annotations)
+}
+
+// syncManagedMetadata 将期望的标签和注解合并到子资源上，并删除上次调谐写入但已不再期望的 key
+// 子资源上没有记录或记录无法解析时只合并，不删除任何 key
+func syncManagedMetadata(actual, desired client.Object) {
+	previous := managedMetadata{}
+	if data, ok := actual.GetAnnotations()[v1.ManagedMetadataAnnotation]; ok {
+		if err := json.Unmarshal([]byte(data), &previous); err != nil {
+			previous = managedMetadata{}
+		}
+	}
+	actual.SetLabels(mergeManagedStringMap(actual.GetLabels(), desired.GetLabels(), previous.Labels))
+	actual.SetAnnotations(mergeManagedStringMap(actual.GetAnnotations(), desired.GetAnnotations(), previous.Annotations))
+}
+
+// mergeManagedStringMap 删除 actual 中曾被管理但 desired 中已不存在的 key，再合并 desired
+func mergeManagedStringMap(actual, desired map[string]string, managed []string) map[string]string {
+	merged := mergeStringMap(actual, desired)
+	for _, k := range managed {
+		if _, ok := desired[k]; !ok {
+			delete(merged, k)
+		}
+	}
+	return merged
+}
+
+func sortedKeys(in map[string]string) []string {
+	keys := make([]string, 0, len(in))
+	for k := range in {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// mergeStringMap 合并两个 map，override 中的值优先
+func mergeStringMap(base, override map[string]string) map[string]string {
+	if base == nil && override == nil {
+		return nil
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range override {
+		merged[k] = v
+	}
+	return merged
+}
+
+// drifted 判断子资源是否偏离期望状态，只比较期望状态中声明的字段，apiserver 填充的默认值不视为偏离
+func drifted(desired, actual interface{}) bool {
+	return !equality.Semantic.DeepDerivative(desired, actual)
+}
+
+func copyStringMap(in map[string]string) map[string]string {
+	if in == nil {
+		return nil
+	}
+	out := make(map[string]string, len(in))
+	for k, v := range in {
+		out[k] = v
+	}
+	return out
+}
+
+// findTemplateReferrers 将模板的变化映射为对所有引用该模板的 Application 的调谐请求
+func (r *ApplicationReconciler) findTemplateReferrers(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+
+	key := "ClusterApplicationTemplate/" + obj.GetName()
+	if _, ok := obj.(*v1.ApplicationTemplate); ok {
+		key = "ApplicationTemplate/" + client.ObjectKeyFromObject(obj).String()
+	}
+
+	apps := &v1.ApplicationList{}
+	if err := r.List(ctx, apps, client.MatchingFields{templateRefIndexField: key}); err != nil {
+		logger.Error(err, "Failed to list Applications referencing the template.", "template", key)
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(apps.Items))
+	for _, app := range apps.Items {
+		requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(&app)})
+	}
+	return requests
+}
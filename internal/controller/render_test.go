@@ -0,0 +1,227 @@
+package controller
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func TestSyncManagedMetadata(t *testing.T) {
+	tests := []struct {
+		name       string
+		actual     map[string]string
+		previous   map[string]string
+		desired    map[string]string
+		wantLabels map[string]string
+	}{
+		{
+			name:       "keys removed from the desired state are deleted",
+			actual:     map[string]string{"app": "demo", "tier": "web"},
+			previous:   map[string]string{"app": "demo", "tier": "web"},
+			desired:    map[string]string{"app": "demo"},
+			wantLabels: map[string]string{"app": "demo"},
+		},
+		{
+			name:       "keys written by other components are kept",
+			actual:     map[string]string{"app": "demo", "team": "ops"},
+			previous:   map[string]string{"app": "demo"},
+			desired:    map[string]string{"app": "demo", "tier": "web"},
+			wantLabels: map[string]string{"app": "demo", "team": "ops", "tier": "web"},
+		},
+		{
+			name:       "children without a record are only merged",
+			actual:     map[string]string{"app": "demo", "tier": "web"},
+			desired:    map[string]string{"app": "demo"},
+			wantLabels: map[string]string{"app": "demo", "tier": "web"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := &appsv1.Deployment{}
+			actual.SetLabels(tt.actual)
+			if tt.previous != nil {
+				previous := &appsv1.Deployment{}
+				previous.SetLabels(tt.previous)
+				setManagedMetadata(previous)
+				actual.SetAnnotations(previous.Annotations)
+			}
+			desired := &appsv1.Deployment{}
+			desired.SetLabels(tt.desired)
+			setManagedMetadata(desired)
+
+			syncManagedMetadata(actual, desired)
+			if !reflect.DeepEqual(actual.Labels, tt.wantLabels) {
+				t.Errorf("labels = %v, want %v", actual.Labels, tt.wantLabels)
+			}
+			if got, want := actual.Annotations[v1.ManagedMetadataAnnotation], desired.Annotations[v1.ManagedMetadataAnnotation]; got != want {
+				t.Errorf("managed metadata = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestRenderDesiredStateAppliesSecurityProfileToTemplate(t *testing.T) {
+	privileged := true
+	tests := []struct {
+		name    string
+		sidecar corev1.Container
+		wantErr bool
+	}{
+		{
+			name:    "containers added by the template are defaulted",
+			sidecar: corev1.Container{Name: "proxy", Image: "envoy:1.28"},
+		},
+		{
+			name: "containers added by the template are validated",
+			sidecar: corev1.Container{Name: "proxy", Image: "envoy:1.28",
+				SecurityContext: &corev1.SecurityContext{Privileged: &privileged}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &v1.Application{}
+			app.Name, app.Namespace = "demo", "default"
+			app.Spec.Security = &v1.SecuritySpec{Profile: v1.SecurityProfileRestricted}
+			app.Spec.Deployment.Template.Spec.Containers = []corev1.Container{{Name: "web", Image: "nginx:1.25"}}
+			tpl := &v1.ApplicationTemplateSpec{}
+			tpl.Deployment.Template.Spec.Containers = []corev1.Container{tt.sidecar}
+
+			state, err := renderDesiredState(app, tpl, app.Spec, app.Namespace, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("renderDesiredState() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			for _, container := range state.Deployment.Spec.Template.Spec.Containers {
+				if sc := container.SecurityContext; sc == nil || sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+					t.Errorf("container %s was not defaulted by the restricted profile: %+v", container.Name, sc)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneNulls(t *testing.T) {
+	in := map[string]interface{}{
+		"replicas": nil,
+		"selector": map[string]interface{}{"matchLabels": nil, "matchExpressions": []interface{}{}},
+		"containers": []interface{}{
+			map[string]interface{}{"name": "web", "resources": nil},
+			"plain",
+		},
+	}
+	want := map[string]interface{}{
+		"selector":   map[string]interface{}{"matchExpressions": []interface{}{}},
+		"containers": []interface{}{map[string]interface{}{"name": "web"}, "plain"},
+	}
+	if got := pruneNulls(in); !reflect.DeepEqual(got, want) {
+		t.Errorf("pruneNulls() = %v, want %v", got, want)
+	}
+}
+
+func TestMergeDeploymentSpec(t *testing.T) {
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}}
+	tests := []struct {
+		name  string
+		base  appsv1.DeploymentSpec
+		patch appsv1.DeploymentSpec
+		check func(t *testing.T, merged appsv1.DeploymentSpec)
+	}{
+		{
+			name: "fields the patch leaves unset keep the template values",
+			base: appsv1.DeploymentSpec{Selector: selector, Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "demo"}},
+			}},
+			patch: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: "web", Image: "nginx:1.25"}},
+			}}},
+			check: func(t *testing.T, merged appsv1.DeploymentSpec) {
+				if !reflect.DeepEqual(merged.Selector, selector) || merged.Template.Labels["app"] != "demo" {
+					t.Errorf("the selector and labels of the template were dropped: %+v", merged)
+				}
+			},
+		},
+		{
+			name: "containers are merged by name and sidecars are appended",
+			base: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "web", Image: "nginx:1.24", Env: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}}},
+				{Name: "proxy", Image: "envoy:1.28"},
+			}}}},
+			patch: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "web", Image: "nginx:1.25"},
+			}}}},
+			check: func(t *testing.T, merged appsv1.DeploymentSpec) {
+				containers := merged.Template.Spec.Containers
+				if len(containers) != 2 || containers[0].Name != "web" || containers[0].Image != "nginx:1.25" ||
+					len(containers[0].Env) != 1 || containers[1].Name != "proxy" {
+					t.Errorf("unexpected containers: %+v", containers)
+				}
+			},
+		},
+		{
+			name: "the wildcard container defaults every container",
+			base: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{
+					Name:      v1.TemplateWildcardContainerName,
+					Env:       []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "info"}},
+					Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")}},
+				},
+			}}}},
+			patch: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{
+				{Name: "web", Image: "nginx:1.25", Env: []corev1.EnvVar{{Name: "LOG_LEVEL", Value: "debug"}}},
+				{Name: "worker", Image: "worker:1.0"},
+			}}}},
+			check: func(t *testing.T, merged appsv1.DeploymentSpec) {
+				containers := merged.Template.Spec.Containers
+				if len(containers) != 2 {
+					t.Fatalf("the wildcard container must not be added, got %+v", containers)
+				}
+				for _, container := range containers {
+					if container.Resources.Limits.Cpu().String() != "1" || len(container.Env) != 1 {
+						t.Errorf("container %s was not defaulted: %+v", container.Name, container)
+					}
+				}
+				if containers[0].Env[0].Value != "debug" {
+					t.Errorf("the container must override the wildcard defaults, got %+v", containers[0].Env)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeDeploymentSpec(tt.base, tt.patch)
+			if err != nil {
+				t.Fatalf("merge Deployment spec: %v", err)
+			}
+			tt.check(t, merged)
+		})
+	}
+}
+
+func TestMergeServiceSpec(t *testing.T) {
+	base := corev1.ServiceSpec{
+		Type:  corev1.ServiceTypeClusterIP,
+		Ports: []corev1.ServicePort{{Name: "http", Port: 80}, {Name: "metrics", Port: 9090}},
+	}
+	patch := corev1.ServiceSpec{Ports: []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromInt(8080)}}}
+
+	merged, err := mergeServiceSpec(base, patch)
+	if err != nil {
+		t.Fatalf("merge Service spec: %v", err)
+	}
+	if merged.Type != corev1.ServiceTypeClusterIP || len(merged.Ports) != 2 {
+		t.Fatalf("unexpected Service spec: %+v", merged)
+	}
+	if port := merged.Ports[0]; port.Name != "http" || port.TargetPort.IntValue() != 8080 {
+		t.Errorf("ports must be merged by port, got %+v", port)
+	}
+}
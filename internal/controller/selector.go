@@ -0,0 +1,116 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// indexChildNamespaces 为子资源所在的命名空间建立索引，selector 只会与渲染到同一命名空间的 Application 重叠
+func indexChildNamespaces(obj client.Object) []string {
+	return obj.(*v1.Application).ChildNamespaces()
+}
+
+// listApplicationsSharingNamespaces 通过索引列出子资源与 app 渲染到同一命名空间的 Application，包括 app 自身
+func (r *ApplicationReconciler) listApplicationsSharingNamespaces(ctx context.Context, app *v1.Application) ([]*v1.Application, error) {
+	seen := map[types.NamespacedName]bool{}
+	var apps []*v1.Application
+	for _, namespace := range app.ChildNamespaces() {
+		list := &v1.ApplicationList{}
+		if err := r.List(ctx, list, client.MatchingFields{v1.ChildNamespacesIndexField: namespace}); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			key := client.ObjectKeyFromObject(&list.Items[i])
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			apps = append(apps, &list.Items[i])
+		}
+	}
+	return apps, nil
+}
+
+// reconcileSelectorConflicts 检查 app 的 selector 是否与其他 Application 的 Pod 重叠，并维护 SelectorConflict Condition
+// Service 的 selector 取自 metadata.labels，标签相同的两个 Application 会互相转发流量
+// 准入 Webhook 会拒绝新产生的冲突，这里用于暴露 Webhook 启用之前已经存在的冲突
+func (r *ApplicationReconciler) reconcileSelectorConflicts(ctx context.Context, app *v1.Application) error {
+	apps, err := r.listApplicationsSharingNamespaces(ctx, app)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []string
+	for _, other := range apps {
+		if !other.DeletionTimestamp.IsZero() || !app.SelectorOverlaps(other) {
+			continue
+		}
+		conflicts = append(conflicts, client.ObjectKeyFromObject(other).String())
+	}
+
+	if len(conflicts) == 0 {
+		return r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeSelectorConflict,
+			Status:  metav1.ConditionFalse,
+			Reason:  "NoOverlap",
+			Message: "Selectors do not overlap with other Applications",
+		})
+	}
+
+	log.FromContext(ctx).Info("The selectors overlap with other Applications.", "applications", conflicts)
+	return r.setCondition(ctx, app, metav1.Condition{
+		Type:   v1.ConditionTypeSelectorConflict,
+		Status: metav1.ConditionTrue,
+		Reason: "SelectorOverlap",
+		Message: fmt.Sprintf("Selectors overlap with the pods of Applications %s, traffic may be routed between them",
+			strings.Join(conflicts, ", ")),
+	})
+}
+
+// findSelectorConflicts 将 Application 的变化映射为对与其重叠、或此前处于冲突状态的 Application 的调谐请求
+// 更新事件会分别以变化前后的对象调用，移出某个命名空间时同样能找到此前与其冲突的 Application
+func (r *ApplicationReconciler) findSelectorConflicts(ctx context.Context, obj client.Object) []reconcile.Request {
+	logger := log.FromContext(ctx)
+	changed := obj.(*v1.Application)
+
+	apps, err := r.listApplicationsSharingNamespaces(ctx, changed)
+	if err != nil {
+		logger.Error(err, "Failed to list Applications.")
+		return nil
+	}
+
+	var requests []reconcile.Request
+	for _, app := range apps {
+		if app.Namespace == changed.Namespace && app.Name == changed.Name {
+			continue
+		}
+		if changed.SelectorOverlaps(app) || meta.IsStatusConditionTrue(app.Status.Conditions, v1.ConditionTypeSelectorConflict) {
+			requests = append(requests, reconcile.Request{NamespacedName: client.ObjectKeyFromObject(app)})
+		}
+	}
+	return requests
+}
+
+// selectorChanged 只关注 Application 的创建、删除以及标签和 spec 的变化
+var selectorChanged = predicate.Funcs{
+	UpdateFunc: func(event event.UpdateEvent) bool {
+		return event.ObjectOld.GetGeneration() != event.ObjectNew.GetGeneration() ||
+			!reflect.DeepEqual(event.ObjectOld.GetLabels(), event.ObjectNew.GetLabels())
+	},
+	GenericFunc: func(event event.GenericEvent) bool {
+		return false
+	},
+}
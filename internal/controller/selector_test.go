@@ -0,0 +1,65 @@
+package controller
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// newLabeledApplication 返回带有 labels 的 Application，声明了 environments 时渲染到对应的命名空间
+func newLabeledApplication(namespace, name string, labels map[string]string, environments ...string) *v1.Application {
+	app := &v1.Application{}
+	app.Name, app.Namespace = name, namespace
+	app.Labels = labels
+	for _, env := range environments {
+		app.Spec.Environments = append(app.Spec.Environments, v1.ApplicationEnvironment{Name: env, Namespace: env})
+	}
+	return app
+}
+
+func TestSelectorConflicts(t *testing.T) {
+	ctx := context.Background()
+	scheme := newTestScheme(t)
+	web := map[string]string{"app": "web"}
+	app := newLabeledApplication("default", "web", web)
+	resolved := newLabeledApplication("team-a", "api", map[string]string{"app": "api"}, "default")
+	resolved.Status.Conditions = []metav1.Condition{{Type: v1.ConditionTypeSelectorConflict, Status: metav1.ConditionTrue}}
+	objs := []client.Object{
+		app,
+		newLabeledApplication("default", "web-canary", web),
+		newLabeledApplication("team-a", "web", web, "default", "team-a"),
+		newLabeledApplication("team-b", "web", web),
+		newLabeledApplication("default", "db", map[string]string{"app": "db"}),
+		resolved,
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objs...).WithStatusSubresource(app).
+		WithIndex(&v1.Application{}, v1.ChildNamespacesIndexField, indexChildNamespaces).Build()
+	r := &ApplicationReconciler{Client: c, Scheme: scheme}
+
+	if err := r.reconcileSelectorConflicts(ctx, app); err != nil {
+		t.Fatalf("reconcile selector conflicts: %v", err)
+	}
+	condition := meta.FindStatusCondition(app.Status.Conditions, v1.ConditionTypeSelectorConflict)
+	want := "Selectors overlap with the pods of Applications default/web-canary, team-a/web, traffic may be routed between them"
+	if condition == nil || condition.Status != metav1.ConditionTrue || condition.Message != want {
+		t.Errorf("SelectorConflict condition = %+v, want message %q", condition, want)
+	}
+
+	var got []string
+	for _, request := range r.findSelectorConflicts(ctx, app) {
+		got = append(got, request.String())
+	}
+	sort.Strings(got)
+	wantRequests := []string{"default/web-canary", "team-a/api", "team-a/web"}
+	if !reflect.DeepEqual(got, wantRequests) {
+		t.Errorf("findSelectorConflicts() = %v, want %v", got, wantRequests)
+	}
+}
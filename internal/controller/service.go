@@ -4,6 +4,7 @@ import (
 	"context"
 	"reflect"
 
+	"go.opentelemetry.io/otel/attribute"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/types"
@@ -11,21 +12,71 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log"
 
 	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+	"github.com/ahwhy/clusterops-operator/internal/featuregate"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
 )
 
-func (r *ApplicationReconciler) reconcileService(ctx context.Context, app *v1.Application) (
-	ctrl.Result, error) {
-	logger := log.FromContext(ctx)
+func (r *ApplicationReconciler) reconcileService(ctx context.Context, app *v1.Application, state *desiredState) (
+	result ctrl.Result, err error) {
+	desired := state.Service
+	ctx, span := tracing.Start(ctx, "reconcileService",
+		attribute.String("environment", state.Environment),
+		attribute.String("k8s.namespace.name", desired.Namespace),
+		attribute.String("k8s.object.name", desired.Name),
+	)
+	defer func() { tracing.End(span, err) }()
+	logger := log.FromContext(ctx).WithValues("environment", state.Environment)
 
 	// Get Service
 	var svc = &corev1.Service{}
-	err := r.Get(ctx, types.NamespacedName{
-		Namespace: app.Namespace,
-		Name:      app.Name,
+	err = r.Get(ctx, types.NamespacedName{
+		Namespace: desired.Namespace,
+		Name:      desired.Name,
 	}, svc)
 
 	if err == nil {
 		logger.Info("The Service has already exist.")
+		if err := checkOwnership(app, svc); err != nil {
+			logger.Error(err, "The Service is not managed by this Application, will requeue after a short time.")
+			if condErr := r.reportOwnershipConflict(ctx, app, err); condErr != nil {
+				return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, condErr
+			}
+			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+		}
+		// Application 或其引用的模板发生变化时，期望状态摘要随之变化，此时更新 Service
+		// 开启 DriftCorrection 时，Service 在 Operator 之外被修改后同样恢复为期望状态
+		if !rolloutHeld(app) && (svc.Annotations[v1.SpecHashAnnotation] != desired.Annotations[v1.SpecHashAnnotation] ||
+			featuregate.Enabled(featuregate.DriftCorrection) && drifted(desired.Spec, svc.Spec)) {
+			// 期望状态摘要未变化时，是 DriftCorrection 恢复了 Operator 之外的修改
+			reason := "DesiredStateChanged"
+			if svc.Annotations[v1.SpecHashAnnotation] == desired.Annotations[v1.SpecHashAnnotation] {
+				reason = "DriftCorrected"
+			}
+			// 指定了与已分配地址不同的 clusterIP 时只能删除重建
+			if desired.Spec.ClusterIP != "" && desired.Spec.ClusterIP != svc.Spec.ClusterIP {
+				return r.recreateChild(ctx, app, svc)
+			}
+			// ClusterIP 由 apiserver 分配且不可变更，更新时沿用现有值
+			clusterIP, clusterIPs := svc.Spec.ClusterIP, svc.Spec.ClusterIPs
+			syncManagedMetadata(svc, desired)
+			svc.Spec = desired.Spec
+			if svc.Spec.ClusterIP == "" {
+				svc.Spec.ClusterIP, svc.Spec.ClusterIPs = clusterIP, clusterIPs
+			}
+			if err := r.Update(audit.WithReason(ctx, reason), svc); err != nil {
+				logger.Error(err, "Failed to update Service, will requeue after a short time.")
+				return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
+			}
+			logger.Info("The Service has been updated.")
+			tracing.Eventf(ctx, r.Recorder, app, corev1.EventTypeNormal, "ServiceUpdated", "Updated Service %s/%s", svc.Namespace, svc.Name)
+		}
+
+		// status.network 只记录 Application 自身命名空间中的 Service
+		if state.Environment != "" {
+			return ctrl.Result{}, nil
+		}
+
 		// 判断 svc.Status 和 app.Status.Network 是否相等
 		if reflect.DeepEqual(svc.Status, app.Status.Network) {
 			return ctrl.Result{}, nil
@@ -33,7 +84,7 @@ func (r *ApplicationReconciler) reconcileService(ctx context.Context, app *v1.Ap
 
 		app.Status.Network = svc.Status
 		// 若不相等，则触发更新
-		if err := r.Status().Update(ctx, app); err != nil {
+		if err := r.Status().Update(audit.WithReason(ctx, "ServiceStatusChanged"), app); err != nil {
 			logger.Error(err, "Failed to update Application service status.")
 			return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 		}
@@ -47,24 +98,26 @@ func (r *ApplicationReconciler) reconcileService(ctx context.Context, app *v1.Ap
 		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 	}
 
+	// 处于暂停状态或依赖尚未就绪时不创建子资源
+	if rolloutHeld(app) {
+		logger.Info("The rollout of the Application is held, skip creating Service.")
+		return ctrl.Result{}, nil
+	}
+
 	// 若 NotFound，则触发 Create
-	newSvc := &corev1.Service{}
-	newSvc.SetName(app.Name)
-	newSvc.SetNamespace(app.Namespace)
-	newSvc.SetLabels(app.Labels)
-	newSvc.Spec = app.Spec.Service.ServiceSpec
-	newSvc.Spec.Selector = app.Labels
+	newSvc := desired.DeepCopy()
 
 	// 将当前创建的 newSvc 设置为 Application 类型的 app 资源的子资源
-	if err := ctrl.SetControllerReference(app, newSvc, r.Scheme); err != nil {
+	if err := r.setOwner(app, newSvc); err != nil {
 		logger.Error(err, "Failed to Set ControllerReference, will requeue after a short time.")
 		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 	}
-	if err := r.Create(ctx, newSvc); err != nil {
+	if err := r.Create(audit.WithReason(ctx, "ChildMissing"), newSvc); err != nil {
 		logger.Error(err, "Failed to Create Service, will requeue after a short time.")
 		return ctrl.Result{RequeueAfter: GenericRequeueDuraiton}, err
 	}
 
 	logger.Info("The Service has been created")
+	tracing.Eventf(ctx, r.Recorder, app, corev1.EventTypeNormal, "ServiceCreated", "Created Service %s/%s", newSvc.Namespace, newSvc.Name)
 	return ctrl.Result{}, nil
 }
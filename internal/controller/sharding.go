@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"context"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// ownsApplication 判断本副本是否负责调谐 app，未启用分片时负责所有 Application
+func (r *ApplicationReconciler) ownsApplication(app *v1.Application) bool {
+	return r.Shard == nil || r.Shard.Owns(app.ShardKey())
+}
+
+// ownedByShard 过滤不属于本副本的 Application 事件
+func (r *ApplicationReconciler) ownedByShard() predicate.Predicate {
+	return predicate.NewPredicateFuncs(func(obj client.Object) bool {
+		app, ok := obj.(*v1.Application)
+		return !ok || r.ownsApplication(app)
+	})
+}
+
+// shardRebalancer 在分片成员变化后为本副本新负责的 Application 产生事件，使副本加入或离开后 Application 立即被接管
+type shardRebalancer struct {
+	reconciler *ApplicationReconciler
+	events     chan event.GenericEvent
+}
+
+var _ manager.LeaderElectionRunnable = &shardRebalancer{}
+
+// newShardRebalancer 返回 rebalancer 以及调谐器需要监听的事件源
+func newShardRebalancer(r *ApplicationReconciler) (*shardRebalancer, source.Source) {
+	rebalancer := &shardRebalancer{reconciler: r, events: make(chan event.GenericEvent)}
+	return rebalancer, &source.Channel{Source: rebalancer.events}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. 分片模式下每个副本都需要运行
+func (s *shardRebalancer) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable.
+func (s *shardRebalancer) Start(ctx context.Context) error {
+	logger := log.FromContext(ctx).WithName("sharding")
+	r := s.reconciler
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-r.Shard.Changed():
+		}
+
+		apps := &v1.ApplicationList{}
+		if err := r.List(ctx, apps); err != nil {
+			logger.Error(err, "Failed to list Applications for rebalancing.")
+			continue
+		}
+		owned := 0
+		for i := range apps.Items {
+			if !r.ownsApplication(&apps.Items[i]) {
+				continue
+			}
+			owned++
+			select {
+			case s.events <- event.GenericEvent{Object: &apps.Items[i]}:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+		logger.Info("The Applications of this shard have been enqueued.", "owned", owned, "total", len(apps.Items))
+	}
+}
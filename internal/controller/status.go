@@ -0,0 +1,126 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/audit"
+)
+
+// setCondition 设置 Application 的 Condition，仅在 Status/Reason/Message 发生变化时才写回 Status
+func (r *ApplicationReconciler) setCondition(ctx context.Context, app *v1.Application, condition metav1.Condition) error {
+	logger := log.FromContext(ctx)
+
+	existing := meta.FindStatusCondition(app.Status.Conditions, condition.Type)
+	if existing != nil &&
+		existing.Status == condition.Status &&
+		existing.Reason == condition.Reason &&
+		existing.Message == condition.Message &&
+		existing.ObservedGeneration == app.Generation {
+		return nil
+	}
+
+	condition.ObservedGeneration = app.Generation
+	meta.SetStatusCondition(&app.Status.Conditions, condition)
+	if err := r.Status().Update(audit.WithReason(ctx, "ConditionChanged"), app); err != nil {
+		logger.Error(err, "Failed to update Application condition.", "type", condition.Type)
+		return err
+	}
+
+	logger.Info("The Application condition has been updated.", "type", condition.Type, "status", condition.Status)
+	return nil
+}
+
+// reconcilePaused 根据 spec.paused 或 paused 注解维护 Paused Condition 及相关指标
+func (r *ApplicationReconciler) reconcilePaused(ctx context.Context, app *v1.Application) error {
+	if !app.IsPaused() {
+		applicationPaused.WithLabelValues(app.Namespace, app.Name).Set(0)
+		return r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypePaused,
+			Status:  metav1.ConditionFalse,
+			Reason:  "Reconciling",
+			Message: "Child resources are reconciled normally",
+		})
+	}
+
+	applicationPaused.WithLabelValues(app.Namespace, app.Name).Set(1)
+	applicationPausedReconciles.WithLabelValues(app.Namespace, app.Name).Inc()
+
+	reason, message := "PausedBySpec", "Reconciliation of child resources is paused by spec.paused"
+	if !app.Spec.Paused {
+		reason, message = "PausedByAnnotation", "Reconciliation of child resources is paused by the "+v1.PausedAnnotation+" annotation"
+	}
+	return r.setCondition(ctx, app, metav1.Condition{
+		Type:    v1.ConditionTypePaused,
+		Status:  metav1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// rolloutHeld 判断当前是否应暂缓对子资源的变更：Application 被暂停，或仍在等待依赖就绪
+func rolloutHeld(app *v1.Application) bool {
+	return app.IsPaused() || meta.IsStatusConditionTrue(app.Status.Conditions, v1.ConditionTypeWaitingForDependencies)
+}
+
+// reconcileReady 根据已同步的 Deployment 状态维护 Ready Condition，供依赖方判断是否可以开始发布
+// 声明了 environments 时，所有环境均就绪才视为就绪；期望副本数取渲染结果，模板和环境的覆盖同样生效
+func (r *ApplicationReconciler) reconcileReady(ctx context.Context, app *v1.Application, states []*desiredState) error {
+	if len(app.Spec.Environments) > 0 {
+		var notReady []string
+		for _, env := range app.Spec.Environments {
+			ready := false
+			for _, envStatus := range app.Status.Environments {
+				if envStatus.Name == env.Name {
+					ready = envStatus.Ready
+				}
+			}
+			if !ready {
+				notReady = append(notReady, env.Name)
+			}
+		}
+
+		if len(notReady) > 0 {
+			return r.setCondition(ctx, app, metav1.Condition{
+				Type:    v1.ConditionTypeReady,
+				Status:  metav1.ConditionFalse,
+				Reason:  "EnvironmentsNotReady",
+				Message: "Environments not ready: " + strings.Join(notReady, ", "),
+			})
+		}
+		return r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeReady,
+			Status:  metav1.ConditionTrue,
+			Reason:  "EnvironmentsReady",
+			Message: fmt.Sprintf("All %d environments are ready", len(app.Spec.Environments)),
+		})
+	}
+
+	desired := int32(1)
+	if len(states) > 0 && states[0].Deployment.Spec.Replicas != nil {
+		desired = *states[0].Deployment.Spec.Replicas
+	}
+
+	workflow := app.Status.Workflow
+	if workflow.AvailableReplicas >= desired && workflow.UpdatedReplicas >= desired {
+		return r.setCondition(ctx, app, metav1.Condition{
+			Type:    v1.ConditionTypeReady,
+			Status:  metav1.ConditionTrue,
+			Reason:  "ReplicasAvailable",
+			Message: fmt.Sprintf("%d/%d replicas are available", workflow.AvailableReplicas, desired),
+		})
+	}
+
+	return r.setCondition(ctx, app, metav1.Condition{
+		Type:    v1.ConditionTypeReady,
+		Status:  metav1.ConditionFalse,
+		Reason:  "ReplicasUnavailable",
+		Message: fmt.Sprintf("%d/%d replicas are available", workflow.AvailableReplicas, desired),
+	})
+}
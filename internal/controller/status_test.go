@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	v1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := v1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func TestReconcilePaused(t *testing.T) {
+	tests := []struct {
+		name        string
+		paused      bool
+		annotations map[string]string
+		wantStatus  metav1.ConditionStatus
+		wantReason  string
+		wantHeld    bool
+	}{
+		{
+			name:       "not paused",
+			wantStatus: metav1.ConditionFalse,
+			wantReason: "Reconciling",
+		},
+		{
+			name:       "paused by spec",
+			paused:     true,
+			wantStatus: metav1.ConditionTrue,
+			wantReason: "PausedBySpec",
+			wantHeld:   true,
+		},
+		{
+			name:        "paused by annotation",
+			annotations: map[string]string{v1.PausedAnnotation: "true"},
+			wantStatus:  metav1.ConditionTrue,
+			wantReason:  "PausedByAnnotation",
+			wantHeld:    true,
+		},
+		{
+			name:        "annotation other than true",
+			annotations: map[string]string{v1.PausedAnnotation: "false"},
+			wantStatus:  metav1.ConditionFalse,
+			wantReason:  "Reconciling",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			app := &v1.Application{}
+			app.Name, app.Namespace = "demo", "default"
+			app.Spec.Paused = tt.paused
+			app.Annotations = tt.annotations
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(app).WithStatusSubresource(app).Build()
+			r := &ApplicationReconciler{Client: c, Scheme: scheme}
+
+			if err := r.reconcilePaused(ctx, app); err != nil {
+				t.Fatalf("reconcile paused: %v", err)
+			}
+			condition := meta.FindStatusCondition(app.Status.Conditions, v1.ConditionTypePaused)
+			if condition == nil || condition.Status != tt.wantStatus || condition.Reason != tt.wantReason {
+				t.Errorf("Paused condition = %+v, want status %s and reason %s", condition, tt.wantStatus, tt.wantReason)
+			}
+			if got := rolloutHeld(app); got != tt.wantHeld {
+				t.Errorf("rolloutHeld() = %v, want %v", got, tt.wantHeld)
+			}
+		})
+	}
+}
+
+func TestReconcileReady(t *testing.T) {
+	two, three := int32(2), int32(3)
+	tests := []struct {
+		name       string
+		declared   *int32
+		rendered   *int32
+		available  int32
+		wantStatus metav1.ConditionStatus
+	}{
+		{
+			name:       "rendered replicas available",
+			declared:   &three,
+			rendered:   &three,
+			available:  3,
+			wantStatus: metav1.ConditionTrue,
+		},
+		{
+			name:       "rendered replicas override the declared count",
+			declared:   &two,
+			rendered:   &three,
+			available:  2,
+			wantStatus: metav1.ConditionFalse,
+		},
+		{
+			name:       "replicas supplied by a template",
+			rendered:   &two,
+			available:  2,
+			wantStatus: metav1.ConditionTrue,
+		},
+		{
+			name:       "one replica when nothing is declared",
+			available:  0,
+			wantStatus: metav1.ConditionFalse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			scheme := newTestScheme(t)
+			app := &v1.Application{}
+			app.Name, app.Namespace = "demo", "default"
+			app.Spec.Deployment.Replicas = tt.declared
+			app.Status.Workflow.AvailableReplicas = tt.available
+			app.Status.Workflow.UpdatedReplicas = tt.available
+			c := fake.NewClientBuilder().WithScheme(scheme).WithObjects(app).WithStatusSubresource(app).Build()
+			r := &ApplicationReconciler{Client: c, Scheme: scheme}
+
+			state := &desiredState{Deployment: &appsv1.Deployment{}}
+			state.Deployment.Spec.Replicas = tt.rendered
+			if err := r.reconcileReady(ctx, app, []*desiredState{state}); err != nil {
+				t.Fatalf("reconcile ready: %v", err)
+			}
+			condition := meta.FindStatusCondition(app.Status.Conditions, v1.ConditionTypeReady)
+			if condition == nil || condition.Status != tt.wantStatus {
+				t.Errorf("Ready condition = %+v, want status %s", condition, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var demo = types.NamespacedName{Namespace: "default", Name: "demo"}
+
+func TestTrackerRecordsReconciles(t *testing.T) {
+	tracker := NewTracker()
+	release := make(chan struct{})
+	reconciler := tracker.Wrap("application", reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		<-release
+		return ctrl.Result{RequeueAfter: time.Minute}, errors.New("boom")
+	}))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = reconciler.Reconcile(context.Background(), ctrl.Request{NamespacedName: demo})
+	}()
+	for len(tracker.InFlight("application")) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	if _, ok := tracker.InFlightSince("application", demo); !ok {
+		t.Fatalf("expected %s to be in flight", demo)
+	}
+
+	close(release)
+	<-done
+	if len(tracker.InFlight("")) != 0 {
+		t.Fatalf("expected no reconcile in flight")
+	}
+	record, ok := tracker.Last("application", demo)
+	if !ok || record.Error != "boom" || record.RequeueAfter != "1m0s" {
+		t.Fatalf("unexpected last reconcile: %+v", record)
+	}
+	if failed := tracker.LastResults("application", true); len(failed) != 1 {
+		t.Fatalf("expected 1 failed reconcile, got %d", len(failed))
+	}
+	if other := tracker.LastResults("applicationset", false); len(other) != 0 {
+		t.Fatalf("expected no reconcile of another controller, got %d", len(other))
+	}
+}
+
+func TestTrackerEvictsOldestResults(t *testing.T) {
+	tracker := NewTracker()
+	tracker.MaxEntries = 2
+	start := time.Now()
+	for i, name := range []string{"a", "b", "c"} {
+		key := trackerKey{controller: "application", key: types.NamespacedName{Namespace: "default", Name: name}}
+		tracker.finished(key, start.Add(time.Duration(i)*time.Second), ctrl.Result{}, nil)
+	}
+
+	if _, ok := tracker.Last("application", types.NamespacedName{Namespace: "default", Name: "a"}); ok {
+		t.Fatalf("expected the oldest result to be evicted")
+	}
+	if results := tracker.LastResults("", false); len(results) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(results))
+	}
+}
+
+type fakeRenderer struct{}
+
+func (fakeRenderer) DesiredState(_ context.Context, key types.NamespacedName) ([]client.Object, error) {
+	if key != demo {
+		return nil, apierrors.NewNotFound(schema.GroupResource{Group: "apps.clusterops.io", Resource: "applications"}, key.Name)
+	}
+	return []client.Object{&corev1.Service{ObjectMeta: metav1.ObjectMeta{Namespace: key.Namespace, Name: key.Name}}}, nil
+}
+
+func TestServerApplication(t *testing.T) {
+	server := &Server{Tracker: NewTracker(), Renderer: fakeRenderer{}, Controller: "application", Token: "secret"}
+	handler := server.Handler()
+
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp
+	}
+
+	if resp := get("/debug/applications/default/demo", ""); resp.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401 without a token, got %d", resp.Code)
+	}
+	if resp := get("/debug/applications/default/missing", "secret"); resp.Code != http.StatusNotFound {
+		t.Fatalf("expected 404 for a missing Application, got %d", resp.Code)
+	}
+	if resp := get("/debug/applications/default", "secret"); resp.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for an invalid path, got %d", resp.Code)
+	}
+
+	resp := get("/debug/applications/default/demo", "secret")
+	if resp.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", resp.Code, resp.Body.String())
+	}
+	var status struct {
+		Key          string            `json:"key"`
+		DesiredState []json.RawMessage `json:"desiredState"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &status); err != nil {
+		t.Fatalf("decode response: %v", err)
+	}
+	if status.Key != demo.String() || len(status.DesiredState) != 1 {
+		t.Fatalf("unexpected response: %s", resp.Body.String())
+	}
+
+	if resp := get("/debug/workqueue", "secret"); resp.Code != http.StatusOK {
+		t.Fatalf("expected 200 for the work queues, got %d", resp.Code)
+	}
+}
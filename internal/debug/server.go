@@ -0,0 +1,237 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debug
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// DesiredStateRenderer renders the child resources of an Application without applying them.
+type DesiredStateRenderer interface {
+	DesiredState(ctx context.Context, key types.NamespacedName) ([]client.Object, error)
+}
+
+// Server serves the debug endpoints:
+//
+//	/debug/pprof/                          Go runtime profiles
+//	/debug/workqueue                       depth and in-flight keys of each controller queue
+//	/debug/reconciles?controller=&failed=  last reconcile result of each object
+//	/debug/applications/<namespace>/<name> last reconcile and rendered desired state of an Application
+type Server struct {
+	// BindAddress 是管理端点监听的地址，只应绑定到 localhost，或者同时设置 Token
+	BindAddress string
+	// Token 不为空时要求请求携带 Authorization: Bearer <Token>
+	Token string
+	// Tracker 记录各控制器的调谐
+	Tracker *Tracker
+	// Renderer 渲染 Application 的期望状态
+	Renderer DesiredStateRenderer
+	// Controller 是 Renderer 对应的控制器名称，用于查询 Application 的调谐结果
+	Controller string
+}
+
+var _ manager.Runnable = &Server{}
+var _ manager.LeaderElectionRunnable = &Server{}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. 非 leader 副本同样需要排查
+func (s *Server) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable.
+func (s *Server) Start(ctx context.Context) error {
+	logger := ctrl.Log.WithName("debug")
+	listener, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return fmt.Errorf("listen on %s: %w", s.BindAddress, err)
+	}
+	server := &http.Server{
+		Handler:           s.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("Serving debug endpoints.", "address", listener.Addr().String())
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Handler 返回所有管理端点
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.HandleFunc("/debug/workqueue", s.serveWorkqueue)
+	mux.HandleFunc("/debug/reconciles", s.serveReconciles)
+	mux.HandleFunc("/debug/applications/", s.serveApplication)
+	return s.authenticate(mux)
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	if s.Token == "" {
+		return next
+	}
+	expected := []byte("Bearer " + s.Token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// QueueStatus is the state of the work queue of a controller.
+type QueueStatus struct {
+	Name                           string            `json:"name"`
+	Depth                          float64           `json:"depth"`
+	UnfinishedWorkSeconds          float64           `json:"unfinishedWorkSeconds"`
+	LongestRunningProcessorSeconds float64           `json:"longestRunningProcessorSeconds"`
+	InFlight                       []ReconcileRecord `json:"inFlight"`
+}
+
+// serveWorkqueue 从 controller-runtime 的工作队列指标读取队列长度，正在处理的 key 来自 Tracker
+func (s *Server) serveWorkqueue(w http.ResponseWriter, _ *http.Request) {
+	families, err := metrics.Registry.Gather()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	queues := map[string]*QueueStatus{}
+	queue := func(name string) *QueueStatus {
+		if queues[name] == nil {
+			queues[name] = &QueueStatus{Name: name, InFlight: s.Tracker.InFlight(name)}
+		}
+		return queues[name]
+	}
+	for _, family := range families {
+		for _, metric := range family.GetMetric() {
+			name := labelValue(metric, "name")
+			switch family.GetName() {
+			case "workqueue_depth":
+				queue(name).Depth = metric.GetGauge().GetValue()
+			case "workqueue_unfinished_work_seconds":
+				queue(name).UnfinishedWorkSeconds = metric.GetGauge().GetValue()
+			case "workqueue_longest_running_processor_seconds":
+				queue(name).LongestRunningProcessorSeconds = metric.GetGauge().GetValue()
+			}
+		}
+	}
+
+	statuses := make([]*QueueStatus, 0, len(queues))
+	for _, status := range queues {
+		statuses = append(statuses, status)
+	}
+	sortQueues(statuses)
+	writeJSON(w, statuses)
+}
+
+func (s *Server) serveReconciles(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	writeJSON(w, map[string]interface{}{
+		"inFlight": s.Tracker.InFlight(query.Get("controller")),
+		"last":     s.Tracker.LastResults(query.Get("controller"), query.Get("failed") == "true"),
+	})
+}
+
+// ApplicationStatus is the debug view of an Application.
+type ApplicationStatus struct {
+	Key           string           `json:"key"`
+	InFlightSince *time.Time       `json:"inFlightSince,omitempty"`
+	LastReconcile *ReconcileRecord `json:"lastReconcile,omitempty"`
+	DesiredState  []client.Object  `json:"desiredState,omitempty"`
+	RenderError   string           `json:"renderError,omitempty"`
+}
+
+func (s *Server) serveApplication(w http.ResponseWriter, req *http.Request) {
+	namespace, name, ok := strings.Cut(strings.TrimPrefix(req.URL.Path, "/debug/applications/"), "/")
+	if !ok || namespace == "" || name == "" || strings.Contains(name, "/") {
+		http.Error(w, "expected /debug/applications/<namespace>/<name>", http.StatusBadRequest)
+		return
+	}
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+
+	status := ApplicationStatus{Key: key.String()}
+	if start, ok := s.Tracker.InFlightSince(s.Controller, key); ok {
+		status.InFlightSince = &start
+	}
+	if record, ok := s.Tracker.Last(s.Controller, key); ok {
+		status.LastReconcile = &record
+	}
+	if s.Renderer != nil {
+		objects, err := s.Renderer.DesiredState(req.Context(), key)
+		if apierrors.IsNotFound(err) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			status.RenderError = err.Error()
+		}
+		status.DesiredState = objects
+	}
+	writeJSON(w, status)
+}
+
+func sortQueues(queues []*QueueStatus) {
+	sort.Slice(queues, func(i, j int) bool { return queues[i].Name < queues[j].Name })
+}
+
+func labelValue(metric *dto.Metric, name string) string {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name {
+			return label.GetValue()
+		}
+	}
+	return ""
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	_ = encoder.Encode(v)
+}
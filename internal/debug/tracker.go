@@ -0,0 +1,182 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package debug 提供排查问题用的管理端点，包括 pprof、工作队列、各 Application 最近一次调谐的结果以及渲染出的期望状态
+package debug
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// DefaultMaxEntries 是 Tracker 默认保留的调谐结果数量，超出后丢弃最早的结果，避免已删除对象的结果无限增长
+const DefaultMaxEntries = 10000
+
+// ReconcileRecord is a reconcile of an object, in flight or finished.
+type ReconcileRecord struct {
+	Controller   string    `json:"controller"`
+	Key          string    `json:"key"`
+	Start        time.Time `json:"start"`
+	Duration     string    `json:"duration,omitempty"`
+	Requeue      bool      `json:"requeue,omitempty"`
+	RequeueAfter string    `json:"requeueAfter,omitempty"`
+	Error        string    `json:"error,omitempty"`
+}
+
+type trackerKey struct {
+	controller string
+	key        types.NamespacedName
+}
+
+// Tracker records the reconciles in flight and the result of the last reconcile of
+// each object. A nil Tracker records nothing.
+type Tracker struct {
+	// MaxEntries 是保留的调谐结果数量，为 0 时使用 DefaultMaxEntries
+	MaxEntries int
+
+	mu       sync.Mutex
+	inFlight map[trackerKey]time.Time
+	last     map[trackerKey]ReconcileRecord
+}
+
+// NewTracker 返回一个空的 Tracker
+func NewTracker() *Tracker {
+	return &Tracker{
+		inFlight: map[trackerKey]time.Time{},
+		last:     map[trackerKey]ReconcileRecord{},
+	}
+}
+
+// Wrap 返回记录每次调谐的 Reconciler，controller 与工作队列指标中的 name 保持一致
+func (t *Tracker) Wrap(controller string, r reconcile.Reconciler) reconcile.Reconciler {
+	if t == nil {
+		return r
+	}
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		key := trackerKey{controller: controller, key: req.NamespacedName}
+		start := time.Now()
+		t.started(key, start)
+
+		result, err := r.Reconcile(ctx, req)
+		t.finished(key, start, result, err)
+		return result, err
+	})
+}
+
+func (t *Tracker) started(key trackerKey, start time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inFlight[key] = start
+}
+
+func (t *Tracker) finished(key trackerKey, start time.Time, result ctrl.Result, err error) {
+	record := ReconcileRecord{
+		Controller: key.controller,
+		Key:        key.key.String(),
+		Start:      start,
+		Duration:   time.Since(start).String(),
+		Requeue:    result.Requeue,
+	}
+	if result.RequeueAfter > 0 {
+		record.RequeueAfter = result.RequeueAfter.String()
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.inFlight, key)
+	t.last[key] = record
+	t.evict()
+}
+
+// evict 丢弃最早的调谐结果，直到数量不超过 MaxEntries
+func (t *Tracker) evict() {
+	max := t.MaxEntries
+	if max <= 0 {
+		max = DefaultMaxEntries
+	}
+	for len(t.last) > max {
+		var oldest trackerKey
+		first := true
+		for key, record := range t.last {
+			if first || record.Start.Before(t.last[oldest].Start) {
+				oldest, first = key, false
+			}
+		}
+		delete(t.last, oldest)
+	}
+}
+
+// InFlight 返回正在进行的调谐，按开始时间排序
+func (t *Tracker) InFlight(controller string) []ReconcileRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	records := []ReconcileRecord{}
+	for key, start := range t.inFlight {
+		if controller == "" || key.controller == controller {
+			records = append(records, ReconcileRecord{Controller: key.controller, Key: key.key.String(), Start: start})
+		}
+	}
+	sortRecords(records)
+	return records
+}
+
+// InFlightSince 返回对象当前调谐的开始时间
+func (t *Tracker) InFlightSince(controller string, key types.NamespacedName) (time.Time, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	start, ok := t.inFlight[trackerKey{controller: controller, key: key}]
+	return start, ok
+}
+
+// Last 返回对象最近一次调谐的结果
+func (t *Tracker) Last(controller string, key types.NamespacedName) (ReconcileRecord, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	record, ok := t.last[trackerKey{controller: controller, key: key}]
+	return record, ok
+}
+
+// LastResults 返回所有对象最近一次调谐的结果，failedOnly 时只返回失败的调谐
+func (t *Tracker) LastResults(controller string, failedOnly bool) []ReconcileRecord {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	records := []ReconcileRecord{}
+	for key, record := range t.last {
+		if (controller == "" || key.controller == controller) && (!failedOnly || record.Error != "") {
+			records = append(records, record)
+		}
+	}
+	sortRecords(records)
+	return records
+}
+
+func sortRecords(records []ReconcileRecord) {
+	sort.Slice(records, func(i, j int) bool {
+		if !records[i].Start.Equal(records[j].Start) {
+			return records[i].Start.Before(records[j].Start)
+		}
+		return records[i].Key < records[j].Key
+	})
+}
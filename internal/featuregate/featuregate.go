@@ -0,0 +1,194 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package featuregate 提供特性开关，用于安全地试用实验性的控制器行为
+// 特性分为 alpha、beta、GA 三个阶段：alpha 默认关闭，beta 默认开启，GA 始终开启且不能关闭
+package featuregate
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// Feature is the name of a feature gate.
+type Feature string
+
+// Stage is the maturity of a feature.
+type Stage string
+
+const (
+	// Alpha features are disabled by default and may change or be removed at any time.
+	Alpha Stage = "ALPHA"
+	// Beta features are enabled by default and can still be disabled.
+	Beta Stage = "BETA"
+	// GA features are always enabled, the gate is kept until it is removed.
+	GA Stage = "GA"
+)
+
+// FeatureSpec describes a feature gate.
+type FeatureSpec struct {
+	// Default is the state of the gate when it is not set.
+	Default bool
+	// Stage is the maturity of the feature.
+	Stage Stage
+	// LockToDefault rejects setting the gate to anything but its default.
+	LockToDefault bool
+}
+
+// FeatureStatus is the state of a feature gate.
+type FeatureStatus struct {
+	Name    Feature `json:"name"`
+	Stage   Stage   `json:"stage"`
+	Default bool    `json:"default"`
+	Enabled bool    `json:"enabled"`
+}
+
+// Gate holds the known features and which of them are enabled. It is safe for
+// concurrent use, the enabled features are replaced as a whole by Set.
+type Gate struct {
+	known   map[Feature]FeatureSpec
+	enabled atomic.Pointer[map[Feature]bool]
+}
+
+// NewGate 返回包含 known 中特性的 Gate，所有特性处于默认状态
+func NewGate(known map[Feature]FeatureSpec) *Gate {
+	g := &Gate{known: map[Feature]FeatureSpec{}}
+	for name, spec := range known {
+		g.known[name] = spec
+	}
+	enabled := g.defaults()
+	g.enabled.Store(&enabled)
+	return g
+}
+
+// Enabled 判断特性是否开启，未知的特性视为关闭
+func (g *Gate) Enabled(name Feature) bool {
+	return (*g.enabled.Load())[name]
+}
+
+// Validate 校验需要设置的特性开关，返回所有未知或被锁定的特性
+func (g *Gate) Validate(gates map[string]bool, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, name := range sortedKeys(gates) {
+		spec, ok := g.known[Feature(name)]
+		switch {
+		case !ok:
+			allErrs = append(allErrs, field.NotSupported(fldPath.Key(name), name, g.names()))
+		case spec.LockToDefault && gates[name] != spec.Default:
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(name), gates[name],
+				fmt.Sprintf("the %s feature is locked to %t", spec.Stage, spec.Default)))
+		}
+	}
+	return allErrs
+}
+
+// Set 在默认状态之上设置特性开关，未声明的特性恢复默认状态
+func (g *Gate) Set(gates map[string]bool) error {
+	if err := g.Validate(gates, field.NewPath("featureGates")).ToAggregate(); err != nil {
+		return err
+	}
+	enabled := g.defaults()
+	for name, value := range gates {
+		enabled[Feature(name)] = value
+	}
+	g.enabled.Store(&enabled)
+	return nil
+}
+
+// Status 返回所有特性的状态，按名称排序
+func (g *Gate) Status() []FeatureStatus {
+	enabled := *g.enabled.Load()
+	statuses := make([]FeatureStatus, 0, len(g.known))
+	for _, name := range g.names() {
+		spec := g.known[Feature(name)]
+		statuses = append(statuses, FeatureStatus{
+			Name:    Feature(name),
+			Stage:   spec.Stage,
+			Default: spec.Default,
+			Enabled: enabled[Feature(name)],
+		})
+	}
+	return statuses
+}
+
+// Usage 返回命令行参数的帮助信息，列出所有特性及其阶段和默认值
+func (g *Gate) Usage() string {
+	lines := make([]string, 0, len(g.known))
+	for _, name := range g.names() {
+		spec := g.known[Feature(name)]
+		lines = append(lines, fmt.Sprintf("%s=true|false (%s - default=%t)", name, spec.Stage, spec.Default))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ServeHTTP 以 JSON 返回所有特性的状态
+func (g *Gate) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(g.Status())
+}
+
+func (g *Gate) defaults() map[Feature]bool {
+	enabled := make(map[Feature]bool, len(g.known))
+	for name, spec := range g.known {
+		enabled[name] = spec.Default
+	}
+	return enabled
+}
+
+func (g *Gate) names() []string {
+	names := make([]string, 0, len(g.known))
+	for name := range g.known {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Parse 解析 --feature-gates 参数，格式为逗号分隔的 Name=true|false
+func Parse(value string) (map[string]bool, error) {
+	gates := map[string]bool{}
+	for _, pair := range strings.Split(value, ",") {
+		if pair = strings.TrimSpace(pair); pair == "" {
+			continue
+		}
+		name, raw, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("missing value for feature gate %q, expected %s=true|false", pair, pair)
+		}
+		enabled, err := strconv.ParseBool(strings.TrimSpace(raw))
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for feature gate %s: %w", raw, name, err)
+		}
+		gates[strings.TrimSpace(name)] = enabled
+	}
+	return gates, nil
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package featuregate
+
+import (
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+const (
+	alphaFeature Feature = "AlphaFeature"
+	betaFeature  Feature = "BetaFeature"
+	gaFeature    Feature = "GAFeature"
+)
+
+func newTestGate() *Gate {
+	return NewGate(map[Feature]FeatureSpec{
+		alphaFeature: {Default: false, Stage: Alpha},
+		betaFeature:  {Default: true, Stage: Beta},
+		gaFeature:    {Default: true, Stage: GA, LockToDefault: true},
+	})
+}
+
+func TestGateDefaults(t *testing.T) {
+	g := newTestGate()
+	if g.Enabled(alphaFeature) || !g.Enabled(betaFeature) || !g.Enabled(gaFeature) {
+		t.Fatalf("unexpected defaults: %+v", g.Status())
+	}
+	if g.Enabled("Unknown") {
+		t.Fatalf("unknown features must be disabled")
+	}
+}
+
+func TestGateSet(t *testing.T) {
+	g := newTestGate()
+	if err := g.Set(map[string]bool{"AlphaFeature": true, "BetaFeature": false}); err != nil {
+		t.Fatalf("set feature gates: %v", err)
+	}
+	if !g.Enabled(alphaFeature) || g.Enabled(betaFeature) {
+		t.Fatalf("feature gates not applied: %+v", g.Status())
+	}
+
+	// 再次设置时未声明的特性恢复默认状态
+	if err := g.Set(nil); err != nil {
+		t.Fatalf("reset feature gates: %v", err)
+	}
+	if g.Enabled(alphaFeature) || !g.Enabled(betaFeature) {
+		t.Fatalf("feature gates not reset: %+v", g.Status())
+	}
+}
+
+func TestGateValidate(t *testing.T) {
+	g := newTestGate()
+	errs := g.Validate(map[string]bool{"Unknown": true, "GAFeature": false, "AlphaFeature": true}, field.NewPath("featureGates"))
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 errors, got %v", errs)
+	}
+	if err := g.Set(map[string]bool{"GAFeature": false}); err == nil {
+		t.Fatalf("expected an error disabling a locked feature")
+	}
+	if !g.Enabled(gaFeature) {
+		t.Fatalf("a rejected Set must not change the gates")
+	}
+}
+
+func TestParse(t *testing.T) {
+	gates, err := Parse(" AlphaFeature=true, BetaFeature=false ,")
+	if err != nil {
+		t.Fatalf("parse feature gates: %v", err)
+	}
+	if len(gates) != 2 || !gates["AlphaFeature"] || gates["BetaFeature"] {
+		t.Fatalf("unexpected feature gates: %v", gates)
+	}
+
+	for _, value := range []string{"AlphaFeature", "AlphaFeature=yes"} {
+		if _, err := Parse(value); err == nil || !strings.Contains(err.Error(), "AlphaFeature") {
+			t.Fatalf("expected an error parsing %q, got %v", value, err)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package featuregate
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+// 新增特性时在此声明，并在 defaultFeatures 中登记阶段和默认值
+// 特性进入 GA 后锁定为开启，在之后的版本中删除开关及关闭时的代码路径
+const (
+	// DriftCorrection reverts changes made to the child resources of an Application
+	// outside the operator, instead of only updating them when the Application changes.
+	DriftCorrection Feature = "DriftCorrection"
+)
+
+var defaultFeatures = map[Feature]FeatureSpec{
+	DriftCorrection: {Default: false, Stage: Alpha},
+}
+
+// Default 是 Manager 使用的 Gate，由 --feature-gates 参数和配置文件中的 featureGates 设置
+// 调谐器和 webhook 通过 Enabled 查询特性是否开启
+var Default = NewGate(defaultFeatures)
+
+// Enabled 判断 Default 中的特性是否开启
+func Enabled(name Feature) bool {
+	return Default.Enabled(name)
+}
+
+// featureEnabledDesc 描述各特性是否开启，指标在采集时从 Default 读取，特性开关变化后无需额外更新
+var featureEnabledDesc = prometheus.NewDesc(
+	"clusterops_feature_enabled",
+	"Whether a feature gate is enabled (1) or disabled (0).",
+	[]string{"name", "stage"}, nil,
+)
+
+type gateCollector struct {
+	gate *Gate
+}
+
+func (c gateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- featureEnabledDesc
+}
+
+func (c gateCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, status := range c.gate.Status() {
+		value := 0.0
+		if status.Enabled {
+			value = 1
+		}
+		ch <- prometheus.MustNewConstMetric(featureEnabledDesc, prometheus.GaugeValue, value, string(status.Name), string(status.Stage))
+	}
+}
+
+func init() {
+	// 注册到 controller-runtime 的全局 Registry，随 Manager 的 metrics 端点一起暴露
+	metrics.Registry.MustRegister(gateCollector{gate: Default})
+}
@@ -0,0 +1,135 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package health 提供 Manager 的就绪检查和诊断检查，各项检查分别注册，/readyz?verbose 列出每项检查的结果，
+// /readyz/<name> 返回失败的原因。诊断检查反映所有副本共同依赖的状态，不影响就绪状态，通过 /debug/health 查询
+package health
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// CheckTimeout 是单项检查的超时时间，所有检查依次执行，总耗时需要小于 readinessProbe 的 timeoutSeconds
+const CheckTimeout = time.Second
+
+// CacheSynced 检查 informer 缓存是否已同步，缓存未同步时控制器读到的对象可能不完整
+func CacheSynced(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), CheckTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return fmt.Errorf("informer caches have not synced")
+		}
+		return nil
+	}
+}
+
+// WebhookServing 检查 webhook server 是否已经开始服务，并且证书可以加载且处于有效期内
+// certDir 为空时使用 controller-runtime 的默认目录
+func WebhookServing(server webhook.Server, certDir string) healthz.Checker {
+	if certDir == "" {
+		certDir = filepath.Join(os.TempDir(), "k8s-webhook-server", "serving-certs")
+	}
+	started := server.StartedChecker()
+	return func(req *http.Request) error {
+		if err := started(req); err != nil {
+			return err
+		}
+		return checkCertificate(filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key"), time.Now())
+	}
+}
+
+func checkCertificate(certFile, keyFile string, now time.Time) error {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return fmt.Errorf("load webhook certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parse webhook certificate: %w", err)
+	}
+	if now.Before(cert.NotBefore) {
+		return fmt.Errorf("webhook certificate is not valid before %s", cert.NotBefore.UTC().Format(time.RFC3339))
+	}
+	if now.After(cert.NotAfter) {
+		return fmt.Errorf("webhook certificate expired at %s", cert.NotAfter.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// LeaderElection 检查 leader election 的状态是否已知：本副本已经成为 leader，或者 Lease 由其他存活的副本持有
+// 两者都不满足时(例如无法读取 Lease 或 leader 已经失联)，本副本既不调谐也无法确认有其他副本在调谐
+// 所有副本的检查结果相同，只作为诊断检查，不能用于就绪检查，否则所有副本会同时下线
+func LeaderElection(elected <-chan struct{}, reader client.Reader, lease client.ObjectKey) healthz.Checker {
+	return func(req *http.Request) error {
+		select {
+		case <-elected:
+			return nil
+		default:
+		}
+
+		ctx, cancel := context.WithTimeout(req.Context(), CheckTimeout)
+		defer cancel()
+		obj := &coordinationv1.Lease{}
+		if err := reader.Get(ctx, lease, obj); err != nil {
+			return fmt.Errorf("get leader election Lease %s: %w", lease, err)
+		}
+		if !leaseHeld(obj, time.Now()) {
+			return fmt.Errorf("leader election Lease %s is not held by a live leader", lease)
+		}
+		return nil
+	}
+}
+
+func leaseHeld(lease *coordinationv1.Lease, now time.Time) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || *spec.HolderIdentity == "" || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	return spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second).After(now)
+}
+
+// APIServerReachable 检查 apiserver 是否可以访问，apiserver 的 /readyz 对所有用户开放，不需要额外的权限
+// apiserver 短暂不可用时所有副本的检查同时失败，只作为诊断检查，不能用于就绪检查
+func APIServerReachable(config *rest.Config) (healthz.Checker, error) {
+	client, err := discovery.NewDiscoveryClientForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), CheckTimeout)
+		defer cancel()
+		if err := client.RESTClient().Get().AbsPath("/readyz").Do(ctx).Error(); err != nil {
+			return fmt.Errorf("apiserver is not reachable: %w", err)
+		}
+		return nil
+	}, nil
+}
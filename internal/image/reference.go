@@ -0,0 +1,86 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DefaultRegistry 是镜像未指明仓库地址时使用的仓库
+const DefaultRegistry = "docker.io"
+
+var digestPattern = regexp.MustCompile(`^sha256:[a-f0-9]{64}$`)
+
+// Split 将镜像引用拆分为名称、tag 和 digest，例如 nginx:1.25@sha256:... 拆分为 nginx、1.25 和 sha256:...
+func Split(image string) (name, tag, digest string) {
+	name = image
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+	}
+	// 仓库地址中的端口同样以冒号分隔，只有最后一个 / 之后的冒号才是 tag 的分隔符
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+	return name, tag, digest
+}
+
+// Name 返回不带 tag 和 digest 的镜像名称
+func Name(image string) string {
+	name, _, _ := Split(image)
+	return name
+}
+
+// IsPinned 判断镜像引用是否已经固定到 digest
+func IsPinned(image string) bool {
+	_, _, digest := Split(image)
+	return digest != ""
+}
+
+// Pin 将镜像引用固定到 digest，原有的 tag 和 digest 会被丢弃
+func Pin(image, digest string) string {
+	return Name(image) + "@" + digest
+}
+
+// ValidDigest 判断 digest 是否为 sha256:<64 位十六进制> 的格式
+func ValidDigest(digest string) bool {
+	return digestPattern.MatchString(digest)
+}
+
+// Normalize 为未指明仓库地址的镜像补全默认仓库，例如 nginx 补全为 docker.io/library/nginx
+func Normalize(image string) string {
+	parts := strings.SplitN(image, "/", 2)
+	if len(parts) == 2 && (strings.ContainsAny(parts[0], ".:") || parts[0] == "localhost") {
+		return image
+	}
+	if len(parts) == 1 {
+		return DefaultRegistry + "/library/" + image
+	}
+	return DefaultRegistry + "/" + image
+}
+
+// Allowed 判断镜像是否来自允许的仓库，允许的仓库可以带有仓库路径前缀，例如 docker.io/library
+func Allowed(image string, allowed []string) bool {
+	normalized := Normalize(image)
+	for _, registry := range allowed {
+		registry = strings.TrimSuffix(registry, "/")
+		if normalized == registry || strings.HasPrefix(normalized, registry+"/") {
+			return true
+		}
+	}
+	return false
+}
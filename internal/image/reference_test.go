@@ -0,0 +1,56 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		image, name, tag, digest string
+	}{
+		{"nginx", "nginx", "", ""},
+		{"nginx:1.25", "nginx", "1.25", ""},
+		{"localhost:5000/nginx", "localhost:5000/nginx", "", ""},
+		{"localhost:5000/nginx:1.25", "localhost:5000/nginx", "1.25", ""},
+		{"nginx:1.25@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "nginx", "1.25", "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+		{"registry.example.com/nginx@sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa", "registry.example.com/nginx", "", "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"},
+	}
+	for _, tt := range tests {
+		name, tag, digest := Split(tt.image)
+		if name != tt.name || tag != tt.tag || digest != tt.digest {
+			t.Errorf("Split(%q) = %q, %q, %q, want %q, %q, %q", tt.image, name, tag, digest, tt.name, tt.tag, tt.digest)
+		}
+	}
+}
+
+func TestLoadStaticResolver(t *testing.T) {
+	resolver, err := LoadStaticResolver([]byte("images:\n  nginx: sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa\n"))
+	if err != nil {
+		t.Fatalf("load resolver: %v", err)
+	}
+	digest, err := resolver.Resolve(context.Background(), "docker.io/library/nginx:latest")
+	if err != nil || digest != "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa" {
+		t.Errorf("Resolve() = %q, %v", digest, err)
+	}
+
+	if _, err := LoadStaticResolver([]byte("images:\n  nginx: latest\n")); err == nil {
+		t.Error("expected an error for an invalid digest")
+	}
+}
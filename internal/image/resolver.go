@@ -0,0 +1,81 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"sigs.k8s.io/yaml"
+)
+
+// ErrNotFound 表示解析器无法找到镜像对应的 digest
+var ErrNotFound = errors.New("image digest not found")
+
+// Resolver resolves image references to the digest of the manifest they point at.
+type Resolver interface {
+	// Resolve returns the digest, e.g. sha256:..., of the given image reference.
+	Resolve(ctx context.Context, image string) (string, error)
+}
+
+// StaticResolver resolves images from a fixed table keyed by normalized image
+// references. It backs the file-based resolver and fakes registries in tests.
+type StaticResolver map[string]string
+
+var _ Resolver = StaticResolver{}
+
+// staticResolverFile 是 LoadStaticResolver 读取的文件格式
+type staticResolverFile struct {
+	// Images 的 key 为镜像引用，value 为 digest，例如 nginx:1.25: sha256:...
+	Images map[string]string `json:"images"`
+}
+
+// LoadStaticResolver 解析 YAML 格式的镜像 digest 表，镜像引用在加载时统一规范化
+func LoadStaticResolver(data []byte) (StaticResolver, error) {
+	file := &staticResolverFile{}
+	if err := yaml.UnmarshalStrict(data, file); err != nil {
+		return nil, err
+	}
+
+	resolver := StaticResolver{}
+	for image, digest := range file.Images {
+		if !ValidDigest(digest) {
+			return nil, fmt.Errorf("invalid digest %q of image %s", digest, image)
+		}
+		resolver[resolverKey(image)] = digest
+	}
+	return resolver, nil
+}
+
+// Resolve implements Resolver.
+func (r StaticResolver) Resolve(_ context.Context, image string) (string, error) {
+	digest, ok := r[resolverKey(image)]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, image)
+	}
+	return digest, nil
+}
+
+// resolverKey 规范化镜像引用，未指明 tag 时使用 latest，使 nginx 与 docker.io/library/nginx:latest 命中同一条记录
+func resolverKey(image string) string {
+	name, tag, _ := Split(image)
+	if tag == "" {
+		tag = "latest"
+	}
+	return Normalize(name) + ":" + tag
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package security 按 Pod 安全标准为 Pod 模板注入默认的 securityContext 并校验违规项，
+// webhook 用于 Application 自身声明的 Pod 模板，控制器用于合并模板之后渲染出的 Pod 模板
+package security
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// 以下取值参考 https://kubernetes.io/docs/concepts/security/pod-security-standards/
+var (
+	// baselineCapabilities 是 baseline 级别允许添加的 capabilities
+	baselineCapabilities = sets.New[corev1.Capability](
+		"AUDIT_WRITE", "CHOWN", "DAC_OVERRIDE", "FOWNER", "FSETID", "KILL", "MKNOD",
+		"NET_BIND_SERVICE", "SETFCAP", "SETGID", "SETPCAP", "SETUID", "SYS_CHROOT")
+	// restrictedCapabilities 是 restricted 级别允许添加的 capabilities
+	restrictedCapabilities = sets.New[corev1.Capability]("NET_BIND_SERVICE")
+	// safeSysctls 是 baseline 级别允许设置的 sysctls
+	safeSysctls = sets.New(
+		"kernel.shm_rmid_forced", "net.ipv4.ip_local_port_range", "net.ipv4.ip_unprivileged_port_start",
+		"net.ipv4.tcp_syncookies", "net.ipv4.ping_group_range")
+	// seLinuxTypes 是 baseline 级别允许的 SELinux type，空字符串表示未设置
+	seLinuxTypes = sets.New("", "container_t", "container_init_t", "container_kvm_t")
+)
+
+const appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+
+// Profile 返回 app 的安全配置，未声明 spec.security 时返回空字符串，不注入也不校验
+func Profile(app *appsv1.Application) appsv1.SecurityProfile {
+	if app.Spec.Security == nil {
+		return ""
+	}
+	if app.Spec.Security.Profile == "" {
+		return appsv1.SecurityProfileRestricted
+	}
+	return app.Spec.Security.Profile
+}
+
+// podContainers 返回 Pod 模板中的 init 容器和普通容器及其字段路径
+func podContainers(podSpec *corev1.PodSpec, podSpecPath *field.Path) ([]*corev1.Container, []*field.Path) {
+	var containers []*corev1.Container
+	var paths []*field.Path
+	for i := range podSpec.InitContainers {
+		containers = append(containers, &podSpec.InitContainers[i])
+		paths = append(paths, podSpecPath.Child("initContainers").Index(i))
+	}
+	for i := range podSpec.Containers {
+		containers = append(containers, &podSpec.Containers[i])
+		paths = append(paths, podSpecPath.Child("containers").Index(i))
+	}
+	return containers, paths
+}
+
+// Apply 按安全配置为 Pod 模板中未设置的 securityContext 字段注入默认值，已声明的字段保持不变
+// baseline 只注入 RuntimeDefault seccompProfile；restricted 另外注入 runAsNonRoot、readOnlyRootFilesystem、
+// allowPrivilegeEscalation=false 并丢弃全部 capabilities
+func Apply(profile appsv1.SecurityProfile, template *corev1.PodTemplateSpec) {
+	if profile != appsv1.SecurityProfileRestricted && profile != appsv1.SecurityProfileBaseline {
+		return
+	}
+
+	podSpec := &template.Spec
+	if podSpec.SecurityContext == nil {
+		podSpec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	if podSpec.SecurityContext.SeccompProfile == nil {
+		podSpec.SecurityContext.SeccompProfile = &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault}
+	}
+	if profile != appsv1.SecurityProfileRestricted {
+		return
+	}
+
+	if podSpec.SecurityContext.RunAsNonRoot == nil {
+		podSpec.SecurityContext.RunAsNonRoot = boolPtr(true)
+	}
+	containers, _ := podContainers(podSpec, field.NewPath("spec"))
+	for _, container := range containers {
+		if container.SecurityContext == nil {
+			container.SecurityContext = &corev1.SecurityContext{}
+		}
+		sc := container.SecurityContext
+		if sc.AllowPrivilegeEscalation == nil {
+			sc.AllowPrivilegeEscalation = boolPtr(false)
+		}
+		if sc.ReadOnlyRootFilesystem == nil {
+			sc.ReadOnlyRootFilesystem = boolPtr(true)
+		}
+		if sc.Capabilities == nil {
+			sc.Capabilities = &corev1.Capabilities{}
+		}
+		if len(sc.Capabilities.Drop) == 0 {
+			sc.Capabilities.Drop = []corev1.Capability{"ALL"}
+		}
+	}
+}
+
+// Validate 按安全配置校验 templatePath 处的 Pod 模板，每一项违规单独返回，便于用户逐项修正
+func Validate(profile appsv1.SecurityProfile, template *corev1.PodTemplateSpec, templatePath *field.Path) field.ErrorList {
+	if profile != appsv1.SecurityProfileRestricted && profile != appsv1.SecurityProfileBaseline {
+		return nil
+	}
+
+	allErrs := validateBaseline(template.Annotations, &template.Spec, templatePath)
+	if profile == appsv1.SecurityProfileRestricted {
+		allErrs = append(allErrs, validateRestricted(&template.Spec, templatePath.Child("spec"))...)
+	}
+	for i := range allErrs {
+		allErrs[i].Detail = fmt.Sprintf("%s (security profile %s)", allErrs[i].Detail, profile)
+	}
+	return allErrs
+}
+
+// validateBaseline 校验 baseline 级别的 Pod 安全标准
+func validateBaseline(annotations map[string]string, podSpec *corev1.PodSpec, templatePath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := templatePath.Child("spec")
+
+	if podSpec.HostNetwork {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("hostNetwork"), "host network is not allowed"))
+	}
+	if podSpec.HostPID {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("hostPID"), "host PID namespace is not allowed"))
+	}
+	if podSpec.HostIPC {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("hostIPC"), "host IPC namespace is not allowed"))
+	}
+	for i, volume := range podSpec.Volumes {
+		if volume.HostPath != nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("volumes").Index(i).Child("hostPath"), "hostPath volumes are not allowed"))
+		}
+	}
+	for key, value := range annotations {
+		if !strings.HasPrefix(key, appArmorAnnotationPrefix) {
+			continue
+		}
+		if value != "runtime/default" && !strings.HasPrefix(value, "localhost/") {
+			allErrs = append(allErrs, field.Invalid(templatePath.Child("metadata", "annotations").Key(key), value,
+				"AppArmor profile must be runtime/default or localhost/*"))
+		}
+	}
+
+	if sc := podSpec.SecurityContext; sc != nil {
+		scPath := specPath.Child("securityContext")
+		allErrs = append(allErrs, validateSeccompBaseline(sc.SeccompProfile, scPath.Child("seccompProfile"))...)
+		allErrs = append(allErrs, validateSELinux(sc.SELinuxOptions, scPath.Child("seLinuxOptions"))...)
+		for i, sysctl := range sc.Sysctls {
+			if !safeSysctls.Has(sysctl.Name) {
+				allErrs = append(allErrs, field.Forbidden(scPath.Child("sysctls").Index(i).Child("name"),
+					fmt.Sprintf("sysctl %s is not allowed", sysctl.Name)))
+			}
+		}
+	}
+
+	containers, paths := podContainers(podSpec, specPath)
+	for i, container := range containers {
+		for j, port := range container.Ports {
+			if port.HostPort != 0 {
+				allErrs = append(allErrs, field.Forbidden(paths[i].Child("ports").Index(j).Child("hostPort"), "host ports are not allowed"))
+			}
+		}
+
+		sc := container.SecurityContext
+		if sc == nil {
+			continue
+		}
+		scPath := paths[i].Child("securityContext")
+		if sc.Privileged != nil && *sc.Privileged {
+			allErrs = append(allErrs, field.Forbidden(scPath.Child("privileged"), "privileged containers are not allowed"))
+		}
+		if sc.ProcMount != nil && *sc.ProcMount != corev1.DefaultProcMount {
+			allErrs = append(allErrs, field.NotSupported(scPath.Child("procMount"), *sc.ProcMount, []string{string(corev1.DefaultProcMount)}))
+		}
+		if sc.Capabilities != nil {
+			for j, capability := range sc.Capabilities.Add {
+				if !baselineCapabilities.Has(capability) {
+					allErrs = append(allErrs, field.Forbidden(scPath.Child("capabilities", "add").Index(j),
+						fmt.Sprintf("capability %s is not allowed", capability)))
+				}
+			}
+		}
+		allErrs = append(allErrs, validateSeccompBaseline(sc.SeccompProfile, scPath.Child("seccompProfile"))...)
+		allErrs = append(allErrs, validateSELinux(sc.SELinuxOptions, scPath.Child("seLinuxOptions"))...)
+	}
+	return allErrs
+}
+
+// validateRestricted 校验 restricted 级别在 baseline 之外追加的要求
+func validateRestricted(podSpec *corev1.PodSpec, specPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+
+	for i, volume := range podSpec.Volumes {
+		if volume.ConfigMap == nil && volume.CSI == nil && volume.DownwardAPI == nil && volume.EmptyDir == nil &&
+			volume.Ephemeral == nil && volume.PersistentVolumeClaim == nil && volume.Projected == nil && volume.Secret == nil {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("volumes").Index(i),
+				"only configMap, csi, downwardAPI, emptyDir, ephemeral, persistentVolumeClaim, projected and secret volumes are allowed"))
+		}
+	}
+
+	podSC := podSpec.SecurityContext
+	if podSC == nil {
+		podSC = &corev1.PodSecurityContext{}
+	}
+	podSCPath := specPath.Child("securityContext")
+	if podSC.RunAsNonRoot != nil && !*podSC.RunAsNonRoot {
+		allErrs = append(allErrs, field.Invalid(podSCPath.Child("runAsNonRoot"), false, "must be true"))
+	}
+	if podSC.RunAsUser != nil && *podSC.RunAsUser == 0 {
+		allErrs = append(allErrs, field.Invalid(podSCPath.Child("runAsUser"), 0, "must not run as root"))
+	}
+
+	containers, paths := podContainers(podSpec, specPath)
+	for i, container := range containers {
+		sc := container.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		scPath := paths[i].Child("securityContext")
+
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			allErrs = append(allErrs, field.Required(scPath.Child("allowPrivilegeEscalation"), "must be set to false"))
+		}
+
+		// runAsNonRoot 和 seccompProfile 可以在 Pod 级别统一设置，容器级别的设置优先
+		runAsNonRoot := podSC.RunAsNonRoot
+		if sc.RunAsNonRoot != nil {
+			runAsNonRoot = sc.RunAsNonRoot
+		}
+		if runAsNonRoot == nil || !*runAsNonRoot {
+			allErrs = append(allErrs, field.Required(scPath.Child("runAsNonRoot"),
+				"must be set to true in the container or pod securityContext"))
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			allErrs = append(allErrs, field.Invalid(scPath.Child("runAsUser"), 0, "must not run as root"))
+		}
+
+		seccompProfile := podSC.SeccompProfile
+		if sc.SeccompProfile != nil {
+			seccompProfile = sc.SeccompProfile
+		}
+		if seccompProfile == nil {
+			allErrs = append(allErrs, field.Required(scPath.Child("seccompProfile"),
+				"must be set to RuntimeDefault or Localhost in the container or pod securityContext"))
+		}
+
+		capabilities := sc.Capabilities
+		if capabilities == nil {
+			capabilities = &corev1.Capabilities{}
+		}
+		if !sets.New(capabilities.Drop...).Has("ALL") {
+			allErrs = append(allErrs, field.Required(scPath.Child("capabilities", "drop"), "must include ALL"))
+		}
+		for j, capability := range capabilities.Add {
+			if !restrictedCapabilities.Has(capability) {
+				allErrs = append(allErrs, field.Forbidden(scPath.Child("capabilities", "add").Index(j),
+					fmt.Sprintf("capability %s is not allowed, only NET_BIND_SERVICE may be added", capability)))
+			}
+		}
+	}
+	return allErrs
+}
+
+// validateSeccompBaseline 拒绝 Unconfined 类型的 seccompProfile
+func validateSeccompBaseline(profile *corev1.SeccompProfile, fldPath *field.Path) field.ErrorList {
+	if profile == nil || profile.Type != corev1.SeccompProfileTypeUnconfined {
+		return nil
+	}
+	return field.ErrorList{field.NotSupported(fldPath.Child("type"), profile.Type,
+		[]string{string(corev1.SeccompProfileTypeRuntimeDefault), string(corev1.SeccompProfileTypeLocalhost)})}
+}
+
+// validateSELinux 只允许容器相关的 SELinux type，并禁止设置 user 和 role
+func validateSELinux(options *corev1.SELinuxOptions, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if options == nil {
+		return allErrs
+	}
+	if !seLinuxTypes.Has(options.Type) {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), options.Type, []string{"container_t", "container_init_t", "container_kvm_t"}))
+	}
+	if options.User != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("user"), "setting the SELinux user is not allowed"))
+	}
+	if options.Role != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("role"), "setting the SELinux role is not allowed"))
+	}
+	return allErrs
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
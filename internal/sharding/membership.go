@@ -0,0 +1,210 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// GroupLabel 标识 Lease 所属的分片组，同一组中的副本共同分担 Application
+const GroupLabel = "apps.clusterops.io/shard-group"
+
+//+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;watch;create;update;patch;delete
+
+// Membership maintains the Lease of this replica and the hash ring of all live
+// replicas of the group. A replica is live while its Lease is renewed.
+type Membership struct {
+	// Client 写入本副本的 Lease
+	Client client.Client
+	// Reader 直接从 apiserver 列出 Lease，避免为 Lease 建立缓存
+	Reader client.Reader
+
+	// Namespace of the Leases.
+	Namespace string
+	// Group of the replicas sharing the Applications, used as Lease name prefix and GroupLabel value.
+	Group string
+	// Identity of this replica, unique within the group.
+	Identity string
+	// LeaseDuration is how long a replica is considered live after its last renewal.
+	LeaseDuration time.Duration
+	// RenewInterval is how often the Lease is renewed and the members are listed.
+	RenewInterval time.Duration
+	// VirtualNodes of each member on the hash ring.
+	VirtualNodes int
+
+	ring atomic.Pointer[Ring]
+	// renewed 是最近一次成功续约的时间(UnixNano)，超过 LeaseDuration 后其他副本会认为本副本已经离开
+	renewed atomic.Int64
+	changed chan struct{}
+}
+
+var _ manager.Runnable = &Membership{}
+var _ manager.LeaderElectionRunnable = &Membership{}
+
+// NewMembership 返回一个 Membership，调用方设置 Client、Reader 等字段后加入 Manager 运行
+func NewMembership() *Membership {
+	return &Membership{changed: make(chan struct{}, 1)}
+}
+
+// Changed 在成员变化后收到通知，多次变化可能合并为一次通知
+func (m *Membership) Changed() <-chan struct{} {
+	return m.changed
+}
+
+// Owns 判断 key 是否由本副本负责，尚未完成第一次成员同步时返回 false，避免与其他副本重复调谐
+// 续约失败超过 LeaseDuration 后本副本的 Lease 已经过期，其他副本会接管它的 key，此时同样返回 false
+func (m *Membership) Owns(key string) bool {
+	ring := m.ring.Load()
+	return ring != nil && m.leaseValid(time.Now()) && ring.Owner(key) == m.Identity
+}
+
+// leaseValid 判断本副本最近一次成功续约的 Lease 在 now 时是否仍然有效
+func (m *Membership) leaseValid(now time.Time) bool {
+	return now.Before(time.Unix(0, m.renewed.Load()).Add(m.LeaseDuration))
+}
+
+// Members 返回当前存活的成员
+func (m *Membership) Members() []string {
+	if ring := m.ring.Load(); ring != nil {
+		return ring.Members()
+	}
+	return nil
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. 每个副本都需要维护自己的 Lease
+func (m *Membership) NeedLeaderElection() bool {
+	return false
+}
+
+// Start implements manager.Runnable. 停止时删除本副本的 Lease，使其他副本立即接管
+func (m *Membership) Start(ctx context.Context) error {
+	logger := ctrl.Log.WithName("sharding").WithValues("group", m.Group, "identity", m.Identity)
+
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := m.sync(ctx); err != nil {
+			logger.Error(err, "Failed to sync shard membership, will retry after a short time.")
+		}
+	}, m.RenewInterval)
+
+	releaseCtx, cancel := context.WithTimeout(context.Background(), m.RenewInterval)
+	defer cancel()
+	lease := &coordinationv1.Lease{ObjectMeta: metav1.ObjectMeta{Namespace: m.Namespace, Name: m.leaseName(m.Identity)}}
+	if err := m.Client.Delete(releaseCtx, lease); err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "Failed to release the shard Lease.")
+	}
+	return nil
+}
+
+// sync 续约本副本的 Lease 并根据存活的 Lease 重建哈希环
+func (m *Membership) sync(ctx context.Context) error {
+	logger := ctrl.Log.WithName("sharding").WithValues("group", m.Group, "identity", m.Identity)
+
+	renewedAt := time.Now()
+	if err := m.renew(ctx, renewedAt); err != nil {
+		// Lease 过期后清空哈希环，恢复续约时即使成员未变化也会通知重新调谐本副本负责的 Application
+		if !m.leaseValid(time.Now()) && m.ring.Swap(nil) != nil {
+			logger.Info("The shard Lease has expired, this replica stops reconciling until it is renewed.")
+		}
+		return err
+	}
+	m.renewed.Store(renewedAt.UnixNano())
+
+	leases := &coordinationv1.LeaseList{}
+	if err := m.Reader.List(ctx, leases, client.InNamespace(m.Namespace), client.MatchingLabels{GroupLabel: m.Group}); err != nil {
+		return err
+	}
+	now := time.Now()
+	var members []string
+	for i := range leases.Items {
+		if lease := &leases.Items[i]; leaseAlive(lease, now) {
+			members = append(members, *lease.Spec.HolderIdentity)
+		}
+	}
+
+	ring := NewRing(members, m.VirtualNodes)
+	if current := m.ring.Load(); current != nil && reflect.DeepEqual(current.Members(), ring.Members()) {
+		return nil
+	}
+	m.ring.Store(ring)
+	logger.Info("The shard members have changed.", "members", ring.Members())
+	select {
+	case m.changed <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// renew 以 renewedAt 作为续约时间创建或续约本副本的 Lease
+func (m *Membership) renew(ctx context.Context, renewedAt time.Time) error {
+	now := metav1.NewMicroTime(renewedAt)
+	durationSeconds := int32(m.LeaseDuration / time.Second)
+
+	lease := &coordinationv1.Lease{}
+	key := client.ObjectKey{Namespace: m.Namespace, Name: m.leaseName(m.Identity)}
+	if err := m.Reader.Get(ctx, key, lease); err != nil {
+		if !errors.IsNotFound(err) {
+			return err
+		}
+		lease = &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Namespace: key.Namespace,
+				Name:      key.Name,
+				Labels:    map[string]string{GroupLabel: m.Group},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.Identity,
+				LeaseDurationSeconds: &durationSeconds,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}
+		return m.Client.Create(ctx, lease)
+	}
+
+	if lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != m.Identity {
+		return fmt.Errorf("shard Lease %s is held by %s", key, *lease.Spec.HolderIdentity)
+	}
+	lease.Spec.HolderIdentity = &m.Identity
+	lease.Spec.LeaseDurationSeconds = &durationSeconds
+	lease.Spec.RenewTime = &now
+	return m.Client.Update(ctx, lease)
+}
+
+func (m *Membership) leaseName(identity string) string {
+	return m.Group + "-" + identity
+}
+
+// leaseAlive 判断 Lease 是否在有效期内
+func leaseAlive(lease *coordinationv1.Lease, now time.Time) bool {
+	spec := lease.Spec
+	if spec.HolderIdentity == nil || spec.RenewTime == nil || spec.LeaseDurationSeconds == nil {
+		return false
+	}
+	return spec.RenewTime.Add(time.Duration(*spec.LeaseDurationSeconds) * time.Second).After(now)
+}
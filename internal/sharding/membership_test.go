@@ -0,0 +1,88 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+)
+
+func TestMembershipStopsOwningAfterTheLeaseExpires(t *testing.T) {
+	ctx := context.Background()
+	unavailable := false
+	c := interceptor.NewClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).Build(), interceptor.Funcs{
+		Update: func(ctx context.Context, c client.WithWatch, obj client.Object, opts ...client.UpdateOption) error {
+			if unavailable {
+				return errors.New("apiserver unavailable")
+			}
+			return c.Update(ctx, obj, opts...)
+		},
+	})
+	m := NewMembership()
+	m.Client, m.Reader = c, c
+	m.Namespace, m.Group, m.Identity = "default", "clusterops", "replica-a"
+	m.LeaseDuration, m.RenewInterval, m.VirtualNodes = time.Second, 100*time.Millisecond, DefaultVirtualNodes
+
+	if err := m.sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	<-m.Changed()
+	if !m.Owns("default/web") {
+		t.Fatalf("the only member must own every key")
+	}
+
+	// 续约失败但 Lease 仍在有效期内时继续负责
+	unavailable = true
+	if err := m.sync(ctx); err == nil {
+		t.Fatalf("sync must fail while the Lease cannot be renewed")
+	}
+	if !m.Owns("default/web") {
+		t.Errorf("the member must keep its keys while its Lease is valid")
+	}
+
+	time.Sleep(m.LeaseDuration)
+	if m.Owns("default/web") {
+		t.Errorf("the member must not own keys after its Lease expired")
+	}
+	if err := m.sync(ctx); err == nil {
+		t.Fatalf("sync must fail while the Lease cannot be renewed")
+	}
+	if members := m.Members(); members != nil {
+		t.Errorf("members = %v, want the ring cleared after the Lease expired", members)
+	}
+
+	// 恢复续约后重新负责，并通知重新调谐本副本的 Application
+	unavailable = false
+	if err := m.sync(ctx); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	select {
+	case <-m.Changed():
+	default:
+		t.Errorf("renewing an expired Lease must notify the change")
+	}
+	if !m.Owns("default/web") {
+		t.Errorf("the member must own its keys again after renewing its Lease")
+	}
+}
@@ -0,0 +1,85 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package sharding 将 Application 按一致性哈希分配给多个 Operator 副本，副本之间通过 Lease 协调成员关系
+package sharding
+
+import (
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// DefaultVirtualNodes 是每个成员在哈希环上的虚拟节点数，虚拟节点越多，分配越均匀
+const DefaultVirtualNodes = 100
+
+// Ring is a consistent hash ring. Adding or removing a member only moves the
+// keys owned by that member.
+type Ring struct {
+	members []string
+	hashes  []uint32
+	owners  map[uint32]string
+}
+
+// NewRing 返回由 members 组成的哈希环，每个成员在环上有 virtualNodes 个虚拟节点
+func NewRing(members []string, virtualNodes int) *Ring {
+	if virtualNodes <= 0 {
+		virtualNodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{
+		members: append([]string{}, members...),
+		owners:  map[uint32]string{},
+	}
+	sort.Strings(r.members)
+	for _, member := range r.members {
+		for i := 0; i < virtualNodes; i++ {
+			hash := hashKey(member + "#" + strconv.Itoa(i))
+			// 哈希冲突时保留排序靠前的成员，使所有副本得出相同的结果
+			if _, ok := r.owners[hash]; ok {
+				continue
+			}
+			r.owners[hash] = member
+			r.hashes = append(r.hashes, hash)
+		}
+	}
+	sort.Slice(r.hashes, func(i, j int) bool { return r.hashes[i] < r.hashes[j] })
+	return r
+}
+
+// Members 返回环上的成员，按名称排序
+func (r *Ring) Members() []string {
+	return append([]string{}, r.members...)
+}
+
+// Owner 返回 key 所属的成员，环为空时返回空字符串
+func (r *Ring) Owner(key string) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	hash := hashKey(key)
+	i := sort.Search(len(r.hashes), func(i int) bool { return r.hashes[i] >= hash })
+	if i == len(r.hashes) {
+		i = 0
+	}
+	return r.owners[r.hashes[i]]
+}
+
+func hashKey(key string) uint32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return h.Sum32()
+}
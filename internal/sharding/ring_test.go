@@ -0,0 +1,82 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sharding
+
+import (
+	"fmt"
+	"testing"
+)
+
+func keys(n int) []string {
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = fmt.Sprintf("namespace-%d/app-%d", i%7, i)
+	}
+	return keys
+}
+
+func TestRingOwnerIsIndependentOfMemberOrder(t *testing.T) {
+	a := NewRing([]string{"a", "b", "c"}, DefaultVirtualNodes)
+	b := NewRing([]string{"c", "a", "b"}, DefaultVirtualNodes)
+	for _, key := range keys(1000) {
+		if a.Owner(key) != b.Owner(key) {
+			t.Fatalf("owner of %s differs between rings with the same members", key)
+		}
+	}
+}
+
+func TestRingEmpty(t *testing.T) {
+	if owner := NewRing(nil, DefaultVirtualNodes).Owner("default/demo"); owner != "" {
+		t.Fatalf("expected no owner on an empty ring, got %q", owner)
+	}
+}
+
+func TestRingBalance(t *testing.T) {
+	members := []string{"a", "b", "c", "d"}
+	ring := NewRing(members, DefaultVirtualNodes)
+
+	counts := map[string]int{}
+	all := keys(10000)
+	for _, key := range all {
+		counts[ring.Owner(key)]++
+	}
+	expected := len(all) / len(members)
+	for _, member := range members {
+		if counts[member] < expected/2 || counts[member] > expected*3/2 {
+			t.Fatalf("member %s owns %d of %d keys, the ring is unbalanced: %v", member, counts[member], len(all), counts)
+		}
+	}
+}
+
+// TestRingRebalance 确认成员加入或离开时只有相关成员的 key 发生迁移
+func TestRingRebalance(t *testing.T) {
+	before := NewRing([]string{"a", "b", "c"}, DefaultVirtualNodes)
+	after := NewRing([]string{"a", "b", "c", "d"}, DefaultVirtualNodes)
+
+	for _, key := range keys(10000) {
+		if owner := after.Owner(key); owner != before.Owner(key) && owner != "d" {
+			t.Fatalf("key %s moved from %s to %s, only keys moving to the new member are expected", key, before.Owner(key), owner)
+		}
+	}
+
+	removed := NewRing([]string{"a", "c"}, DefaultVirtualNodes)
+	for _, key := range keys(10000) {
+		if owner := before.Owner(key); owner != "b" && removed.Owner(key) != owner {
+			t.Fatalf("key %s moved from %s to %s although %s is still a member", key, owner, removed.Owner(key), owner)
+		}
+	}
+}
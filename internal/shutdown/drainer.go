@@ -0,0 +1,202 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package shutdown 实现 Manager 的优雅停止：收到停止信号后就绪检查失败，不再接收新的请求；
+// 等待正在执行的调谐完成(有超时)，随后导出链路追踪和审计日志等缓冲中的数据，最后由 Manager 释放 leader election 的 Lease
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// FlushTimeout 是导出缓冲数据的最长时间
+const FlushTimeout = 5 * time.Second
+
+// Flusher writes out the data a sink buffers before the manager exits.
+type Flusher struct {
+	Name  string
+	Flush func(context.Context) error
+}
+
+// Drainer tracks the reconciles in flight. When the manager stops, it waits for them to
+// finish and then runs the flushers in order. It runs with the leader election runnables,
+// which the manager stops before releasing the Lease, so the sinks are flushed before
+// another replica takes over.
+type Drainer struct {
+	// Timeout 是等待调谐完成的最长时间，超时后取消仍在执行的调谐，为 0 时不等待
+	Timeout time.Duration
+	// Flushers 在调谐完成或等待超时后按顺序执行
+	Flushers []Flusher
+
+	stopping atomic.Bool
+	mu       sync.Mutex
+	inFlight int
+	// idle 在没有正在执行的调谐时关闭
+	idle chan struct{}
+	// abort 在等待超时后关闭，取消仍在执行的调谐
+	abort     chan struct{}
+	abortOnce sync.Once
+}
+
+var (
+	_ manager.Runnable               = &Drainer{}
+	_ manager.LeaderElectionRunnable = &Drainer{}
+)
+
+// NewDrainer 返回一个没有正在执行的调谐的 Drainer
+func NewDrainer(timeout time.Duration, flushers ...Flusher) *Drainer {
+	idle := make(chan struct{})
+	close(idle)
+	return &Drainer{Timeout: timeout, Flushers: flushers, idle: idle, abort: make(chan struct{})}
+}
+
+// Wrap 返回记录正在执行的调谐的 Reconciler，d 为 nil 时直接返回 r
+// Manager 停止时会取消控制器传入的 ctx，调谐中的 API 请求随之失败，因此调谐改用不随 Manager 停止而取消的 ctx，
+// 只在等待超过 Timeout 后取消
+func (d *Drainer) Wrap(r reconcile.Reconciler) reconcile.Reconciler {
+	if d == nil {
+		return r
+	}
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		d.started()
+		defer d.finished()
+		return r.Reconcile(&drainContext{Context: ctx, abort: d.abort}, req)
+	})
+}
+
+// drainContext 保留 ctx 中的值(logger、trace 等)，但只在 abort 关闭时取消
+type drainContext struct {
+	context.Context
+	abort <-chan struct{}
+}
+
+func (c *drainContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (c *drainContext) Done() <-chan struct{} {
+	return c.abort
+}
+
+func (c *drainContext) Err() error {
+	select {
+	case <-c.abort:
+		return context.Canceled
+	default:
+		return nil
+	}
+}
+
+func (d *Drainer) started() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.inFlight == 0 {
+		d.idle = make(chan struct{})
+	}
+	d.inFlight++
+}
+
+func (d *Drainer) finished() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.inFlight--
+	if d.inFlight == 0 {
+		close(d.idle)
+	}
+}
+
+// InFlight 返回正在执行的调谐数量
+func (d *Drainer) InFlight() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.inFlight
+}
+
+// Wait 等待正在执行的调谐全部完成，ctx 结束时返回错误
+func (d *Drainer) Wait(ctx context.Context) error {
+	d.mu.Lock()
+	idle := d.idle
+	d.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d reconciles still in flight: %w", d.InFlight(), ctx.Err())
+	}
+}
+
+// Stopping 标记 Manager 正在停止，此后就绪检查失败
+func (d *Drainer) Stopping() {
+	d.stopping.Store(true)
+}
+
+// Check 是就绪检查，Manager 停止期间返回错误，使 Service 不再将 webhook 请求转发到本副本
+func (d *Drainer) Check(_ *http.Request) error {
+	if d.stopping.Load() {
+		return errors.New("the manager is shutting down")
+	}
+	return nil
+}
+
+// Start implements manager.Runnable. It blocks until ctx is done, then drains the reconciles
+// in flight and runs the flushers.
+func (d *Drainer) Start(ctx context.Context) error {
+	<-ctx.Done()
+	d.Stopping()
+	logger := ctrl.Log.WithName("shutdown")
+
+	// 控制器在停止时关闭工作队列，不再取出新的对象，这里只需等待已经开始的调谐
+	drainCtx, cancel := context.WithTimeout(context.Background(), d.Timeout)
+	defer cancel()
+	logger.Info("Waiting for in-flight reconciles to finish.", "inFlight", d.InFlight(), "timeout", d.Timeout)
+	if err := d.Wait(drainCtx); err != nil {
+		logger.Error(err, "Timed out waiting for in-flight reconciles, cancelling them and flushing anyway.")
+	}
+	d.abortOnce.Do(func() { close(d.abort) })
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), FlushTimeout)
+	defer cancelFlush()
+	d.Flush(flushCtx)
+	return nil
+}
+
+// Flush 依次执行所有 Flusher，单个失败不影响其他 Flusher
+func (d *Drainer) Flush(ctx context.Context) {
+	logger := ctrl.Log.WithName("shutdown")
+	for _, flusher := range d.Flushers {
+		if err := flusher.Flush(ctx); err != nil {
+			logger.Error(err, "Failed to flush.", "sink", flusher.Name)
+			continue
+		}
+		logger.Info("Flushed.", "sink", flusher.Name)
+	}
+}
+
+// NeedLeaderElection implements manager.LeaderElectionRunnable. The manager releases the Lease
+// only after the leader election runnables stop.
+func (d *Drainer) NeedLeaderElection() bool {
+	return true
+}
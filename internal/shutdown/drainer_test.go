@@ -0,0 +1,149 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+func TestDrainerWaitsForReconciles(t *testing.T) {
+	var flushed []string
+	flusher := func(name string, err error) Flusher {
+		return Flusher{Name: name, Flush: func(context.Context) error {
+			flushed = append(flushed, name)
+			return err
+		}}
+	}
+	d := NewDrainer(time.Minute, flusher("tracing", errors.New("collector unavailable")), flusher("audit", nil))
+
+	started, release := make(chan struct{}), make(chan struct{})
+	r := d.Wrap(reconcile.Func(func(context.Context, ctrl.Request) (ctrl.Result, error) {
+		close(started)
+		<-release
+		return ctrl.Result{}, nil
+	}))
+	go func() { _, _ = r.Reconcile(context.Background(), ctrl.Request{}) }()
+	<-started
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- d.Start(ctx) }()
+	if err := d.Check(nil); err != nil {
+		t.Fatalf("expected the drainer to be ready before the manager stops, got %v", err)
+	}
+	cancel()
+
+	select {
+	case <-stopped:
+		t.Fatalf("expected the drainer to wait for the reconcile in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if d.InFlight() != 1 || d.Check(nil) == nil {
+		t.Errorf("expected 1 reconcile in flight and the ready check to fail, got %d", d.InFlight())
+	}
+	if len(flushed) != 0 {
+		t.Errorf("expected the sinks to be flushed after the reconciles finish, got %v", flushed)
+	}
+
+	close(release)
+	if err := <-stopped; err != nil {
+		t.Fatalf("stop the drainer: %v", err)
+	}
+	// 单个 Flusher 失败不影响后续的 Flusher
+	if len(flushed) != 2 || flushed[0] != "tracing" || flushed[1] != "audit" {
+		t.Errorf("expected every sink flushed in order, got %v", flushed)
+	}
+}
+
+func TestDrainerTimeout(t *testing.T) {
+	d := NewDrainer(10 * time.Millisecond)
+	block := make(chan struct{})
+	defer close(block)
+	r := d.Wrap(reconcile.Func(func(context.Context, ctrl.Request) (ctrl.Result, error) {
+		<-block
+		return ctrl.Result{}, nil
+	}))
+	go func() { _, _ = r.Reconcile(context.Background(), ctrl.Request{}) }()
+	for d.InFlight() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := d.Wait(ctx); err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected waiting to time out, got %v", err)
+	}
+
+	// 超时后仍然正常退出，不阻塞 Manager 释放 Lease
+	stopCtx, stop := context.WithCancel(context.Background())
+	stop()
+	if err := d.Start(stopCtx); err != nil {
+		t.Errorf("expected the drainer to stop after the timeout, got %v", err)
+	}
+}
+
+func TestDrainerDetachesReconciles(t *testing.T) {
+	d := NewDrainer(50 * time.Millisecond)
+	managerCtx, stopManager := context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "logger"))
+	reconcileCtx := make(chan context.Context, 1)
+	block := make(chan struct{})
+	defer close(block)
+	r := d.Wrap(reconcile.Func(func(ctx context.Context, _ ctrl.Request) (ctrl.Result, error) {
+		reconcileCtx <- ctx
+		<-block
+		return ctrl.Result{}, nil
+	}))
+	go func() { _, _ = r.Reconcile(managerCtx, ctrl.Request{}) }()
+	ctx := <-reconcileCtx
+
+	// Manager 停止后调谐的 ctx 仍然有效，ctx 中的值保持不变
+	stopManager()
+	stopped := make(chan error)
+	go func() { stopped <- d.Start(managerCtx) }()
+	if ctx.Err() != nil || ctx.Value(ctxKey{}) != "logger" {
+		t.Fatalf("expected the reconcile to keep running after the manager stops, got %v", ctx.Err())
+	}
+
+	// 等待超时后取消仍在执行的调谐
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("expected the reconcile to be cancelled after the timeout")
+	}
+	if !errors.Is(ctx.Err(), context.Canceled) {
+		t.Errorf("expected the reconcile to be cancelled, got %v", ctx.Err())
+	}
+	if err := <-stopped; err != nil {
+		t.Errorf("stop the drainer: %v", err)
+	}
+}
+
+type ctxKey struct{}
+
+func TestNilDrainer(t *testing.T) {
+	var d *Drainer
+	r := reconcile.Func(func(context.Context, ctrl.Request) (ctrl.Result, error) { return ctrl.Result{}, nil })
+	if _, err := d.Wrap(r).Reconcile(context.Background(), ctrl.Request{}); err != nil {
+		t.Errorf("expected a nil Drainer to pass reconciles through, got %v", err)
+	}
+}
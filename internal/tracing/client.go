@@ -0,0 +1,172 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+)
+
+// WrapClient 返回为每次 API 调用创建 span 的 client，读取请求通常由缓存响应，span 同样记录其耗时
+func WrapClient(c client.Client) client.Client {
+	return &tracedClient{Client: c}
+}
+
+type tracedClient struct {
+	client.Client
+}
+
+var _ client.Client = &tracedClient{}
+
+// start 创建名为 "<Kind> <verb>" 的 span，例如 "Deployment update"
+func (c *tracedClient) start(ctx context.Context, verb string, obj client.Object, sub string) (context.Context, trace.Span) {
+	kind := c.kindOf(obj)
+	name := kind + " " + verb
+	if sub != "" {
+		name = kind + "/" + sub + " " + verb
+	}
+	attrs := []attribute.KeyValue{
+		attribute.String("k8s.verb", verb),
+		attribute.String("k8s.kind", kind),
+	}
+	if obj.GetNamespace() != "" {
+		attrs = append(attrs, attribute.String("k8s.namespace.name", obj.GetNamespace()))
+	}
+	if obj.GetName() != "" {
+		attrs = append(attrs, attribute.String("k8s.object.name", obj.GetName()))
+	}
+	return Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+func (c *tracedClient) kindOf(obj client.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return "Unknown"
+	}
+	return gvk.Kind
+}
+
+func (c *tracedClient) Get(ctx context.Context, key client.ObjectKey, obj client.Object, opts ...client.GetOption) error {
+	ctx, span := c.start(ctx, "get", obj, "")
+	span.SetAttributes(attribute.String("k8s.namespace.name", key.Namespace), attribute.String("k8s.object.name", key.Name))
+	err := c.Client.Get(ctx, key, obj, opts...)
+	// 调谐过程中经常通过 NotFound 判断子资源是否需要创建，不视为失败
+	End(span, client.IgnoreNotFound(err))
+	return err
+}
+
+func (c *tracedClient) List(ctx context.Context, list client.ObjectList, opts ...client.ListOption) error {
+	kind := "Unknown"
+	if gvk, err := apiutil.GVKForObject(list, c.Scheme()); err == nil {
+		kind = gvk.Kind
+	}
+	listOpts := (&client.ListOptions{}).ApplyOptions(opts)
+	ctx, span := Tracer().Start(ctx, kind+" list", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("k8s.verb", "list"),
+		attribute.String("k8s.kind", kind),
+		attribute.String("k8s.namespace.name", listOpts.Namespace),
+	))
+	err := c.Client.List(ctx, list, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedClient) Create(ctx context.Context, obj client.Object, opts ...client.CreateOption) error {
+	ctx, span := c.start(ctx, "create", obj, "")
+	err := c.Client.Create(ctx, obj, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	ctx, span := c.start(ctx, "update", obj, "")
+	err := c.Client.Update(ctx, obj, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	ctx, span := c.start(ctx, "patch", obj, "")
+	span.SetAttributes(attribute.String("k8s.patch_type", string(patch.Type())))
+	err := c.Client.Patch(ctx, obj, patch, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedClient) Delete(ctx context.Context, obj client.Object, opts ...client.DeleteOption) error {
+	ctx, span := c.start(ctx, "delete", obj, "")
+	err := c.Client.Delete(ctx, obj, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedClient) DeleteAllOf(ctx context.Context, obj client.Object, opts ...client.DeleteAllOfOption) error {
+	ctx, span := c.start(ctx, "deletecollection", obj, "")
+	err := c.Client.DeleteAllOf(ctx, obj, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedClient) Status() client.SubResourceWriter {
+	return c.SubResource("status")
+}
+
+func (c *tracedClient) SubResource(subResource string) client.SubResourceClient {
+	return &tracedSubResourceClient{
+		SubResourceClient: c.Client.SubResource(subResource),
+		client:            c,
+		subResource:       subResource,
+	}
+}
+
+type tracedSubResourceClient struct {
+	client.SubResourceClient
+	client      *tracedClient
+	subResource string
+}
+
+func (c *tracedSubResourceClient) Get(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceGetOption) error {
+	ctx, span := c.client.start(ctx, "get", obj, c.subResource)
+	err := c.SubResourceClient.Get(ctx, obj, subResource, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedSubResourceClient) Create(ctx context.Context, obj client.Object, subResource client.Object, opts ...client.SubResourceCreateOption) error {
+	ctx, span := c.client.start(ctx, "create", obj, c.subResource)
+	err := c.SubResourceClient.Create(ctx, obj, subResource, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedSubResourceClient) Update(ctx context.Context, obj client.Object, opts ...client.SubResourceUpdateOption) error {
+	ctx, span := c.client.start(ctx, "update", obj, c.subResource)
+	err := c.SubResourceClient.Update(ctx, obj, opts...)
+	End(span, err)
+	return err
+}
+
+func (c *tracedSubResourceClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.SubResourcePatchOption) error {
+	ctx, span := c.client.start(ctx, "patch", obj, c.subResource)
+	err := c.SubResourceClient.Patch(ctx, obj, patch, opts...)
+	End(span, err)
+	return err
+}
@@ -0,0 +1,36 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+)
+
+// Eventf 通过 recorder 记录 Event，ctx 中有 span 时在 Event 的注解中记录 trace ID。recorder 为 nil 时不记录
+func Eventf(ctx context.Context, recorder record.EventRecorder, obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if recorder == nil {
+		return
+	}
+	var annotations map[string]string
+	if traceID := TraceID(ctx); traceID != "" {
+		annotations = map[string]string{TraceIDAnnotation: traceID}
+	}
+	recorder.AnnotatedEventf(obj, annotations, eventType, reason, messageFmt, args...)
+}
@@ -0,0 +1,232 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package tracing 基于 OpenTelemetry 为调谐、子资源调谐函数、API 调用以及 webhook 准入处理生成链路追踪数据，
+// trace ID 同时写入日志和 Kubernetes Event，便于从任意一处定位到完整的调用链
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+const (
+	// InstrumentationName 是 Operator 创建的 span 所属的 instrumentation scope
+	InstrumentationName = "github.com/ahwhy/clusterops-operator"
+
+	// TraceIDAnnotation 是 Event 上记录 trace ID 的注解
+	TraceIDAnnotation = "clusterops.io/trace-id"
+
+	// DefaultOTLPEndpoint 是 OpenTelemetry collector OTLP/HTTP 接收器的默认地址
+	DefaultOTLPEndpoint = "http://localhost:4318"
+)
+
+// Exporter is the backend spans are exported to.
+type Exporter string
+
+const (
+	// ExporterNone disables tracing.
+	ExporterNone Exporter = "none"
+	// ExporterStdout writes spans to the standard output as JSON.
+	ExporterStdout Exporter = "stdout"
+	// ExporterFile writes spans to a file as JSON.
+	ExporterFile Exporter = "file"
+	// ExporterOTLP sends spans to an OpenTelemetry collector over OTLP/HTTP.
+	ExporterOTLP Exporter = "otlp"
+)
+
+// Options configures the TracerProvider built by Setup.
+type Options struct {
+	// Exporter 为空或 none 时不导出 span
+	Exporter Exporter
+	// Endpoint 是 OTLP/HTTP 的地址，例如 http://otel-collector:4318
+	Endpoint string
+	// File 是 file 导出器写入的文件
+	File string
+	// ServiceName 写入 resource 的 service.name
+	ServiceName string
+	// SampleRatio 是根 span 的采样比例，父 span 已采样时始终采样
+	SampleRatio float64
+}
+
+// Setup 根据 opts 创建 TracerProvider 并设置为全局的 TracerProvider 和 W3C trace context 传播器，
+// 返回的函数在退出前调用，导出缓冲中的 span 并关闭导出器
+func Setup(opts Options) (func(context.Context) error, error) {
+	exporter, err := newExporter(opts)
+	if err != nil {
+		return nil, err
+	}
+	if exporter == nil {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+func newExporter(opts Options) (sdktrace.SpanExporter, error) {
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return nil, nil
+	case ExporterStdout:
+		return stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case ExporterFile:
+		f, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &closingExporter{SpanExporter: exporter, closer: f}, nil
+	case ExporterOTLP:
+		return newOTLPExporter(opts.Endpoint)
+	default:
+		return nil, fmt.Errorf("unsupported tracing exporter %q", opts.Exporter)
+	}
+}
+
+// newOTLPExporter 返回向 endpoint 发送 span 的 OTLP/HTTP 导出器，endpoint 为空时使用 DefaultOTLPEndpoint
+// span 发送到 endpoint 的路径加上 /v1/traces，http 地址不使用 TLS
+func newOTLPExporter(endpoint string) (sdktrace.SpanExporter, error) {
+	if endpoint == "" {
+		endpoint = DefaultOTLPEndpoint
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("parse OTLP endpoint: %w", err)
+	}
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+	// HTTP 导出器创建时不建立连接，ctx 只用于创建过程
+	return otlptracehttp.New(context.Background(), opts...)
+}
+
+// closingExporter 在导出器关闭后关闭其写入的文件
+type closingExporter struct {
+	sdktrace.SpanExporter
+	closer io.Closer
+}
+
+func (e *closingExporter) Shutdown(ctx context.Context) error {
+	err := e.SpanExporter.Shutdown(ctx)
+	if closeErr := e.closer.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Flush 导出全局 TracerProvider 缓冲中的 span，未调用 Setup 时不做任何操作
+func Flush(ctx context.Context) error {
+	if provider, ok := otel.GetTracerProvider().(interface{ ForceFlush(context.Context) error }); ok {
+		return provider.ForceFlush(ctx)
+	}
+	return nil
+}
+
+// Tracer 返回全局 TracerProvider 中 Operator 使用的 Tracer，未调用 Setup 时 span 不会被记录
+func Tracer() trace.Tracer {
+	return otel.Tracer(InstrumentationName)
+}
+
+// Start 创建一个 span，ctx 中已有 span 时作为其子 span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End 结束 span，err 不为 nil 时记录错误并将 span 标记为失败
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// TraceID 返回 ctx 中 span 的 trace ID，ctx 中没有被记录的 span 时返回空字符串
+func TraceID(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// Logger 在 logger 中加入 ctx 中 span 的 trace ID 和 span ID，便于从日志跳转到对应的链路
+func Logger(ctx context.Context, logger logr.Logger) logr.Logger {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return logger
+	}
+	return logger.WithValues("traceID", sc.TraceID().String(), "spanID", sc.SpanID().String())
+}
+
+// Reconciler 返回为每次调谐创建根 span 的 Reconciler，ctx 中的 logger 同时带上 trace ID
+func Reconciler(controller string, r reconcile.Reconciler) reconcile.Reconciler {
+	return reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		ctx, span := Start(ctx, controller+".Reconcile",
+			attribute.String("controller", controller),
+			attribute.String("k8s.namespace.name", req.Namespace),
+			attribute.String("k8s.object.name", req.Name),
+		)
+		ctx = log.IntoContext(ctx, Logger(ctx, log.FromContext(ctx)))
+
+		result, err := r.Reconcile(ctx, req)
+		span.SetAttributes(
+			attribute.Bool("requeue", result.Requeue),
+			attribute.String("requeue_after", result.RequeueAfter.String()),
+		)
+		End(span, err)
+		return result, err
+	})
+}
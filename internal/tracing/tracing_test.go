@@ -0,0 +1,145 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// newTestProvider 将全局 TracerProvider 替换为同步导出到内存的 TracerProvider
+func newTestProvider(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+func TestReconcilerSpans(t *testing.T) {
+	exporter := newTestProvider(t)
+
+	dp := &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "web"}}
+	cl := WrapClient(fake.NewClientBuilder().WithScheme(clientgoscheme.Scheme).WithObjects(dp).Build())
+	recorder := record.NewFakeRecorder(1)
+	failure := errors.New("boom")
+
+	var traceID string
+	r := Reconciler("application", reconcile.Func(func(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+		traceID = TraceID(ctx)
+		if err := cl.Get(ctx, req.NamespacedName, &appsv1.Deployment{}); err != nil {
+			return ctrl.Result{}, err
+		}
+		// NotFound 不视为失败
+		_ = cl.Get(ctx, types.NamespacedName{Namespace: "default", Name: "missing"}, &appsv1.Deployment{})
+		Eventf(ctx, recorder, dp, "Normal", "DeploymentUpdated", "Updated Deployment %s", req.Name)
+		return ctrl.Result{}, failure
+	}))
+	if _, err := r.Reconcile(context.Background(), ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "default", Name: "web"}}); err != failure {
+		t.Fatalf("expected the error of the reconciler, got %v", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %d", len(spans))
+	}
+	get, missing, root := spans[0], spans[1], spans[2]
+	if root.Name != "application.Reconcile" || root.Status.Code != codes.Error || root.Parent.IsValid() {
+		t.Errorf("unexpected root span: %s %+v", root.Name, root.Status)
+	}
+	if get.Name != "Deployment get" || get.Parent.SpanID() != root.SpanContext.SpanID() || get.Status.Code == codes.Error {
+		t.Errorf("unexpected API call span: %s parent %s", get.Name, get.Parent.SpanID())
+	}
+	if !hasAttribute(get.Attributes, attribute.String("k8s.object.name", "web")) {
+		t.Errorf("expected the object name in the attributes, got %v", get.Attributes)
+	}
+	if missing.Status.Code == codes.Error {
+		t.Errorf("expected NotFound not to fail the span, got %+v", missing.Status)
+	}
+
+	if traceID == "" || traceID != root.SpanContext.TraceID().String() {
+		t.Errorf("expected the trace ID of the root span in the context, got %q", traceID)
+	}
+	if event := <-recorder.Events; !strings.Contains(event, TraceIDAnnotation+":"+traceID) {
+		t.Errorf("expected the trace ID in the annotations of the event, got %q", event)
+	}
+}
+
+func TestEventfWithoutSpan(t *testing.T) {
+	recorder := record.NewFakeRecorder(1)
+	Eventf(context.Background(), recorder, &appsv1.Deployment{}, "Normal", "DeploymentCreated", "Created")
+	if event := <-recorder.Events; event != "Normal DeploymentCreated Created" {
+		t.Errorf("expected an event without annotations, got %q", event)
+	}
+	// recorder 为 nil 时不记录
+	Eventf(context.Background(), nil, &appsv1.Deployment{}, "Normal", "DeploymentCreated", "Created")
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer server.Close()
+
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	_, span := provider.Tracer(InstrumentationName).Start(context.Background(), "reconcile")
+	span.End()
+
+	otlp, err := newOTLPExporter(server.URL + "/")
+	if err != nil {
+		t.Fatalf("create OTLP exporter: %v", err)
+	}
+	if err := otlp.ExportSpans(context.Background(), exporter.GetSpans().Snapshots()); err != nil {
+		t.Fatalf("export spans: %v", err)
+	}
+	if r := <-requests; r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+		t.Errorf("unexpected request %s with content type %q", r.URL.Path, r.Header.Get("Content-Type"))
+	}
+}
+
+func hasAttribute(attrs []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, kv := range attrs {
+		if kv == want {
+			return true
+		}
+	}
+	return false
+}
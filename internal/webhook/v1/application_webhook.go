@@ -0,0 +1,305 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	admissionv1 "k8s.io/api/admission/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/image"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+)
+
+// log is for logging in this package.
+var applicationlog = logf.Log.WithName("application-resource")
+
+//+kubebuilder:rbac:groups=apps.clusterops.io,resources=applicationpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps.clusterops.io,resources=clusterapplicationpolicies,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=endpoints,verbs=get
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get
+
+// ApplicationWebhookOptions configures the Application webhook.
+type ApplicationWebhookOptions struct {
+	// Defaults are filled into Applications that leave them unset, the built-in defaults are used when nil.
+	Defaults *ApplicationDefaults
+	// ImageResolver pins container images to digests, images are left as declared when nil.
+	ImageResolver image.Resolver
+	// AllowedRegistries restricts the registries container images may come from, any registry is allowed when empty.
+	AllowedRegistries []string
+	// AllowedEnvironmentNamespaces lists the namespaces, as path.Match patterns, environments may render into.
+	// When empty the requester must be allowed to create Deployments and Services in the namespaces instead.
+	AllowedEnvironmentNamespaces []string
+	// DeletionProtectionExemptUsers may delete protected Applications without confirming the deletion,
+	// in addition to the garbage collector and the namespace controller.
+	DeletionProtectionExemptUsers []string
+	// DefaultPolicy is evaluated for every Application in addition to the ApplicationPolicies and
+	// ClusterApplicationPolicies in scope. Its maxReplicas always applies, other policies may only tighten it.
+	// A policy limiting replicas to DefaultMaxReplicas is used when nil.
+	DefaultPolicy *appsv1.ApplicationPolicySpec
+}
+
+// ApplicationWebhookConfig holds the options of the Application webhook. The options can be
+// replaced while the webhook is serving, requests in flight keep the options they started with.
+type ApplicationWebhookConfig struct {
+	current atomic.Pointer[ApplicationWebhookOptions]
+}
+
+// NewApplicationWebhookConfig returns an ApplicationWebhookConfig holding opts.
+func NewApplicationWebhookConfig(opts ApplicationWebhookOptions) *ApplicationWebhookConfig {
+	c := &ApplicationWebhookConfig{}
+	c.Store(opts)
+	return c
+}
+
+// Load returns the current options.
+func (c *ApplicationWebhookConfig) Load() *ApplicationWebhookOptions {
+	return c.current.Load()
+}
+
+// Store replaces the current options, unset options fall back to the built-in defaults.
+func (c *ApplicationWebhookConfig) Store(opts ApplicationWebhookOptions) {
+	if opts.Defaults == nil {
+		opts.Defaults = NewApplicationDefaults()
+	}
+	if opts.DefaultPolicy == nil {
+		maxReplicas := int32(DefaultMaxReplicas)
+		opts.DefaultPolicy = &appsv1.ApplicationPolicySpec{MaxReplicas: &maxReplicas}
+	}
+	c.current.Store(&opts)
+}
+
+// SetupApplicationWebhookWithManager registers the webhook for Application in the manager.
+// The Application controller must be set up on the same manager, the validator looks up
+// conflicting Applications through the appsv1.ChildNamespacesIndexField index it registers.
+// config 为 nil 时使用内置的默认值
+func SetupApplicationWebhookWithManager(mgr ctrl.Manager, config *ApplicationWebhookConfig) error {
+	if config == nil {
+		config = NewApplicationWebhookConfig(ApplicationWebhookOptions{})
+	}
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(&appsv1.Application{}).
+		WithDefaulter(&ApplicationCustomDefaulter{Config: config}).
+		WithValidator(&ApplicationCustomValidator{
+			Client:     mgr.GetClient(),
+			APIReader:  mgr.GetAPIReader(),
+			Authorizer: mgr.GetClient(),
+			Config:     config,
+		}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-apps-clusterops-io-v1-application,mutating=true,failurePolicy=fail,sideEffects=None,groups=apps.clusterops.io,resources=applications,verbs=create;update,versions=v1,name=mapplication.kb.io,admissionReviewVersions=v1
+
+// ApplicationCustomDefaulter fills unset fields of Applications from their metadata and the configured defaults.
+type ApplicationCustomDefaulter struct {
+	Config *ApplicationWebhookConfig
+}
+
+var _ webhook.CustomDefaulter = &ApplicationCustomDefaulter{}
+
+// Default implements webhook.CustomDefaulter so a webhook will be registered for the type
+func (d *ApplicationCustomDefaulter) Default(ctx context.Context, obj runtime.Object) (err error) {
+	app, ok := obj.(*appsv1.Application)
+	if !ok {
+		return fmt.Errorf("expected an Application object but got %T", obj)
+	}
+	ctx, span := startAdmissionSpan(ctx, "Application.Default", app)
+	defer func() { tracing.End(span, err) }()
+	logger := tracing.Logger(ctx, applicationlog)
+	logger.Info("default", "name", app.Name)
+
+	// 删除中或 spec 与标签未变化的更新(例如添加、移除 Finalizer)不再填充默认值，
+	// 避免收紧后的配置或无法解析的镜像阻塞 Finalizer 的移除
+	if !app.DeletionTimestamp.IsZero() {
+		return nil
+	}
+	old, err := oldApplication(ctx)
+	if err != nil {
+		return err
+	}
+	if old != nil && !specChanged(old, app) {
+		return nil
+	}
+
+	opts := d.Config.Load()
+	applyDefaults(app, opts.Defaults)
+	applySecurityProfile(app)
+	if opts.ImageResolver != nil {
+		if err := pinImages(ctx, app, opts.ImageResolver); err != nil {
+			logger.Error(err, "Failed to pin images.", "name", app.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+//+kubebuilder:webhook:path=/validate-apps-clusterops-io-v1-application,mutating=false,failurePolicy=fail,sideEffects=None,groups=apps.clusterops.io,resources=applications,verbs=create;update;delete,versions=v1,name=vapplication.kb.io,admissionReviewVersions=v1
+
+// ApplicationCustomValidator validates Applications, including checks across objects
+// such as policies and other Applications.
+type ApplicationCustomValidator struct {
+	// Client 读取经过缓存的 Application 和策略
+	Client client.Reader
+	// APIReader 直接读取 apiserver，用于不希望缓存的资源，例如 Endpoints
+	APIReader client.Reader
+	// Authorizer 创建 SubjectAccessReview，确认请求者有权写入环境的目标命名空间，为 nil 时不检查
+	Authorizer client.Writer
+	Config     *ApplicationWebhookConfig
+}
+
+var _ webhook.CustomValidator = &ApplicationCustomValidator{}
+
+// ValidateCreate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ApplicationCustomValidator) ValidateCreate(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	app, ok := obj.(*appsv1.Application)
+	if !ok {
+		return nil, fmt.Errorf("expected an Application object but got %T", obj)
+	}
+	ctx, span := startAdmissionSpan(ctx, "Application.ValidateCreate", app)
+	tracing.Logger(ctx, applicationlog).Info("validate create", "name", app.Name)
+	warnings, err := v.validateApplication(ctx, nil, app)
+	tracing.End(span, err)
+	return warnings, err
+}
+
+// ValidateUpdate implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ApplicationCustomValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) (admission.Warnings, error) {
+	app, ok := newObj.(*appsv1.Application)
+	if !ok {
+		return nil, fmt.Errorf("expected an Application object for the newObj but got %T", newObj)
+	}
+	old, ok := oldObj.(*appsv1.Application)
+	if !ok {
+		return nil, fmt.Errorf("expected an Application object for the oldObj but got %T", oldObj)
+	}
+	ctx, span := startAdmissionSpan(ctx, "Application.ValidateUpdate", app)
+	tracing.Logger(ctx, applicationlog).Info("validate update", "name", app.Name)
+	warnings, err := v.validateApplication(ctx, old, app)
+	tracing.End(span, err)
+	return warnings, err
+}
+
+// ValidateDelete implements webhook.CustomValidator so a webhook will be registered for the type
+func (v *ApplicationCustomValidator) ValidateDelete(ctx context.Context, obj runtime.Object) (admission.Warnings, error) {
+	app, ok := obj.(*appsv1.Application)
+	if !ok {
+		return nil, fmt.Errorf("expected an Application object but got %T", obj)
+	}
+	ctx, span := startAdmissionSpan(ctx, "Application.ValidateDelete", app)
+	tracing.Logger(ctx, applicationlog).Info("validate delete", "name", app.Name)
+	warnings, err := v.validateDeletion(ctx, app)
+	tracing.End(span, err)
+	return warnings, err
+}
+
+// oldApplication 返回 UPDATE 请求中更新前的 Application，其他请求返回 nil
+func oldApplication(ctx context.Context) (*appsv1.Application, error) {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil || req.Operation != admissionv1.Update || len(req.OldObject.Raw) == 0 {
+		return nil, nil
+	}
+	old := &appsv1.Application{}
+	if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+		return nil, fmt.Errorf("decode the old Application: %w", err)
+	}
+	return old, nil
+}
+
+// specChanged 判断更新是否修改了 spec 或标签，标签决定了 selector 以及适用的策略
+func specChanged(old, app *appsv1.Application) bool {
+	return !apiequality.Semantic.DeepEqual(old.Spec, app.Spec) || !reflect.DeepEqual(old.Labels, app.Labels)
+}
+
+// startAdmissionSpan 为一次准入处理创建 span，CustomDefaulter 和 CustomValidator 无法读取请求头中的 trace context，span 作为根 span
+func startAdmissionSpan(ctx context.Context, name string, app *appsv1.Application) (context.Context, trace.Span) {
+	attrs := []attribute.KeyValue{
+		attribute.String("k8s.namespace.name", app.Namespace),
+		attribute.String("k8s.object.name", app.Name),
+	}
+	if req, err := admission.RequestFromContext(ctx); err == nil {
+		attrs = append(attrs,
+			attribute.String("admission.uid", string(req.UID)),
+			attribute.String("admission.operation", string(req.Operation)),
+			attribute.String("admission.user", req.UserInfo.Username),
+		)
+	}
+	return tracing.Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(attrs...))
+}
+
+// validateApplication 汇总 spec 校验、跨对象校验、策略评估以及更新校验(old 不为 nil 时)的所有错误，以 Invalid 错误返回以便客户端看到每个字段的路径
+// 更新时只有 spec 或标签变化才重新校验，删除中的 Application 不再校验，Finalizer 的添加和移除不受配置或策略变化的影响
+func (v *ApplicationCustomValidator) validateApplication(ctx context.Context, old, app *appsv1.Application) (admission.Warnings, error) {
+	if old != nil && (!app.DeletionTimestamp.IsZero() || !specChanged(old, app)) {
+		return nil, nil
+	}
+
+	warnings, allErrs := validateSpec(app)
+
+	if old != nil {
+		updateWarnings, updateErrs := validateSpecUpdate(old, app)
+		warnings = append(warnings, updateWarnings...)
+		allErrs = append(allErrs, updateErrs...)
+	}
+
+	allErrs = append(allErrs, validateRegistries(app, v.Config.Load().AllowedRegistries)...)
+	allErrs = append(allErrs, validateSecurityProfile(app)...)
+
+	namespaceErrs, err := v.validateEnvironmentNamespaces(ctx, old, app)
+	if err != nil {
+		tracing.Logger(ctx, applicationlog).Error(err, "Failed to review access to environment namespaces.", "name", app.Name)
+		return warnings, errors.NewInternalError(err)
+	}
+	allErrs = append(allErrs, namespaceErrs...)
+
+	conflictWarnings, conflictErrs, err := v.validateConflicts(ctx, old, app)
+	if err != nil {
+		tracing.Logger(ctx, applicationlog).Error(err, "Failed to list Applications.", "name", app.Name)
+		return warnings, errors.NewInternalError(err)
+	}
+	warnings = append(warnings, conflictWarnings...)
+	allErrs = append(allErrs, conflictErrs...)
+
+	policyWarnings, policyErrs, err := v.validatePolicies(ctx, app)
+	if err != nil {
+		tracing.Logger(ctx, applicationlog).Error(err, "Failed to list Application policies.", "name", app.Name)
+		return warnings, errors.NewInternalError(err)
+	}
+	warnings = append(warnings, policyWarnings...)
+	allErrs = append(allErrs, policyErrs...)
+
+	if len(allErrs) == 0 {
+		return warnings, nil
+	}
+
+	return warnings, errors.NewInvalid(appsv1.GroupVersion.WithKind("Application").GroupKind(), app.Name, allErrs)
+}
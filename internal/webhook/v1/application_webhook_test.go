@@ -0,0 +1,150 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/image"
+)
+
+func newTestValidator(t *testing.T, opts ApplicationWebhookOptions, objs ...runtime.Object) *ApplicationCustomValidator {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := appsv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	c := fake.NewClientBuilder().WithScheme(scheme).WithRuntimeObjects(objs...).
+		WithIndex(&appsv1.Application{}, appsv1.ChildNamespacesIndexField, func(obj client.Object) []string {
+			return obj.(*appsv1.Application).ChildNamespaces()
+		}).Build()
+	return &ApplicationCustomValidator{Client: c, APIReader: c, Config: NewApplicationWebhookConfig(opts)}
+}
+
+func TestValidateUpdateSkipsUnchangedSpec(t *testing.T) {
+	// 镜像来自收紧之后不再允许的仓库
+	v := newTestValidator(t, ApplicationWebhookOptions{AllowedRegistries: []string{"registry.example.com"}})
+	old := newImageApplication("nginx:1.25")
+	old.Namespace = "default"
+	old.Labels = map[string]string{"app": "demo"}
+
+	tests := []struct {
+		name    string
+		mutate  func(app *appsv1.Application)
+		wantErr bool
+	}{
+		{
+			name:   "adding a finalizer",
+			mutate: func(app *appsv1.Application) { app.Finalizers = append(app.Finalizers, appsv1.EnvironmentsFinalizer) },
+		},
+		{
+			name: "removing the finalizer of a terminating Application",
+			mutate: func(app *appsv1.Application) {
+				now := metav1.Now()
+				app.DeletionTimestamp = &now
+				app.Spec.Deployment.Template.Spec.Containers[0].Image = "nginx:1.26"
+			},
+		},
+		{
+			name:    "changing the spec",
+			mutate:  func(app *appsv1.Application) { app.Spec.Deployment.Template.Spec.Containers[0].Image = "nginx:1.26" },
+			wantErr: true,
+		},
+		{
+			name:    "changing the labels",
+			mutate:  func(app *appsv1.Application) { app.Labels["tier"] = "web" },
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := old.DeepCopy()
+			tt.mutate(app)
+			if _, err := v.ValidateUpdate(context.Background(), old, app); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateUpdate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDefaultSkipsUnchangedSpec(t *testing.T) {
+	// 解析器中没有该镜像的 digest，重新填充默认值会失败
+	d := &ApplicationCustomDefaulter{Config: NewApplicationWebhookConfig(ApplicationWebhookOptions{ImageResolver: image.StaticResolver{}})}
+	old := newImageApplication("nginx:1.25")
+	raw, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+		Operation: admissionv1.Update,
+		OldObject: runtime.RawExtension{Raw: raw},
+	}})
+
+	app := old.DeepCopy()
+	app.Finalizers = append(app.Finalizers, appsv1.EnvironmentsFinalizer)
+	if err := d.Default(ctx, app); err != nil {
+		t.Errorf("adding a finalizer must not default the Application: %v", err)
+	}
+
+	app.Spec.Deployment.Template.Spec.Containers[0].Image = "nginx:1.26"
+	if err := d.Default(ctx, app); err == nil {
+		t.Errorf("changing the spec must default the Application")
+	}
+}
+
+func TestDefaultUsesCurrentConfig(t *testing.T) {
+	config := NewApplicationWebhookConfig(ApplicationWebhookOptions{})
+	d := &ApplicationCustomDefaulter{Config: config}
+
+	app := newImageApplication("nginx:1.25")
+	if err := d.Default(context.Background(), app); err != nil {
+		t.Fatalf("default: %v", err)
+	}
+	if *app.Spec.Deployment.Replicas != 3 {
+		t.Errorf("replicas = %d, want the built-in default", *app.Spec.Deployment.Replicas)
+	}
+
+	// 替换后的配置只作用于之后的请求
+	defaults := NewApplicationDefaults()
+	defaults.Replicas = 1
+	config.Store(ApplicationWebhookOptions{Defaults: defaults})
+	app = newImageApplication("nginx:1.25")
+	if err := d.Default(context.Background(), app); err != nil {
+		t.Fatalf("default: %v", err)
+	}
+	if *app.Spec.Deployment.Replicas != 1 {
+		t.Errorf("replicas = %d, want the replaced default", *app.Spec.Deployment.Replicas)
+	}
+
+	if err := d.Default(context.Background(), &appsv1.ApplicationPolicy{}); err == nil {
+		t.Errorf("objects other than Applications must be rejected")
+	}
+}
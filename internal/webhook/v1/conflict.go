@@ -0,0 +1,109 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// childNamespacePath 返回决定子资源所在命名空间的字段路径
+func childNamespacePath(app *appsv1.Application, index int) *field.Path {
+	if len(app.Spec.Environments) == 0 {
+		return field.NewPath("metadata", "name")
+	}
+	return field.NewPath("spec", "environments").Index(index).Child("namespace")
+}
+
+// listApplicationsSharingNamespaces 通过 Application 控制器注册的索引，列出子资源与 app 渲染到同一命名空间的 Application
+// 冲突只可能发生在共享的命名空间中，避免每次请求都列出集群中所有的 Application
+func (v *ApplicationCustomValidator) listApplicationsSharingNamespaces(ctx context.Context, app *appsv1.Application) ([]*appsv1.Application, error) {
+	seen := map[types.NamespacedName]bool{}
+	var apps []*appsv1.Application
+	for _, namespace := range app.ChildNamespaces() {
+		list := &appsv1.ApplicationList{}
+		if err := v.Client.List(ctx, list, client.MatchingFields{appsv1.ChildNamespacesIndexField: namespace}); err != nil {
+			return nil, err
+		}
+		for i := range list.Items {
+			key := client.ObjectKeyFromObject(&list.Items[i])
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			apps = append(apps, &list.Items[i])
+		}
+	}
+	return apps, nil
+}
+
+// validateConflicts 拒绝与其他 Application 渲染出同名 Service(以及同名 Deployment)，或 selector 与其他 Application 的 Pod 重叠的 Application
+// 子资源与 Application 同名，位于同一命名空间的两个子资源会互相覆盖；Service 的 selector 取自 metadata.labels，重叠时流量会互相转发
+// 更新时只拒绝新产生的重叠，已经存在的重叠以警告提示，由控制器通过 SelectorConflict Condition 暴露
+func (v *ApplicationCustomValidator) validateConflicts(ctx context.Context, old, app *appsv1.Application) (admission.Warnings, field.ErrorList, error) {
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	if v.Client == nil {
+		return warnings, allErrs, nil
+	}
+
+	apps, err := v.listApplicationsSharingNamespaces(ctx, app)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, other := range apps {
+		if !other.DeletionTimestamp.IsZero() || !app.SelectorOverlaps(other) {
+			continue
+		}
+		message := fmt.Sprintf("selectors overlap with the pods of Application %s/%s in namespaces %s",
+			other.Namespace, other.Name, strings.Join(app.SharedNamespaces(other), ", "))
+		if old != nil && old.SelectorOverlaps(other) {
+			warnings = append(warnings, message)
+			continue
+		}
+		allErrs = append(allErrs, field.Invalid(field.NewPath("metadata", "labels"), app.Labels, message))
+	}
+
+	for i, namespace := range app.ChildNamespaces() {
+		for _, other := range apps {
+			if other.Namespace == app.Namespace && other.Name == app.Name {
+				continue
+			}
+			if other.Name != app.Name || !other.DeletionTimestamp.IsZero() {
+				continue
+			}
+			for _, otherNamespace := range other.ChildNamespaces() {
+				if otherNamespace != namespace {
+					continue
+				}
+				allErrs = append(allErrs, field.Duplicate(childNamespacePath(app, i),
+					fmt.Sprintf("Service %s/%s is already rendered by Application %s/%s",
+						namespace, app.Name, other.Namespace, other.Name)))
+			}
+		}
+	}
+	return warnings, allErrs, nil
+}
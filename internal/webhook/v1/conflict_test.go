@@ -0,0 +1,99 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func TestValidateConflicts(t *testing.T) {
+	newApplication := func(namespace, name string, labels map[string]string, environments ...string) *appsv1.Application {
+		app := &appsv1.Application{}
+		app.Name, app.Namespace = name, namespace
+		app.Labels = labels
+		for _, env := range environments {
+			app.Spec.Environments = append(app.Spec.Environments, appsv1.ApplicationEnvironment{Name: env, Namespace: env})
+		}
+		return app
+	}
+	web := map[string]string{"app": "web"}
+	api := map[string]string{"app": "api"}
+
+	tests := []struct {
+		name         string
+		existing     *appsv1.Application
+		old          *appsv1.Application
+		app          *appsv1.Application
+		wantErrs     []string
+		wantWarnings int
+	}{
+		{
+			name:     "no overlap",
+			existing: newApplication("default", "api", api),
+			app:      newApplication("default", "web", web),
+		},
+		{
+			name:     "overlapping selectors",
+			existing: newApplication("default", "web-canary", web),
+			app:      newApplication("default", "web", web),
+			wantErrs: []string{"metadata.labels"},
+		},
+		{
+			name:         "an existing overlap is only a warning",
+			existing:     newApplication("default", "web-canary", web),
+			old:          newApplication("default", "web", web),
+			app:          newApplication("default", "web", web),
+			wantWarnings: 1,
+		},
+		{
+			name:     "the same name rendered into a shared environment",
+			existing: newApplication("team-b", "web", api, "team-a-prod"),
+			app:      newApplication("team-a", "web", web, "team-a-dev", "team-a-prod"),
+			wantErrs: []string{"spec.environments[1].namespace"},
+		},
+		{
+			name:     "the same name rendered into the namespace of the other Application",
+			existing: newApplication("team-a", "web", api),
+			app:      newApplication("team-b", "web", web, "team-a"),
+			wantErrs: []string{"spec.environments[0].namespace"},
+		},
+		{
+			name:     "the same name in another namespace",
+			existing: newApplication("team-b", "web", web),
+			app:      newApplication("team-a", "web", web),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestValidator(t, ApplicationWebhookOptions{}, tt.existing)
+			warnings, errs, err := v.validateConflicts(context.Background(), tt.old, tt.app)
+			if err != nil {
+				t.Fatalf("validate conflicts: %v", err)
+			}
+			if got := fieldPaths(errs); !reflect.DeepEqual(got, tt.wantErrs) {
+				t.Errorf("validateConflicts() errors = %v, want %v", got, tt.wantErrs)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("validateConflicts() warnings = %v, want %d warnings", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	kappsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/yaml"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+const (
+	// 推荐标签，参考 https://kubernetes.io/docs/concepts/overview/working-with-objects/common-labels/
+	NameLabel      = "app.kubernetes.io/name"
+	InstanceLabel  = "app.kubernetes.io/instance"
+	ManagedByLabel = "app.kubernetes.io/managed-by"
+
+	// ManagedByValue 是由本 Operator 管理的资源的 app.kubernetes.io/managed-by 标签值
+	ManagedByValue = "clusterops-operator"
+)
+
+// ApplicationDefaults holds the values the mutating webhook fills into Applications
+// that leave them unset.
+type ApplicationDefaults struct {
+	// Replicas is the default number of replicas.
+	Replicas int32 `json:"replicas"`
+	// RevisionHistoryLimit is the default number of old ReplicaSets to retain.
+	// +optional
+	RevisionHistoryLimit *int32 `json:"revisionHistoryLimit,omitempty"`
+	// Strategy is the default Deployment strategy.
+	// +optional
+	Strategy *kappsv1.DeploymentStrategy `json:"strategy,omitempty"`
+	// Requests are the default resource requests of containers that do not declare them.
+	// +optional
+	Requests corev1.ResourceList `json:"requests,omitempty"`
+}
+
+// NewApplicationDefaults 返回内置的默认值
+func NewApplicationDefaults() *ApplicationDefaults {
+	revisionHistoryLimit := int32(10)
+	maxUnavailable := intstr.FromInt(0)
+	maxSurge := intstr.FromString("25%")
+	return &ApplicationDefaults{
+		Replicas:             3,
+		RevisionHistoryLimit: &revisionHistoryLimit,
+		Strategy: &kappsv1.DeploymentStrategy{
+			Type: kappsv1.RollingUpdateDeploymentStrategyType,
+			RollingUpdate: &kappsv1.RollingUpdateDeployment{
+				MaxUnavailable: &maxUnavailable,
+				MaxSurge:       &maxSurge,
+			},
+		},
+		Requests: corev1.ResourceList{
+			corev1.ResourceCPU:    resource.MustParse("100m"),
+			corev1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+	}
+}
+
+// LoadApplicationDefaults 解析 YAML 格式的默认值，未声明的字段沿用内置的默认值
+func LoadApplicationDefaults(data []byte) (*ApplicationDefaults, error) {
+	defaults := NewApplicationDefaults()
+	if err := yaml.UnmarshalStrict(data, defaults); err != nil {
+		return nil, fmt.Errorf("parse application defaults: %w", err)
+	}
+	if defaults.Replicas < 0 {
+		return nil, fmt.Errorf("parse application defaults: replicas must be greater than or equal to 0")
+	}
+	return defaults, nil
+}
+
+// applyDefaults 按 metadata 和 defaults 补全 app 中未设置的字段
+func applyDefaults(app *appsv1.Application, defaults *ApplicationDefaults) {
+	// metadata.labels 同时作为 Service 的 selector，为空时生成 app.kubernetes.io/name
+	name := defaultName(app)
+	if len(app.Labels) == 0 && name != "" {
+		app.Labels = map[string]string{NameLabel: name}
+	}
+
+	deployment := &app.Spec.Deployment
+	if deployment.Replicas == nil {
+		replicas := defaults.Replicas
+		deployment.Replicas = &replicas
+	}
+	if deployment.Selector == nil && len(app.Labels) > 0 {
+		deployment.Selector = &metav1.LabelSelector{MatchLabels: copyLabels(app.Labels)}
+	}
+
+	// Pod 模板标签需要能被 selector 选中，同时注入推荐标签
+	if deployment.Template.Labels == nil {
+		deployment.Template.Labels = map[string]string{}
+	}
+	if deployment.Selector != nil {
+		for key, value := range deployment.Selector.MatchLabels {
+			if _, ok := deployment.Template.Labels[key]; !ok {
+				deployment.Template.Labels[key] = value
+			}
+		}
+	}
+	// 实例标签需要区分每个 Application，名称尚未生成时不设置
+	recommended := map[string]string{ManagedByLabel: ManagedByValue}
+	if name != "" {
+		recommended[NameLabel] = name
+	}
+	if app.Name != "" {
+		recommended[InstanceLabel] = app.Name
+	}
+	for key, value := range recommended {
+		if _, ok := deployment.Template.Labels[key]; !ok {
+			deployment.Template.Labels[key] = value
+		}
+	}
+
+	// Service 的 selector 由控制器按 metadata.labels 渲染，不在 spec 中填充，避免标签变化后与实际的 selector 不一致
+	service := &app.Spec.Service
+	if len(service.Ports) == 0 {
+		service.Ports = defaultServicePorts(deployment.Template.Spec.Containers)
+	}
+
+	// 引用模板时策略、历史版本数和资源请求可能由模板提供，此时不再填充默认值以免覆盖模板
+	if app.Spec.TemplateRef != nil {
+		return
+	}
+	if deployment.RevisionHistoryLimit == nil && defaults.RevisionHistoryLimit != nil {
+		revisionHistoryLimit := *defaults.RevisionHistoryLimit
+		deployment.RevisionHistoryLimit = &revisionHistoryLimit
+	}
+	if deployment.Strategy.Type == "" && defaults.Strategy != nil {
+		deployment.Strategy = *defaults.Strategy.DeepCopy()
+	}
+	for i := range deployment.Template.Spec.Containers {
+		defaultRequests(&deployment.Template.Spec.Containers[i].Resources, defaults.Requests)
+	}
+}
+
+// defaultName 返回用于生成默认标签的名称
+// 通过 generateName 创建时，名称在 admission 之后才生成，此时改用去掉末尾分隔符的 generateName，都为空时返回空字符串
+func defaultName(app *appsv1.Application) string {
+	if app.Name != "" {
+		return app.Name
+	}
+	return strings.TrimRight(app.GenerateName, "-.")
+}
+
+// defaultServicePorts 为容器声明的每个端口生成一个同端口号的 Service 端口
+func defaultServicePorts(containers []corev1.Container) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	for _, container := range containers {
+		for _, port := range container.Ports {
+			servicePort := corev1.ServicePort{
+				Name:       port.Name,
+				Protocol:   port.Protocol,
+				Port:       port.ContainerPort,
+				TargetPort: intstr.FromInt(int(port.ContainerPort)),
+			}
+			if port.Name != "" {
+				servicePort.TargetPort = intstr.FromString(port.Name)
+			}
+			ports = append(ports, servicePort)
+		}
+	}
+	// 多个端口时 Service 要求每个端口都有名称
+	if len(ports) > 1 {
+		for i := range ports {
+			if ports[i].Name == "" {
+				ports[i].Name = fmt.Sprintf("port-%d", ports[i].Port)
+			}
+		}
+	}
+	return ports
+}
+
+// defaultRequests 为未声明资源请求的容器补全默认请求
+// 已声明上限的资源由 Kubernetes 以上限作为请求，这里不再补全
+func defaultRequests(resources *corev1.ResourceRequirements, requests corev1.ResourceList) {
+	for name, request := range requests {
+		if _, ok := resources.Requests[name]; ok {
+			continue
+		}
+		if _, ok := resources.Limits[name]; ok {
+			continue
+		}
+		if resources.Requests == nil {
+			resources.Requests = corev1.ResourceList{}
+		}
+		resources.Requests[name] = request.DeepCopy()
+	}
+}
+
+func copyLabels(labels map[string]string) map[string]string {
+	copied := make(map[string]string, len(labels))
+	for key, value := range labels {
+		copied[key] = value
+	}
+	return copied
+}
@@ -0,0 +1,256 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	kappsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func TestApplyDefaults(t *testing.T) {
+	one := int32(1)
+	tests := []struct {
+		name   string
+		mutate func(app *appsv1.Application)
+		check  func(t *testing.T, app *appsv1.Application)
+	}{
+		{
+			name: "labels and selectors derived from the name",
+			check: func(t *testing.T, app *appsv1.Application) {
+				want := map[string]string{NameLabel: "demo"}
+				if !reflect.DeepEqual(app.Labels, want) || !reflect.DeepEqual(app.Spec.Deployment.Selector.MatchLabels, want) {
+					t.Errorf("labels = %v, selector = %v, want %v", app.Labels, app.Spec.Deployment.Selector.MatchLabels, want)
+				}
+				// Service 的 selector 由控制器按 metadata.labels 渲染
+				if app.Spec.Service.Selector != nil {
+					t.Errorf("service selector = %v, want it left unset", app.Spec.Service.Selector)
+				}
+			},
+		},
+		{
+			name: "declared labels are used as selectors",
+			mutate: func(app *appsv1.Application) {
+				app.Labels = map[string]string{"app": "demo"}
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				if app.Spec.Deployment.Selector.MatchLabels["app"] != "demo" {
+					t.Errorf("selector = %v, want app=demo", app.Spec.Deployment.Selector.MatchLabels)
+				}
+				// selector 是 labels 的副本
+				app.Spec.Deployment.Selector.MatchLabels["tier"] = "web"
+				if _, ok := app.Labels["tier"]; ok {
+					t.Errorf("the selector shares its map with the labels")
+				}
+			},
+		},
+		{
+			name: "a declared selector is kept and copied into the pod template labels",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}}
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				if !reflect.DeepEqual(app.Spec.Deployment.Selector.MatchLabels, map[string]string{"app": "web"}) ||
+					app.Spec.Deployment.Template.Labels["app"] != "web" {
+					t.Errorf("selector = %v, template labels = %v, want app=web in both",
+						app.Spec.Deployment.Selector.MatchLabels, app.Spec.Deployment.Template.Labels)
+				}
+			},
+		},
+		{
+			name: "pod template labels keep declared values",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Template.Labels = map[string]string{NameLabel: "web"}
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				want := map[string]string{NameLabel: "web", InstanceLabel: "demo", ManagedByLabel: ManagedByValue}
+				if !reflect.DeepEqual(app.Spec.Deployment.Template.Labels, want) {
+					t.Errorf("template labels = %v, want %v", app.Spec.Deployment.Template.Labels, want)
+				}
+			},
+		},
+		{
+			name: "labels derived from generateName",
+			mutate: func(app *appsv1.Application) {
+				app.Name, app.GenerateName = "", "demo-"
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				want := map[string]string{NameLabel: "demo"}
+				if !reflect.DeepEqual(app.Labels, want) || !reflect.DeepEqual(app.Spec.Deployment.Selector.MatchLabels, want) {
+					t.Errorf("labels = %v, selector = %v, want %v", app.Labels, app.Spec.Deployment.Selector.MatchLabels, want)
+				}
+				if _, ok := app.Spec.Deployment.Template.Labels[InstanceLabel]; ok {
+					t.Errorf("template labels = %v, want no instance label before the name is generated", app.Spec.Deployment.Template.Labels)
+				}
+			},
+		},
+		{
+			name: "no labels without a name",
+			mutate: func(app *appsv1.Application) {
+				app.Name = ""
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				want := map[string]string{ManagedByLabel: ManagedByValue}
+				if app.Labels != nil || app.Spec.Deployment.Selector != nil || !reflect.DeepEqual(app.Spec.Deployment.Template.Labels, want) {
+					t.Errorf("labels = %v, selector = %v, template labels = %v, want only the managed-by label",
+						app.Labels, app.Spec.Deployment.Selector, app.Spec.Deployment.Template.Labels)
+				}
+			},
+		},
+		{
+			name: "workload defaults",
+			check: func(t *testing.T, app *appsv1.Application) {
+				deployment := app.Spec.Deployment
+				if *deployment.Replicas != 3 || *deployment.RevisionHistoryLimit != 10 ||
+					deployment.Strategy.Type != kappsv1.RollingUpdateDeploymentStrategyType {
+					t.Errorf("replicas = %d, revisionHistoryLimit = %d, strategy = %s, want the built-in defaults",
+						*deployment.Replicas, *deployment.RevisionHistoryLimit, deployment.Strategy.Type)
+				}
+			},
+		},
+		{
+			name: "declared workload fields are kept",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Replicas = &one
+				app.Spec.Deployment.RevisionHistoryLimit = &one
+				app.Spec.Deployment.Strategy.Type = kappsv1.RecreateDeploymentStrategyType
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				deployment := app.Spec.Deployment
+				if *deployment.Replicas != 1 || *deployment.RevisionHistoryLimit != 1 ||
+					deployment.Strategy.Type != kappsv1.RecreateDeploymentStrategyType || deployment.Strategy.RollingUpdate != nil {
+					t.Errorf("deployment = %+v, want the declared fields kept", deployment)
+				}
+			},
+		},
+		{
+			name: "requests are filled only for undeclared resources",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+					Limits: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("1Gi")},
+				}
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				requests := app.Spec.Deployment.Template.Spec.Containers[0].Resources.Requests
+				if requests.Cpu().String() != "100m" || requests.Memory().String() != "0" {
+					t.Errorf("requests = %v, want only the cpu request defaulted", requests)
+				}
+			},
+		},
+		{
+			name: "templates keep their workload fields",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.TemplateRef = &appsv1.TemplateReference{Name: "web"}
+			},
+			check: func(t *testing.T, app *appsv1.Application) {
+				deployment := app.Spec.Deployment
+				if deployment.RevisionHistoryLimit != nil || deployment.Strategy.Type != "" ||
+					deployment.Template.Spec.Containers[0].Resources.Requests != nil {
+					t.Errorf("deployment = %+v, want the template fields left unset", deployment)
+				}
+				if *deployment.Replicas != 3 {
+					t.Errorf("replicas = %d, want the default", *deployment.Replicas)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &appsv1.Application{}
+			app.Name, app.Namespace = "demo", "default"
+			app.Spec.Deployment.Template.Spec.Containers = []corev1.Container{{Name: "web", Image: "nginx:1.25"}}
+			if tt.mutate != nil {
+				tt.mutate(app)
+			}
+			applyDefaults(app, NewApplicationDefaults())
+			tt.check(t, app)
+		})
+	}
+}
+
+func TestDefaultServicePorts(t *testing.T) {
+	tests := []struct {
+		name  string
+		ports []corev1.ContainerPort
+		want  []corev1.ServicePort
+	}{
+		{
+			name: "no ports",
+		},
+		{
+			name:  "a single unnamed port",
+			ports: []corev1.ContainerPort{{ContainerPort: 8080}},
+			want:  []corev1.ServicePort{{Port: 8080, TargetPort: intstr.FromInt(8080)}},
+		},
+		{
+			name:  "named ports target the port name",
+			ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080, Protocol: corev1.ProtocolTCP}},
+			want:  []corev1.ServicePort{{Name: "http", Port: 8080, Protocol: corev1.ProtocolTCP, TargetPort: intstr.FromString("http")}},
+		},
+		{
+			name:  "multiple ports are all named",
+			ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}, {ContainerPort: 9090}},
+			want: []corev1.ServicePort{
+				{Name: "http", Port: 8080, TargetPort: intstr.FromString("http")},
+				{Name: "port-9090", Port: 9090, TargetPort: intstr.FromInt(9090)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := defaultServicePorts([]corev1.Container{{Name: "web", Ports: tt.ports}})
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("defaultServicePorts() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadApplicationDefaults(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		wantReplicas int32
+		wantErr      bool
+	}{
+		{name: "empty", wantReplicas: 3},
+		{name: "overridden replicas", data: "replicas: 2", wantReplicas: 2},
+		{name: "negative replicas", data: "replicas: -1", wantErr: true},
+		{name: "unknown fields", data: "replica: 2", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults, err := LoadApplicationDefaults([]byte(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadApplicationDefaults() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if defaults.Replicas != tt.wantReplicas || defaults.Requests.Cpu().String() != "100m" {
+				t.Errorf("defaults = %+v, want replicas %d and the built-in requests", defaults, tt.wantReplicas)
+			}
+		})
+	}
+}
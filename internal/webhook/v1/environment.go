@@ -0,0 +1,125 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+//+kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// environmentResources 是环境渲染到目标命名空间中的子资源，请求者需要有权创建它们
+var environmentResources = []authorizationv1.ResourceAttributes{
+	{Group: "apps", Resource: "deployments", Verb: "create"},
+	{Group: "", Resource: "services", Verb: "create"},
+}
+
+// validateEnvironmentNamespaces 校验新增的环境命名空间：配置了 allowedEnvironmentNamespaces 时必须匹配其中之一，
+// 否则请求者本身必须有权在目标命名空间中创建 Deployment 和 Service，避免借助 Operator 的权限写入其他命名空间
+// 已有的环境命名空间不再检查，Operator 自身更新 Finalizer 等字段时不受影响
+func (v *ApplicationCustomValidator) validateEnvironmentNamespaces(ctx context.Context, old, app *appsv1.Application) (field.ErrorList, error) {
+	var allErrs field.ErrorList
+	fldPath := field.NewPath("spec", "environments")
+
+	existing := sets.New[string]()
+	if old != nil {
+		for _, env := range old.Spec.Environments {
+			existing.Insert(env.Namespace)
+		}
+	}
+	allowed := v.Config.Load().AllowedEnvironmentNamespaces
+
+	for i, env := range app.Spec.Environments {
+		if env.Namespace == "" || env.Namespace == app.Namespace || existing.Has(env.Namespace) {
+			continue
+		}
+		idxPath := fldPath.Index(i).Child("namespace")
+
+		if len(allowed) > 0 {
+			if !namespaceAllowed(env.Namespace, allowed) {
+				allErrs = append(allErrs, field.Forbidden(idxPath,
+					fmt.Sprintf("namespace %s is not in the allowed environment namespaces %v", env.Namespace, allowed)))
+			}
+			continue
+		}
+
+		if v.Authorizer == nil {
+			continue
+		}
+		denied, err := v.deniedEnvironmentResource(ctx, env.Namespace)
+		if err != nil {
+			return nil, err
+		}
+		if denied != "" {
+			allErrs = append(allErrs, field.Forbidden(idxPath,
+				fmt.Sprintf("the requester may not create %s in namespace %s", denied, env.Namespace)))
+		}
+	}
+	return allErrs, nil
+}
+
+// deniedEnvironmentResource 通过 SubjectAccessReview 检查请求者能否在 namespace 中创建环境子资源，返回第一个无权创建的资源
+func (v *ApplicationCustomValidator) deniedEnvironmentResource(ctx context.Context, namespace string) (string, error) {
+	req, err := admission.RequestFromContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	extra := make(map[string]authorizationv1.ExtraValue, len(req.UserInfo.Extra))
+	for k, v := range req.UserInfo.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+
+	for _, attrs := range environmentResources {
+		attrs := attrs
+		attrs.Namespace = namespace
+		review := &authorizationv1.SubjectAccessReview{
+			Spec: authorizationv1.SubjectAccessReviewSpec{
+				ResourceAttributes: &attrs,
+				User:               req.UserInfo.Username,
+				Groups:             req.UserInfo.Groups,
+				UID:                req.UserInfo.UID,
+				Extra:              extra,
+			},
+		}
+		if err := v.Authorizer.Create(ctx, review); err != nil {
+			return "", fmt.Errorf("create SubjectAccessReview: %w", err)
+		}
+		if !review.Status.Allowed {
+			return attrs.Resource, nil
+		}
+	}
+	return "", nil
+}
+
+// namespaceAllowed 判断 namespace 是否匹配 patterns 中的任意一个，pattern 的语法与 path.Match 相同
+func namespaceAllowed(namespace string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, namespace); ok {
+			return true
+		}
+	}
+	return false
+}
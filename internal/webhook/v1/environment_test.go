@@ -0,0 +1,106 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// namespaceAuthorizer 允许请求者写入 writable 中的命名空间
+type namespaceAuthorizer struct {
+	client.Writer
+	writable map[string]bool
+}
+
+func (a *namespaceAuthorizer) Create(_ context.Context, obj client.Object, _ ...client.CreateOption) error {
+	review := obj.(*authorizationv1.SubjectAccessReview)
+	review.Status.Allowed = review.Spec.User == "alice" && a.writable[review.Spec.ResourceAttributes.Namespace]
+	return nil
+}
+
+func newEnvironmentApplication(namespaces ...string) *appsv1.Application {
+	app := &appsv1.Application{}
+	app.Name = "demo"
+	app.Namespace = "team-a"
+	for _, namespace := range namespaces {
+		app.Spec.Environments = append(app.Spec.Environments, appsv1.ApplicationEnvironment{Name: namespace, Namespace: namespace})
+	}
+	return app
+}
+
+func TestValidateEnvironmentNamespaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		allowed []string
+		old     *appsv1.Application
+		app     *appsv1.Application
+		errs    int
+	}{
+		{
+			name: "the namespace of the Application is always allowed",
+			app:  newEnvironmentApplication("team-a"),
+		},
+		{
+			name: "namespaces the requester can write are allowed",
+			app:  newEnvironmentApplication("team-a-dev"),
+		},
+		{
+			name: "namespaces the requester cannot write are rejected",
+			app:  newEnvironmentApplication("team-a-dev", "kube-system"),
+			errs: 1,
+		},
+		{
+			name: "existing environments are not reviewed again",
+			old:  newEnvironmentApplication("kube-system"),
+			app:  newEnvironmentApplication("kube-system"),
+		},
+		{
+			name:    "the allowlist replaces the access review",
+			allowed: []string{"team-a-*"},
+			app:     newEnvironmentApplication("team-a-prod", "team-b"),
+			errs:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &ApplicationCustomValidator{
+				Authorizer: &namespaceAuthorizer{writable: map[string]bool{"team-a-dev": true}},
+				Config:     NewApplicationWebhookConfig(ApplicationWebhookOptions{AllowedEnvironmentNamespaces: tt.allowed}),
+			}
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				UserInfo: authenticationv1.UserInfo{Username: "alice"},
+			}})
+
+			errs, err := v.validateEnvironmentNamespaces(ctx, tt.old, tt.app)
+			if err != nil {
+				t.Fatalf("validate environment namespaces: %v", err)
+			}
+			if len(errs) != tt.errs {
+				t.Errorf("got %d errors, want %d: %v", len(errs), tt.errs, errs)
+			}
+		})
+	}
+}
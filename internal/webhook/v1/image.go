@@ -0,0 +1,102 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/image"
+)
+
+// pinImages 将 Deployment 模板中各容器的镜像解析并固定到 digest，固定前声明的镜像记录在 OriginalImagesAnnotation 注解中
+// 已经固定的镜像保持不变；环境的 imageTag 会覆盖 tag 并丢弃 digest，不在固定范围内
+func pinImages(ctx context.Context, app *appsv1.Application, resolver image.Resolver) error {
+	previous := app.OriginalImages()
+	originals := map[string]string{}
+
+	podSpec := &app.Spec.Deployment.Template.Spec
+	for _, containers := range [][]corev1.Container{podSpec.InitContainers, podSpec.Containers} {
+		for i := range containers {
+			container := &containers[i]
+			if container.Image == "" {
+				continue
+			}
+			// 已固定的镜像沿用之前记录的声明，镜像名称变化说明用户直接指定了 digest，不再保留记录
+			if image.IsPinned(container.Image) {
+				if original, ok := previous[container.Name]; ok && image.Name(original) == image.Name(container.Image) {
+					originals[container.Name] = original
+				}
+				continue
+			}
+
+			digest, err := resolver.Resolve(ctx, container.Image)
+			if err != nil {
+				return fmt.Errorf("failed to resolve image %s of container %s: %w", container.Image, container.Name, err)
+			}
+			if !image.ValidDigest(digest) {
+				return fmt.Errorf("invalid digest %q resolved for image %s of container %s", digest, container.Image, container.Name)
+			}
+			originals[container.Name] = container.Image
+			container.Image = image.Pin(container.Image, digest)
+		}
+	}
+
+	if len(originals) == 0 {
+		delete(app.Annotations, appsv1.OriginalImagesAnnotation)
+		return nil
+	}
+	data, err := json.Marshal(originals)
+	if err != nil {
+		return err
+	}
+	if app.Annotations == nil {
+		app.Annotations = map[string]string{}
+	}
+	app.Annotations[appsv1.OriginalImagesAnnotation] = string(data)
+	return nil
+}
+
+// validateRegistries 拒绝来自允许列表之外仓库的镜像，允许列表为空时不做限制
+func validateRegistries(app *appsv1.Application, allowed []string) field.ErrorList {
+	var allErrs field.ErrorList
+	if len(allowed) == 0 {
+		return allErrs
+	}
+
+	podSpecPath := field.NewPath("spec", "deployment", "template", "spec")
+	podSpec := &app.Spec.Deployment.Template.Spec
+	for _, group := range []struct {
+		path       *field.Path
+		containers []corev1.Container
+	}{
+		{podSpecPath.Child("initContainers"), podSpec.InitContainers},
+		{podSpecPath.Child("containers"), podSpec.Containers},
+	} {
+		for i, container := range group.containers {
+			if container.Image != "" && !image.Allowed(container.Image, allowed) {
+				allErrs = append(allErrs, field.NotSupported(group.path.Index(i).Child("image"), container.Image, allowed))
+			}
+		}
+	}
+	return allErrs
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/image"
+)
+
+const (
+	nginxDigest = "sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	envoyDigest = "sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"
+)
+
+func newImageApplication(images ...string) *appsv1.Application {
+	app := &appsv1.Application{}
+	app.Name = "demo"
+	for i, img := range images {
+		app.Spec.Deployment.Template.Spec.Containers = append(app.Spec.Deployment.Template.Spec.Containers,
+			corev1.Container{Name: []string{"web", "proxy"}[i], Image: img})
+	}
+	return app
+}
+
+func TestPinImages(t *testing.T) {
+	resolver := image.StaticResolver{
+		"docker.io/library/nginx:1.25":      nginxDigest,
+		"docker.io/envoyproxy/envoy:latest": envoyDigest,
+	}
+	app := newImageApplication("nginx:1.25", "envoyproxy/envoy")
+
+	if err := pinImages(context.Background(), app, resolver); err != nil {
+		t.Fatalf("pin images: %v", err)
+	}
+
+	containers := app.Spec.Deployment.Template.Spec.Containers
+	if got, want := containers[0].Image, "nginx@"+nginxDigest; got != want {
+		t.Errorf("image of web = %s, want %s", got, want)
+	}
+	if got, want := containers[1].Image, "envoyproxy/envoy@"+envoyDigest; got != want {
+		t.Errorf("image of proxy = %s, want %s", got, want)
+	}
+	originals := app.OriginalImages()
+	if originals["web"] != "nginx:1.25" || originals["proxy"] != "envoyproxy/envoy" {
+		t.Errorf("unexpected original images: %v", originals)
+	}
+
+	// 再次提交已固定的镜像时保留之前记录的声明
+	if err := pinImages(context.Background(), app, image.StaticResolver{}); err != nil {
+		t.Fatalf("pin pinned images: %v", err)
+	}
+	if got := app.OriginalImages()["web"]; got != "nginx:1.25" {
+		t.Errorf("original image of web = %s, want nginx:1.25", got)
+	}
+
+	// 用户直接指定其他镜像的 digest 时丢弃之前的记录
+	containers[0].Image = "docker.io/library/httpd@" + nginxDigest
+	if err := pinImages(context.Background(), app, image.StaticResolver{}); err != nil {
+		t.Fatalf("pin pinned images: %v", err)
+	}
+	if _, ok := app.OriginalImages()["web"]; ok {
+		t.Errorf("original image of web should be dropped: %v", app.OriginalImages())
+	}
+}
+
+func TestPinImagesUnresolvable(t *testing.T) {
+	app := newImageApplication("nginx:1.25")
+
+	err := pinImages(context.Background(), app, image.StaticResolver{})
+	if !errors.Is(err, image.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, ok := app.Annotations[appsv1.OriginalImagesAnnotation]; ok {
+		t.Errorf("annotation %s must not be set when pinning fails", appsv1.OriginalImagesAnnotation)
+	}
+}
+
+func TestValidateRegistries(t *testing.T) {
+	app := newImageApplication("nginx:1.25", "registry.example.com/team/proxy:v1")
+
+	if errs := validateRegistries(app, nil); len(errs) != 0 {
+		t.Errorf("expected no errors without an allowlist, got %v", errs)
+	}
+	if errs := validateRegistries(app, []string{"docker.io/library", "registry.example.com/team"}); len(errs) != 0 {
+		t.Errorf("expected no errors, got %v", errs)
+	}
+	errs := validateRegistries(app, []string{"registry.example.com"})
+	if len(errs) != 1 || errs[0].Field != "spec.deployment.template.spec.containers[0].image" {
+		t.Errorf("expected the image of web to be rejected, got %v", errs)
+	}
+}
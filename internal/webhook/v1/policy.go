@@ -0,0 +1,198 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/image"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+)
+
+// DefaultMaxReplicas 是未配置默认策略时允许的最大副本数
+const DefaultMaxReplicas = 10
+
+// defaultPolicyName 标识配置中的默认策略
+const defaultPolicyName = "default policy"
+
+// namedPolicy 是一条待评估的策略
+type namedPolicy struct {
+	// Name 用于在错误和警告中标识策略，例如 ApplicationPolicy default/limits
+	Name string
+	Spec *appsv1.ApplicationPolicySpec
+}
+
+// listPolicies 返回对 app 生效的所有策略：app 所在命名空间的 ApplicationPolicy、所有 ClusterApplicationPolicy 以及默认策略
+// 默认策略的 maxReplicas 只是兜底，作用范围内有 Enforce 模式的策略声明了 maxReplicas 时由该策略决定上限，可以收紧也可以放宽
+// Warn、Audit 模式的策略不会拒绝请求，不能替代默认策略
+func (v *ApplicationCustomValidator) listPolicies(ctx context.Context, app *appsv1.Application) ([]namedPolicy, error) {
+	policies := []namedPolicy{}
+	if v.Client != nil {
+		var err error
+		if policies, err = v.listPolicyObjects(ctx, app); err != nil {
+			return nil, err
+		}
+	}
+
+	defaultPolicy := v.Config.Load().DefaultPolicy.DeepCopy()
+	if enforcesMaxReplicas(policies) {
+		defaultPolicy.MaxReplicas = nil
+	}
+	return append(policies, namedPolicy{Name: defaultPolicyName, Spec: defaultPolicy}), nil
+}
+
+// enforcesMaxReplicas 判断是否有 Enforce 模式的策略声明了 maxReplicas
+func enforcesMaxReplicas(policies []namedPolicy) bool {
+	for _, policy := range policies {
+		if enforced(policy.Spec) && policy.Spec.MaxReplicas != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// enforced 判断策略的违规是否会拒绝请求
+func enforced(policy *appsv1.ApplicationPolicySpec) bool {
+	return policy.EnforcementMode == "" || policy.EnforcementMode == appsv1.EnforcementModeEnforce
+}
+
+// listPolicyObjects 列出 app 所在命名空间的 ApplicationPolicy 和所有 ClusterApplicationPolicy
+func (v *ApplicationCustomValidator) listPolicyObjects(ctx context.Context, app *appsv1.Application) ([]namedPolicy, error) {
+	policies := []namedPolicy{}
+
+	nsPolicies := &appsv1.ApplicationPolicyList{}
+	if err := v.Client.List(ctx, nsPolicies, client.InNamespace(app.Namespace)); err != nil {
+		return nil, err
+	}
+	for i := range nsPolicies.Items {
+		policy := &nsPolicies.Items[i]
+		policies = append(policies, namedPolicy{
+			Name: fmt.Sprintf("ApplicationPolicy %s/%s", policy.Namespace, policy.Name),
+			Spec: &policy.Spec,
+		})
+	}
+
+	clusterPolicies := &appsv1.ClusterApplicationPolicyList{}
+	if err := v.Client.List(ctx, clusterPolicies); err != nil {
+		return nil, err
+	}
+	for i := range clusterPolicies.Items {
+		policy := &clusterPolicies.Items[i]
+		policies = append(policies, namedPolicy{
+			Name: fmt.Sprintf("ClusterApplicationPolicy %s", policy.Name),
+			Spec: &policy.Spec,
+		})
+	}
+
+	return policies, nil
+}
+
+// validatePolicies 按各策略的 enforcementMode 评估 app，Enforce 策略的违规以错误返回，Warn 策略的违规以警告返回
+func (v *ApplicationCustomValidator) validatePolicies(ctx context.Context, app *appsv1.Application) (admission.Warnings, field.ErrorList, error) {
+	policies, err := v.listPolicies(ctx, app)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	for _, policy := range policies {
+		violations := evaluatePolicy(app, policy.Spec)
+		if len(violations) == 0 {
+			continue
+		}
+
+		switch policy.Spec.EnforcementMode {
+		case appsv1.EnforcementModeWarn:
+			for _, violation := range violations {
+				warnings = append(warnings, fmt.Sprintf("%s: %s", policy.Name, violation.Error()))
+			}
+		case appsv1.EnforcementModeAudit:
+			for _, violation := range violations {
+				tracing.Logger(ctx, applicationlog).Info("policy violation", "name", app.Name, "namespace", app.Namespace,
+					"policy", policy.Name, "violation", violation.Error())
+			}
+		default:
+			for _, violation := range violations {
+				violation.Detail = fmt.Sprintf("%s (%s)", violation.Detail, policy.Name)
+				allErrs = append(allErrs, violation)
+			}
+		}
+	}
+
+	return warnings, allErrs, nil
+}
+
+// evaluatePolicy 返回 app 违反 policy 的所有字段
+func evaluatePolicy(app *appsv1.Application, policy *appsv1.ApplicationPolicySpec) field.ErrorList {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if policy.MaxReplicas != nil {
+		maxReplicas := *policy.MaxReplicas
+		if replicas := app.Spec.Deployment.Replicas; replicas != nil && *replicas > maxReplicas {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("deployment", "replicas"), *replicas,
+				fmt.Sprintf("must be less than or equal to %d", maxReplicas)))
+		}
+		for i, env := range app.Spec.Environments {
+			if env.Replicas != nil && *env.Replicas > maxReplicas {
+				allErrs = append(allErrs, field.Invalid(specPath.Child("environments").Index(i).Child("replicas"), *env.Replicas,
+					fmt.Sprintf("must be less than or equal to %d", maxReplicas)))
+			}
+		}
+	}
+
+	for _, key := range policy.RequiredLabels {
+		if _, ok := app.Labels[key]; !ok {
+			allErrs = append(allErrs, field.Required(field.NewPath("metadata", "labels").Key(key), "label is required"))
+		}
+	}
+
+	containersPath := specPath.Child("deployment", "template", "spec", "containers")
+	for i, container := range app.Spec.Deployment.Template.Spec.Containers {
+		idxPath := containersPath.Index(i)
+		// 引用模板时镜像可能由模板提供，交由模板的维护者保证
+		if container.Image != "" && len(policy.AllowedRegistries) > 0 && !image.Allowed(container.Image, policy.AllowedRegistries) {
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("image"), container.Image, policy.AllowedRegistries))
+		}
+		for _, name := range policy.RequiredResourceLimits {
+			if _, ok := container.Resources.Limits[name]; !ok {
+				allErrs = append(allErrs, field.Required(idxPath.Child("resources", "limits").Key(string(name)), "limit is required"))
+			}
+		}
+	}
+
+	serviceType := app.Spec.Service.Type
+	if serviceType == "" {
+		serviceType = corev1.ServiceTypeClusterIP
+	}
+	for _, forbidden := range policy.ForbiddenServiceTypes {
+		if serviceType == forbidden {
+			allErrs = append(allErrs, field.Forbidden(specPath.Child("service", "type"),
+				fmt.Sprintf("service type %s is not allowed", serviceType)))
+		}
+	}
+
+	return allErrs
+}
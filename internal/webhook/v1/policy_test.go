@@ -0,0 +1,192 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func newMaxReplicasPolicy(name string, maxReplicas int32, mode appsv1.PolicyEnforcementMode) *appsv1.ApplicationPolicy {
+	policy := &appsv1.ApplicationPolicy{}
+	policy.Name, policy.Namespace = name, "default"
+	policy.Spec.MaxReplicas = &maxReplicas
+	policy.Spec.EnforcementMode = mode
+	return policy
+}
+
+func TestListPoliciesDefaultMaxReplicas(t *testing.T) {
+	tests := []struct {
+		name     string
+		policies []runtime.Object
+		// wantDefault 为 0 表示默认策略的 maxReplicas 被省略
+		wantDefault int32
+	}{
+		{
+			name:        "no policies",
+			wantDefault: DefaultMaxReplicas,
+		},
+		{
+			name:     "a stricter enforced policy replaces the default",
+			policies: []runtime.Object{newMaxReplicasPolicy("strict", 5, appsv1.EnforcementModeEnforce)},
+		},
+		{
+			name:     "a looser enforced policy raises the ceiling",
+			policies: []runtime.Object{newMaxReplicasPolicy("loose", 50, "")},
+		},
+		{
+			name: "an enforced policy in another namespace does not raise the ceiling",
+			policies: []runtime.Object{func() runtime.Object {
+				policy := newMaxReplicasPolicy("loose", 50, appsv1.EnforcementModeEnforce)
+				policy.Namespace = "other"
+				return policy
+			}()},
+			wantDefault: DefaultMaxReplicas,
+		},
+		{
+			name:        "a stricter warning policy does not replace the default",
+			policies:    []runtime.Object{newMaxReplicasPolicy("warn", 5, appsv1.EnforcementModeWarn)},
+			wantDefault: DefaultMaxReplicas,
+		},
+		{
+			name:        "a looser audit policy does not raise the ceiling",
+			policies:    []runtime.Object{newMaxReplicasPolicy("audit", 50, appsv1.EnforcementModeAudit)},
+			wantDefault: DefaultMaxReplicas,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestValidator(t, ApplicationWebhookOptions{}, tt.policies...)
+			app := &appsv1.Application{}
+			app.Name, app.Namespace = "demo", "default"
+
+			policies, err := v.listPolicies(context.Background(), app)
+			if err != nil {
+				t.Fatalf("list policies: %v", err)
+			}
+			defaultPolicy := policies[len(policies)-1]
+			var got int32
+			if defaultPolicy.Spec.MaxReplicas != nil {
+				got = *defaultPolicy.Spec.MaxReplicas
+			}
+			if got != tt.wantDefault {
+				t.Errorf("maxReplicas of the default policy = %d, want %d", got, tt.wantDefault)
+			}
+		})
+	}
+}
+
+func TestEvaluatePolicy(t *testing.T) {
+	three, five := int32(3), int32(5)
+	tests := []struct {
+		name   string
+		policy appsv1.ApplicationPolicySpec
+		mutate func(app *appsv1.Application)
+		want   []string
+	}{
+		{
+			name:   "empty policy",
+			policy: appsv1.ApplicationPolicySpec{},
+		},
+		{
+			name:   "replicas above the limit",
+			policy: appsv1.ApplicationPolicySpec{MaxReplicas: &three},
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Replicas = &five
+				app.Spec.Environments = []appsv1.ApplicationEnvironment{
+					{Name: "dev", Namespace: "team-a-dev", Replicas: &three},
+					{Name: "prod", Namespace: "team-a-prod", Replicas: &five},
+				}
+			},
+			want: []string{"spec.deployment.replicas", "spec.environments[1].replicas"},
+		},
+		{
+			name:   "missing labels",
+			policy: appsv1.ApplicationPolicySpec{RequiredLabels: []string{"app", "team"}},
+			want:   []string{"metadata.labels[team]"},
+		},
+		{
+			name:   "registry not allowed",
+			policy: appsv1.ApplicationPolicySpec{AllowedRegistries: []string{"registry.example.com"}},
+			want:   []string{"spec.deployment.template.spec.containers[0].image"},
+		},
+		{
+			name:   "missing resource limits",
+			policy: appsv1.ApplicationPolicySpec{RequiredResourceLimits: []corev1.ResourceName{corev1.ResourceMemory}},
+			want:   []string{"spec.deployment.template.spec.containers[0].resources.limits[memory]"},
+		},
+		{
+			name:   "the default service type is forbidden",
+			policy: appsv1.ApplicationPolicySpec{ForbiddenServiceTypes: []corev1.ServiceType{corev1.ServiceTypeClusterIP}},
+			want:   []string{"spec.service.type"},
+		},
+		{
+			name:   "an allowed service type",
+			policy: appsv1.ApplicationPolicySpec{ForbiddenServiceTypes: []corev1.ServiceType{corev1.ServiceTypeLoadBalancer}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newValidApplication()
+			if tt.mutate != nil {
+				tt.mutate(app)
+			}
+			if got := fieldPaths(evaluatePolicy(app, &tt.policy)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("evaluatePolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidatePoliciesEnforcementModes(t *testing.T) {
+	newLabelPolicy := func(name string, mode appsv1.PolicyEnforcementMode) *appsv1.ApplicationPolicy {
+		policy := &appsv1.ApplicationPolicy{}
+		policy.Name, policy.Namespace = name, "default"
+		policy.Spec.RequiredLabels = []string{"team"}
+		policy.Spec.EnforcementMode = mode
+		return policy
+	}
+	tests := []struct {
+		name         string
+		mode         appsv1.PolicyEnforcementMode
+		wantErrs     int
+		wantWarnings int
+	}{
+		{name: "enforce by default", wantErrs: 1},
+		{name: "enforce", mode: appsv1.EnforcementModeEnforce, wantErrs: 1},
+		{name: "warn", mode: appsv1.EnforcementModeWarn, wantWarnings: 1},
+		{name: "audit", mode: appsv1.EnforcementModeAudit},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := newTestValidator(t, ApplicationWebhookOptions{}, newLabelPolicy("labels", tt.mode))
+			warnings, errs, err := v.validatePolicies(context.Background(), newValidApplication())
+			if err != nil {
+				t.Fatalf("validate policies: %v", err)
+			}
+			if len(errs) != tt.wantErrs || len(warnings) != tt.wantWarnings {
+				t.Errorf("got errors %v and warnings %v, want %d errors and %d warnings", errs, warnings, tt.wantErrs, tt.wantWarnings)
+			}
+		})
+	}
+}
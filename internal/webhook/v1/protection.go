@@ -0,0 +1,165 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/tracing"
+)
+
+// systemDeleters 是删除 Application 的 Kubernetes 组件：ApplicationSet 等 owner 删除后的垃圾回收，以及命名空间删除时的清理
+// kube-controller-manager 未使用独立的 ServiceAccount 时以 system:kube-controller-manager 身份请求
+var systemDeleters = sets.New[string](
+	"system:serviceaccount:kube-system:generic-garbage-collector",
+	"system:serviceaccount:kube-system:namespace-controller",
+	"system:kube-controller-manager",
+)
+
+// validateDeletion 判断 app 是否受删除保护，受保护的 Application 只有在设置确认删除的注解后才能删除
+// 删除保护只针对用户的误操作：垃圾回收以及命名空间删除不受限制，
+// 这些删除都源自用户对 owner 或命名空间的显式操作，拒绝它们只会让清理无限重试，并使命名空间一直处于 Terminating
+// Operator 自身同样受到保护，ApplicationSet 清理不再生成的 Application 被拒绝时以 PruneBlocked Condition 提示用户确认删除
+func (v *ApplicationCustomValidator) validateDeletion(ctx context.Context, app *appsv1.Application) (admission.Warnings, error) {
+	if app.DeletionConfirmed() {
+		return nil, nil
+	}
+	exempt, err := v.deletionExempt(ctx, app)
+	if err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+	if exempt {
+		return nil, nil
+	}
+
+	var warnings admission.Warnings
+	var reasons []string
+	if app.IsProtected() {
+		reasons = append(reasons, fmt.Sprintf("it is marked with %s=true", appsv1.ProtectedAnnotation))
+	}
+
+	policies, err := v.listPolicies(ctx, app)
+	if err != nil {
+		return nil, errors.NewInternalError(err)
+	}
+
+	// 是否仍在接收流量只在有策略需要时才检查，且只检查一次
+	var receivingTraffic *bool
+	for _, policy := range policies {
+		protection := policy.Spec.DeletionProtection
+		if protection == nil {
+			continue
+		}
+
+		reason := ""
+		if protection.Always {
+			reason = fmt.Sprintf("it is protected by %s", policy.Name)
+		} else if protection.WhileReceivingTraffic {
+			if receivingTraffic == nil {
+				receiving, err := v.receivingTraffic(ctx, app)
+				if err != nil {
+					return nil, errors.NewInternalError(err)
+				}
+				receivingTraffic = &receiving
+			}
+			if *receivingTraffic {
+				reason = fmt.Sprintf("it is still receiving traffic and protected by %s", policy.Name)
+			}
+		}
+		if reason == "" {
+			continue
+		}
+
+		switch policy.Spec.EnforcementMode {
+		case appsv1.EnforcementModeWarn:
+			warnings = append(warnings, fmt.Sprintf("Application %s/%s is deleted although %s", app.Namespace, app.Name, reason))
+		case appsv1.EnforcementModeAudit:
+			tracing.Logger(ctx, applicationlog).Info("policy violation", "name", app.Name, "namespace", app.Namespace,
+				"policy", policy.Name, "violation", "deleted although "+reason)
+		default:
+			reasons = append(reasons, reason)
+		}
+	}
+
+	if len(reasons) == 0 {
+		return warnings, nil
+	}
+
+	return warnings, errors.NewForbidden(appsv1.GroupVersion.WithResource("applications").GroupResource(), app.Name,
+		fmt.Errorf("%s, set the annotation %s=%s to confirm the deletion",
+			strings.Join(reasons, "; "), appsv1.ConfirmDeleteAnnotation, app.Name))
+}
+
+// deletionExempt 判断删除请求是否来自不受删除保护限制的用户，或 app 所在的命名空间是否正在删除
+func (v *ApplicationCustomValidator) deletionExempt(ctx context.Context, app *appsv1.Application) (bool, error) {
+	if req, err := admission.RequestFromContext(ctx); err == nil {
+		username := req.UserInfo.Username
+		if systemDeleters.Has(username) || sets.New(v.Config.Load().DeletionProtectionExemptUsers...).Has(username) {
+			return true, nil
+		}
+	}
+
+	if v.APIReader == nil {
+		return false, nil
+	}
+	ns := &corev1.Namespace{}
+	if err := v.APIReader.Get(ctx, types.NamespacedName{Name: app.Namespace}, ns); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return !ns.DeletionTimestamp.IsZero(), nil
+}
+
+// receivingTraffic 判断 app 在自身命名空间或任一环境命名空间中的 Service 是否仍有就绪的 Endpoints
+// 使用不经过缓存的 APIReader，避免为了偶尔的删除请求而在内存中缓存集群中所有的 Endpoints
+func (v *ApplicationCustomValidator) receivingTraffic(ctx context.Context, app *appsv1.Application) (bool, error) {
+	if v.APIReader == nil {
+		return false, nil
+	}
+
+	namespaces := []string{app.Namespace}
+	for _, env := range app.Spec.Environments {
+		namespaces = append(namespaces, env.Namespace)
+	}
+
+	for _, namespace := range namespaces {
+		endpoints := &corev1.Endpoints{}
+		if err := v.APIReader.Get(ctx, types.NamespacedName{Namespace: namespace, Name: app.Name}, endpoints); err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return false, err
+		}
+		for _, subset := range endpoints.Subsets {
+			if len(subset.Addresses) > 0 {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
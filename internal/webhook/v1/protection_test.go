@@ -0,0 +1,189 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func TestValidateDeletion(t *testing.T) {
+	const operator = "system:serviceaccount:clusterops-operator-system:clusterops-operator-controller-manager"
+	const admin = "ops-admin"
+	active := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default"}}
+	now := metav1.Now()
+	terminating := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "default", DeletionTimestamp: &now, Finalizers: []string{"kubernetes"}}}
+
+	trafficPolicy := &appsv1.ApplicationPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "traffic", Namespace: "default"},
+		Spec:       appsv1.ApplicationPolicySpec{DeletionProtection: &appsv1.DeletionProtection{WhileReceivingTraffic: true}},
+	}
+	withEnvironment := func(app *appsv1.Application) {
+		delete(app.Annotations, appsv1.ProtectedAnnotation)
+		app.Spec.Environments = []appsv1.ApplicationEnvironment{{Name: "prod", Namespace: "team-a-prod"}}
+	}
+
+	tests := []struct {
+		name      string
+		user      string
+		namespace *corev1.Namespace
+		policy    *appsv1.ApplicationPolicy
+		endpoints *corev1.Endpoints
+		mutate    func(app *appsv1.Application)
+		wantErr   bool
+	}{
+		{
+			name:      "unprotected Applications may be deleted",
+			user:      "alice",
+			namespace: active,
+			mutate:    func(app *appsv1.Application) { delete(app.Annotations, appsv1.ProtectedAnnotation) },
+		},
+		{
+			name:      "protected Applications are rejected",
+			user:      "alice",
+			namespace: active,
+			wantErr:   true,
+		},
+		{
+			name:      "the protected label has no effect",
+			user:      "alice",
+			namespace: active,
+			mutate: func(app *appsv1.Application) {
+				delete(app.Annotations, appsv1.ProtectedAnnotation)
+				app.Labels = map[string]string{appsv1.ProtectedAnnotation: "true"}
+			},
+		},
+		{
+			name:      "confirmed deletions are allowed",
+			user:      "alice",
+			namespace: active,
+			mutate: func(app *appsv1.Application) {
+				app.Annotations[appsv1.ConfirmDeleteAnnotation] = app.Name
+			},
+		},
+		{
+			name:      "the garbage collector is exempt",
+			user:      "system:serviceaccount:kube-system:generic-garbage-collector",
+			namespace: active,
+		},
+		{
+			name:      "a configured user is exempt",
+			user:      admin,
+			namespace: active,
+		},
+		{
+			name:      "the operator pruning an ApplicationSet is not exempt",
+			user:      operator,
+			namespace: active,
+			wantErr:   true,
+		},
+		{
+			name:      "deletions of a terminating namespace are exempt",
+			user:      "bob",
+			namespace: terminating,
+		},
+		{
+			name:      "warning policies do not reject",
+			user:      "alice",
+			namespace: active,
+			policy: &appsv1.ApplicationPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "warn", Namespace: "default"},
+				Spec: appsv1.ApplicationPolicySpec{
+					EnforcementMode:    appsv1.EnforcementModeWarn,
+					DeletionProtection: &appsv1.DeletionProtection{Always: true},
+				},
+			},
+			mutate: func(app *appsv1.Application) { delete(app.Annotations, appsv1.ProtectedAnnotation) },
+		},
+		{
+			name:      "enforced policies reject",
+			user:      "alice",
+			namespace: active,
+			policy: &appsv1.ApplicationPolicy{
+				ObjectMeta: metav1.ObjectMeta{Name: "always", Namespace: "default"},
+				Spec:       appsv1.ApplicationPolicySpec{DeletionProtection: &appsv1.DeletionProtection{Always: true}},
+			},
+			mutate:  func(app *appsv1.Application) { delete(app.Annotations, appsv1.ProtectedAnnotation) },
+			wantErr: true,
+		},
+		{
+			name:      "Applications without traffic may be deleted",
+			user:      "alice",
+			namespace: active,
+			policy:    trafficPolicy,
+			endpoints: &corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "team-a-prod"},
+				Subsets:    []corev1.EndpointSubset{{NotReadyAddresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+			},
+			mutate: withEnvironment,
+		},
+		{
+			name:      "Applications receiving traffic in an environment are rejected",
+			user:      "alice",
+			namespace: active,
+			policy:    trafficPolicy,
+			endpoints: &corev1.Endpoints{
+				ObjectMeta: metav1.ObjectMeta{Name: "demo", Namespace: "team-a-prod"},
+				Subsets:    []corev1.EndpointSubset{{Addresses: []corev1.EndpointAddress{{IP: "10.0.0.1"}}}},
+			},
+			mutate:  withEnvironment,
+			wantErr: true,
+		},
+		{
+			name: "deletions in a deleted namespace are exempt",
+			user: "bob",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objs []runtime.Object
+			if tt.namespace != nil {
+				objs = append(objs, tt.namespace)
+			}
+			if tt.policy != nil {
+				objs = append(objs, tt.policy)
+			}
+			if tt.endpoints != nil {
+				objs = append(objs, tt.endpoints)
+			}
+			v := newTestValidator(t, ApplicationWebhookOptions{DeletionProtectionExemptUsers: []string{admin}}, objs...)
+			app := &appsv1.Application{}
+			app.Name, app.Namespace = "demo", "default"
+			app.Annotations = map[string]string{appsv1.ProtectedAnnotation: "true"}
+			if tt.mutate != nil {
+				tt.mutate(app)
+			}
+			ctx := admission.NewContextWithRequest(context.Background(), admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{
+				Operation: admissionv1.Delete,
+				UserInfo:  authenticationv1.UserInfo{Username: tt.user},
+			}})
+
+			if _, err := v.validateDeletion(ctx, app); (err != nil) != tt.wantErr {
+				t.Errorf("validateDeletion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
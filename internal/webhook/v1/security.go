@@ -0,0 +1,36 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+	"github.com/ahwhy/clusterops-operator/internal/security"
+)
+
+// applySecurityProfile 按安全配置为 Application 自身声明的 Pod 模板注入默认的 securityContext
+// 引用模板时，模板提供的部分由控制器在合并之后注入
+func applySecurityProfile(app *appsv1.Application) {
+	security.Apply(security.Profile(app), &app.Spec.Deployment.Template)
+}
+
+// validateSecurityProfile 按安全配置校验 Application 自身声明的 Pod 模板
+// 引用模板时，合并之后的 Pod 模板由控制器在渲染时再次校验，违规时不创建或更新子资源
+func validateSecurityProfile(app *appsv1.Application) field.ErrorList {
+	return security.Validate(security.Profile(app), &app.Spec.Deployment.Template, field.NewPath("spec", "deployment", "template"))
+}
@@ -0,0 +1,118 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+func newSecurityApplication(profile appsv1.SecurityProfile) *appsv1.Application {
+	app := &appsv1.Application{}
+	app.Name = "demo"
+	app.Spec.Security = &appsv1.SecuritySpec{Profile: profile}
+	app.Spec.Deployment.Template.Spec.Containers = []corev1.Container{{Name: "web", Image: "nginx:1.25"}}
+	return app
+}
+
+func TestApplySecurityProfileRestricted(t *testing.T) {
+	app := newSecurityApplication(appsv1.SecurityProfileRestricted)
+	applySecurityProfile(app)
+
+	if errs := validateSecurityProfile(app); len(errs) != 0 {
+		t.Fatalf("defaulted pod template violates the restricted profile: %v", errs)
+	}
+	sc := app.Spec.Deployment.Template.Spec.Containers[0].SecurityContext
+	if sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("expected readOnlyRootFilesystem to be injected, got %+v", sc)
+	}
+}
+
+func TestApplySecurityProfileKeepsDeclaredFields(t *testing.T) {
+	app := newSecurityApplication(appsv1.SecurityProfileRestricted)
+	readOnly := false
+	app.Spec.Deployment.Template.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{ReadOnlyRootFilesystem: &readOnly}
+	applySecurityProfile(app)
+
+	if sc := app.Spec.Deployment.Template.Spec.Containers[0].SecurityContext; *sc.ReadOnlyRootFilesystem {
+		t.Errorf("declared readOnlyRootFilesystem must not be overridden")
+	}
+}
+
+func TestValidateSecurityProfile(t *testing.T) {
+	privileged := true
+	tests := []struct {
+		name    string
+		profile appsv1.SecurityProfile
+		mutate  func(podSpec *corev1.PodSpec)
+		fields  []string
+	}{
+		{
+			name:    "baseline rejects host namespaces and privileged containers",
+			profile: appsv1.SecurityProfileBaseline,
+			mutate: func(podSpec *corev1.PodSpec) {
+				podSpec.HostNetwork = true
+				podSpec.Containers[0].SecurityContext = &corev1.SecurityContext{Privileged: &privileged}
+			},
+			fields: []string{
+				"spec.deployment.template.spec.hostNetwork",
+				"spec.deployment.template.spec.containers[0].securityContext.privileged",
+			},
+		},
+		{
+			name:    "baseline allows a pod without securityContext",
+			profile: appsv1.SecurityProfileBaseline,
+			mutate:  func(podSpec *corev1.PodSpec) {},
+		},
+		{
+			name:    "restricted requires hardening fields",
+			profile: appsv1.SecurityProfileRestricted,
+			mutate:  func(podSpec *corev1.PodSpec) {},
+			fields: []string{
+				"spec.deployment.template.spec.containers[0].securityContext.allowPrivilegeEscalation",
+				"spec.deployment.template.spec.containers[0].securityContext.runAsNonRoot",
+				"spec.deployment.template.spec.containers[0].securityContext.seccompProfile",
+				"spec.deployment.template.spec.containers[0].securityContext.capabilities.drop",
+			},
+		},
+		{
+			name:    "custom is not validated",
+			profile: appsv1.SecurityProfileCustom,
+			mutate:  func(podSpec *corev1.PodSpec) { podSpec.HostPID = true },
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newSecurityApplication(tt.profile)
+			tt.mutate(&app.Spec.Deployment.Template.Spec)
+
+			errs := validateSecurityProfile(app)
+			if len(errs) != len(tt.fields) {
+				t.Fatalf("expected %d violations, got %v", len(tt.fields), errs)
+			}
+			for i, err := range errs {
+				if err.Field != tt.fields[i] {
+					t.Errorf("violation %d is on %s, want %s", i, err.Field, tt.fields[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,374 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// validateSpec 校验 Application 的 spec，返回带有精确字段路径的错误列表以及不阻断请求的警告
+func validateSpec(app *appsv1.Application) (admission.Warnings, field.ErrorList) {
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	// Service 的 selector 直接取自 metadata.labels，为空时 Service 选不中任何 Pod
+	if len(app.Labels) == 0 {
+		warnings = append(warnings, "metadata.labels is empty, the generated Service will not select any Pod")
+	}
+	// 早期版本的 webhook 会把 labels 填充为 spec.service.selector，两者一致时不再提示
+	if len(app.Spec.Service.Selector) > 0 && !reflect.DeepEqual(app.Spec.Service.Selector, app.Labels) {
+		warnings = append(warnings, "spec.service.selector is ignored, the generated Service selects Pods by metadata.labels")
+	}
+	// 删除保护和分片只识别注解，误设为标签时不会生效，还会进入 Service 的 selector
+	if _, ok := app.Labels[appsv1.ProtectedAnnotation]; ok {
+		warnings = append(warnings, fmt.Sprintf("the label %s has no effect, set it as an annotation to protect the Application from deletion",
+			appsv1.ProtectedAnnotation))
+	}
+	if _, ok := app.Labels[appsv1.ShardAnnotation]; ok {
+		warnings = append(warnings, fmt.Sprintf("the label %s has no effect, set it as an annotation to choose the shard of the Application",
+			appsv1.ShardAnnotation))
+	}
+
+	allErrs = append(allErrs, validateDeployment(app, specPath.Child("deployment"))...)
+	allErrs = append(allErrs, validateService(app, specPath.Child("service"))...)
+	allErrs = append(allErrs, validateDependsOn(app, specPath.Child("dependsOn"))...)
+	allErrs = append(allErrs, validateEnvironments(app, specPath.Child("environments"))...)
+
+	return warnings, allErrs
+}
+
+func validateDeployment(app *appsv1.Application, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	spec := app.Spec.Deployment
+
+	// 副本数上限由 ApplicationPolicy 决定，这里只校验取值本身
+	if spec.Replicas != nil && *spec.Replicas < 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("replicas"), *spec.Replicas, "must be greater than or equal to 0"))
+	}
+
+	// 控制器会把 metadata.labels 合并进 Pod 模板的标签，selector 需要能够选中最终的 Pod 标签
+	// 引用模板时 selector 和 Pod 模板的标签可以由模板提供，只校验本地声明的 selector 本身
+	selectorPath := fldPath.Child("selector")
+	templated := app.Spec.TemplateRef != nil
+	if spec.Selector == nil {
+		if !templated {
+			allErrs = append(allErrs, field.Required(selectorPath, ""))
+		}
+	} else if selector, err := metav1.LabelSelectorAsSelector(spec.Selector); err != nil {
+		allErrs = append(allErrs, field.Invalid(selectorPath, spec.Selector, err.Error()))
+	} else if selector.Empty() {
+		allErrs = append(allErrs, field.Invalid(selectorPath, spec.Selector, "empty selector is not valid for a deployment"))
+	} else if !templated {
+		podLabels := labels.Merge(spec.Template.Labels, app.Labels)
+		if !selector.Matches(podLabels) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("template", "metadata", "labels"), podLabels,
+				"`selector` does not match template `labels` merged with metadata.labels"))
+		}
+	}
+
+	allErrs = append(allErrs, validateContainers(app, fldPath.Child("template", "spec", "containers"))...)
+	return allErrs
+}
+
+func validateContainers(app *appsv1.Application, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	containers := app.Spec.Deployment.Template.Spec.Containers
+
+	// 引用模板时容器可以全部由模板提供
+	if len(containers) == 0 && app.Spec.TemplateRef == nil {
+		return append(allErrs, field.Required(fldPath, "at least one container is required"))
+	}
+
+	names := sets.New[string]()
+	for i := range containers {
+		container := &containers[i]
+		idxPath := fldPath.Index(i)
+
+		if container.Name == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("name"), ""))
+		} else if names.Has(container.Name) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("name"), container.Name))
+		}
+		names.Insert(container.Name)
+
+		// 引用模板时镜像可以由模板中的同名容器提供
+		if container.Image == "" && app.Spec.TemplateRef == nil {
+			allErrs = append(allErrs, field.Required(idxPath.Child("image"), ""))
+		}
+
+		portNames := sets.New[string]()
+		for j, port := range container.Ports {
+			if port.Name == "" {
+				continue
+			}
+			if portNames.Has(port.Name) {
+				allErrs = append(allErrs, field.Duplicate(idxPath.Child("ports").Index(j).Child("name"), port.Name))
+			}
+			portNames.Insert(port.Name)
+		}
+
+		allErrs = append(allErrs, validateResources(&container.Resources, idxPath.Child("resources"))...)
+		allErrs = append(allErrs, validateProbe(container, container.LivenessProbe, idxPath.Child("livenessProbe"), true)...)
+		allErrs = append(allErrs, validateProbe(container, container.ReadinessProbe, idxPath.Child("readinessProbe"), false)...)
+		allErrs = append(allErrs, validateProbe(container, container.StartupProbe, idxPath.Child("startupProbe"), true)...)
+	}
+	return allErrs
+}
+
+// validateResources 校验 requests 不超过 limits
+func validateResources(resources *corev1.ResourceRequirements, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for name, request := range resources.Requests {
+		limit, ok := resources.Limits[name]
+		if ok && request.Cmp(limit) > 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("requests").Key(string(name)), request.String(),
+				fmt.Sprintf("must be less than or equal to %s limit of %s", name, limit.String())))
+		}
+	}
+	return allErrs
+}
+
+// validateProbe 校验探针只声明了一种探测方式、数值合法，且引用的命名端口存在
+func validateProbe(container *corev1.Container, probe *corev1.Probe, fldPath *field.Path, requireSingleSuccess bool) field.ErrorList {
+	var allErrs field.ErrorList
+	if probe == nil {
+		return allErrs
+	}
+
+	handlers := 0
+	var port *intstr.IntOrString
+	var portPath *field.Path
+	if probe.Exec != nil {
+		handlers++
+	}
+	if probe.HTTPGet != nil {
+		handlers++
+		port, portPath = &probe.HTTPGet.Port, fldPath.Child("httpGet", "port")
+	}
+	if probe.TCPSocket != nil {
+		handlers++
+		port, portPath = &probe.TCPSocket.Port, fldPath.Child("tcpSocket", "port")
+	}
+	if probe.GRPC != nil {
+		handlers++
+	}
+	switch {
+	case handlers == 0:
+		allErrs = append(allErrs, field.Required(fldPath, "must specify a handler type"))
+	case handlers > 1:
+		allErrs = append(allErrs, field.Forbidden(fldPath, "may not specify more than 1 handler type"))
+	}
+
+	if port != nil && port.Type == intstr.String && !containerHasPortName(container, port.StrVal) {
+		allErrs = append(allErrs, field.NotFound(portPath, port.StrVal))
+	}
+
+	for _, v := range []struct {
+		name  string
+		value int32
+	}{
+		{"initialDelaySeconds", probe.InitialDelaySeconds},
+		{"timeoutSeconds", probe.TimeoutSeconds},
+		{"periodSeconds", probe.PeriodSeconds},
+		{"successThreshold", probe.SuccessThreshold},
+		{"failureThreshold", probe.FailureThreshold},
+	} {
+		if v.value < 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child(v.name), v.value, "must be greater than or equal to 0"))
+		}
+	}
+	if probe.PeriodSeconds > 0 && probe.TimeoutSeconds > probe.PeriodSeconds {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("timeoutSeconds"), probe.TimeoutSeconds,
+			"must be less than or equal to periodSeconds"))
+	}
+	if requireSingleSuccess && probe.SuccessThreshold > 1 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("successThreshold"), probe.SuccessThreshold, "must be 1"))
+	}
+	return allErrs
+}
+
+func containerHasPortName(container *corev1.Container, name string) bool {
+	for _, port := range container.Ports {
+		if port.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// validateService 校验 Service 的 targetPort 能够对应到 Deployment 容器声明的端口
+// 引用模板时容器端口可以由模板提供，本地无法判断，交由控制器渲染后的结果决定
+func validateService(app *appsv1.Application, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if app.Spec.TemplateRef != nil {
+		return allErrs
+	}
+
+	portNames := sets.New[string]()
+	portNumbers := sets.New[int32]()
+	for _, container := range app.Spec.Deployment.Template.Spec.Containers {
+		for _, port := range container.Ports {
+			if port.Name != "" {
+				portNames.Insert(port.Name)
+			}
+			portNumbers.Insert(port.ContainerPort)
+		}
+	}
+
+	for i, port := range app.Spec.Service.Ports {
+		idxPath := fldPath.Child("ports").Index(i)
+		targetPort := port.TargetPort
+		// 未设置 targetPort 时默认与 port 相同
+		if targetPort.Type == intstr.Int && targetPort.IntVal == 0 {
+			targetPort = intstr.FromInt(int(port.Port))
+		}
+
+		switch targetPort.Type {
+		case intstr.String:
+			if !portNames.Has(targetPort.StrVal) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("targetPort"), targetPort.StrVal,
+					"must match the name of a containerPort declared in spec.deployment.template.spec.containers"))
+			}
+		case intstr.Int:
+			// 容器未声明任何端口时无法判断，交由运行时处理
+			if portNumbers.Len() > 0 && !portNumbers.Has(targetPort.IntVal) {
+				allErrs = append(allErrs, field.Invalid(idxPath.Child("targetPort"), targetPort.IntVal,
+					"must match a containerPort declared in spec.deployment.template.spec.containers"))
+			}
+		}
+	}
+	return allErrs
+}
+
+func validateDependsOn(app *appsv1.Application, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, ref := range app.Spec.DependsOn {
+		namespace := ref.Namespace
+		if namespace == "" {
+			namespace = app.Namespace
+		}
+		if ref.Name == app.Name && namespace == app.Namespace {
+			allErrs = append(allErrs, field.Invalid(fldPath.Index(i), ref, "an Application may not depend on itself"))
+		}
+	}
+	return allErrs
+}
+
+func validateEnvironments(app *appsv1.Application, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	namespaces := sets.New[string]()
+	for i, env := range app.Spec.Environments {
+		idxPath := fldPath.Index(i)
+		if env.Namespace == "" {
+			allErrs = append(allErrs, field.Required(idxPath.Child("namespace"), "environments must declare the namespace they render into"))
+		} else if namespaces.Has(env.Namespace) {
+			allErrs = append(allErrs, field.Duplicate(idxPath.Child("namespace"), env.Namespace))
+		}
+		namespaces.Insert(env.Namespace)
+		if env.Replicas != nil && *env.Replicas < 0 {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("replicas"), *env.Replicas, "must be greater than or equal to 0"))
+		}
+		if env.Resources != nil {
+			allErrs = append(allErrs, validateResources(env.Resources, idxPath.Child("resources"))...)
+		}
+	}
+	return allErrs
+}
+
+// validateSpecUpdate 校验从 old 到 app 的变更：禁止修改实际不可变的字段，并对高风险的变更给出警告
+// Application 只渲染 Deployment 作为工作负载，因此不存在工作负载类型的变更
+func validateSpecUpdate(old, app *appsv1.Application) (admission.Warnings, field.ErrorList) {
+	var warnings admission.Warnings
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	allowed := app.AllowsImmutableChanges()
+
+	immutable := func(fldPath *field.Path, value interface{}, recreated string) {
+		if allowed {
+			warnings = append(warnings, fmt.Sprintf("%s changed, the %s will be deleted and recreated", fldPath, recreated))
+			return
+		}
+		allErrs = append(allErrs, field.Invalid(fldPath, value,
+			fmt.Sprintf("field is immutable, set the annotation %s=true to recreate the %s", appsv1.AllowImmutableChangesAnnotation, recreated)))
+	}
+
+	selectorPath := specPath.Child("deployment", "selector")
+	if !apiequality.Semantic.DeepEqual(old.Spec.Deployment.Selector, app.Spec.Deployment.Selector) {
+		immutable(selectorPath, app.Spec.Deployment.Selector, "Deployments")
+	}
+
+	// 清空 clusterIP 时控制器会沿用已分配的地址，只有指定新的地址才需要重建
+	clusterIPPath := specPath.Child("service", "clusterIP")
+	if clusterIP := app.Spec.Service.ClusterIP; clusterIP != "" && clusterIP != old.Spec.Service.ClusterIP {
+		immutable(clusterIPPath, clusterIP, "Services")
+	}
+
+	if oldType, newType := serviceType(&old.Spec.Service.ServiceSpec), serviceType(&app.Spec.Service.ServiceSpec); oldType != newType {
+		warnings = append(warnings, fmt.Sprintf("%s changed from %s to %s, the cluster IP, node ports or load balancer address may be reallocated",
+			specPath.Child("service", "type"), oldType, newType))
+	}
+
+	if warning := replicaDropWarning(specPath.Child("deployment", "replicas"),
+		old.Spec.Deployment.Replicas, app.Spec.Deployment.Replicas); warning != "" {
+		warnings = append(warnings, warning)
+	}
+	for i, env := range app.Spec.Environments {
+		for _, oldEnv := range old.Spec.Environments {
+			if oldEnv.Name != env.Name {
+				continue
+			}
+			if warning := replicaDropWarning(specPath.Child("environments").Index(i).Child("replicas"),
+				oldEnv.Replicas, env.Replicas); warning != "" {
+				warnings = append(warnings, warning)
+			}
+		}
+	}
+
+	return warnings, allErrs
+}
+
+func serviceType(spec *corev1.ServiceSpec) corev1.ServiceType {
+	if spec.Type == "" {
+		return corev1.ServiceTypeClusterIP
+	}
+	return spec.Type
+}
+
+// replicaDropWarning 在副本数减少一半以上时返回警告
+func replicaDropWarning(fldPath *field.Path, oldReplicas, newReplicas *int32) string {
+	if oldReplicas == nil || newReplicas == nil {
+		return ""
+	}
+	if *newReplicas*2 >= *oldReplicas {
+		return ""
+	}
+	return fmt.Sprintf("%s drops from %d to %d, the remaining replicas may not be able to serve the traffic",
+		fldPath, *oldReplicas, *newReplicas)
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2023 ahwhya.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	appsv1 "github.com/ahwhy/clusterops-operator/api/v1"
+)
+
+// newValidApplication 返回一个能够通过 validateSpec 的 Application
+func newValidApplication() *appsv1.Application {
+	app := &appsv1.Application{}
+	app.Name, app.Namespace = "demo", "default"
+	app.Labels = map[string]string{"app": "demo"}
+	app.Spec.Deployment.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "demo"}}
+	app.Spec.Deployment.Template.Spec.Containers = []corev1.Container{{
+		Name:  "web",
+		Image: "nginx:1.25",
+		Ports: []corev1.ContainerPort{{Name: "http", ContainerPort: 8080}},
+	}}
+	app.Spec.Service.Ports = []corev1.ServicePort{{Port: 80, TargetPort: intstr.FromString("http")}}
+	return app
+}
+
+// fieldPaths 返回错误列表中的字段路径
+func fieldPaths(errs field.ErrorList) []string {
+	var paths []string
+	for _, err := range errs {
+		paths = append(paths, err.Field)
+	}
+	return paths
+}
+
+func TestValidateSpec(t *testing.T) {
+	negative := int32(-1)
+	tests := []struct {
+		name         string
+		mutate       func(app *appsv1.Application)
+		want         []string
+		wantWarnings int
+	}{
+		{
+			name:   "valid",
+			mutate: func(app *appsv1.Application) {},
+		},
+		{
+			name: "empty labels only warn",
+			mutate: func(app *appsv1.Application) {
+				app.Labels = nil
+				app.Spec.Deployment.Template.Labels = map[string]string{"app": "demo"}
+			},
+			wantWarnings: 1,
+		},
+		{
+			name:         "a service selector only warns",
+			mutate:       func(app *appsv1.Application) { app.Spec.Service.Selector = map[string]string{"app": "web"} },
+			wantWarnings: 1,
+		},
+		{
+			name:   "a service selector equal to the labels is accepted",
+			mutate: func(app *appsv1.Application) { app.Spec.Service.Selector = map[string]string{"app": "demo"} },
+		},
+		{
+			name:         "the shard label only warns",
+			mutate:       func(app *appsv1.Application) { app.Labels[appsv1.ShardAnnotation] = "payments" },
+			wantWarnings: 1,
+		},
+		{
+			name:         "the protected label only warns",
+			mutate:       func(app *appsv1.Application) { app.Labels[appsv1.ProtectedAnnotation] = "true" },
+			wantWarnings: 1,
+		},
+		{
+			name:   "negative replicas",
+			mutate: func(app *appsv1.Application) { app.Spec.Deployment.Replicas = &negative },
+			want:   []string{"spec.deployment.replicas"},
+		},
+		{
+			name:   "missing selector",
+			mutate: func(app *appsv1.Application) { app.Spec.Deployment.Selector = nil },
+			want:   []string{"spec.deployment.selector"},
+		},
+		{
+			name: "selector not matching the pod labels",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Selector.MatchLabels = map[string]string{"app": "other"}
+			},
+			want: []string{"spec.deployment.template.metadata.labels"},
+		},
+		{
+			name: "duplicate container names and missing image",
+			mutate: func(app *appsv1.Application) {
+				containers := &app.Spec.Deployment.Template.Spec.Containers
+				*containers = append(*containers, corev1.Container{Name: "web"})
+			},
+			want: []string{
+				"spec.deployment.template.spec.containers[1].name",
+				"spec.deployment.template.spec.containers[1].image",
+			},
+		},
+		{
+			name: "requests above limits",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Template.Spec.Containers[0].Resources = corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("2")},
+					Limits:   corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("1")},
+				}
+			},
+			want: []string{"spec.deployment.template.spec.containers[0].resources.requests[cpu]"},
+		},
+		{
+			name: "probe with two handlers and an unknown named port",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Template.Spec.Containers[0].ReadinessProbe = &corev1.Probe{ProbeHandler: corev1.ProbeHandler{
+					Exec:    &corev1.ExecAction{Command: []string{"true"}},
+					HTTPGet: &corev1.HTTPGetAction{Port: intstr.FromString("metrics")},
+				}}
+			},
+			want: []string{
+				"spec.deployment.template.spec.containers[0].readinessProbe",
+				"spec.deployment.template.spec.containers[0].readinessProbe.httpGet.port",
+			},
+		},
+		{
+			name: "liveness probe requiring more than one success",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Template.Spec.Containers[0].LivenessProbe = &corev1.Probe{
+					ProbeHandler:     corev1.ProbeHandler{TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromString("http")}},
+					SuccessThreshold: 2,
+				}
+			},
+			want: []string{"spec.deployment.template.spec.containers[0].livenessProbe.successThreshold"},
+		},
+		{
+			name: "service targeting an undeclared port",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Service.Ports = append(app.Spec.Service.Ports,
+					corev1.ServicePort{Port: 443, TargetPort: intstr.FromString("https")},
+					corev1.ServicePort{Port: 9090})
+			},
+			want: []string{"spec.service.ports[1].targetPort", "spec.service.ports[2].targetPort"},
+		},
+		{
+			name: "a template may supply the selector, containers and ports",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.TemplateRef = &appsv1.TemplateReference{Name: "web"}
+				app.Spec.Deployment.Selector = nil
+				app.Spec.Deployment.Template.Spec.Containers = nil
+				app.Spec.Service.Ports = []corev1.ServicePort{{Port: 443, TargetPort: intstr.FromString("https")}}
+			},
+		},
+		{
+			name: "a declared selector is still validated with a template",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.TemplateRef = &appsv1.TemplateReference{Name: "web"}
+				app.Spec.Deployment.Selector = &metav1.LabelSelector{}
+			},
+			want: []string{"spec.deployment.selector"},
+		},
+		{
+			name:   "depending on itself",
+			mutate: func(app *appsv1.Application) { app.Spec.DependsOn = []appsv1.ApplicationReference{{Name: "demo"}} },
+			want:   []string{"spec.dependsOn[0]"},
+		},
+		{
+			name: "environments without a namespace or with a duplicate one",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Environments = []appsv1.ApplicationEnvironment{
+					{Name: "dev"},
+					{Name: "staging", Namespace: "team-a"},
+					{Name: "prod", Namespace: "team-a", Replicas: &negative},
+				}
+			},
+			want: []string{
+				"spec.environments[0].namespace",
+				"spec.environments[2].namespace",
+				"spec.environments[2].replicas",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newValidApplication()
+			tt.mutate(app)
+			warnings, errs := validateSpec(app)
+			if got := fieldPaths(errs); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("validateSpec() errors = %v, want %v", errs, tt.want)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("validateSpec() warnings = %v, want %d", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestValidateSpecUpdate(t *testing.T) {
+	ten, four, six := int32(10), int32(4), int32(6)
+	tests := []struct {
+		name         string
+		allow        bool
+		mutate       func(app *appsv1.Application)
+		wantErrs     []string
+		wantWarnings int
+	}{
+		{
+			name:   "unchanged spec",
+			mutate: func(app *appsv1.Application) {},
+		},
+		{
+			name:     "changing the selector",
+			mutate:   func(app *appsv1.Application) { app.Spec.Deployment.Selector.MatchLabels["tier"] = "web" },
+			wantErrs: []string{"spec.deployment.selector"},
+		},
+		{
+			name:         "changing the selector with the annotation",
+			allow:        true,
+			mutate:       func(app *appsv1.Application) { app.Spec.Deployment.Selector.MatchLabels["tier"] = "web" },
+			wantWarnings: 1,
+		},
+		{
+			name:     "assigning a new cluster IP",
+			mutate:   func(app *appsv1.Application) { app.Spec.Service.ClusterIP = "10.0.0.2" },
+			wantErrs: []string{"spec.service.clusterIP"},
+		},
+		{
+			name:   "clearing the cluster IP",
+			mutate: func(app *appsv1.Application) { app.Spec.Service.ClusterIP = "" },
+		},
+		{
+			name:         "changing the service type",
+			mutate:       func(app *appsv1.Application) { app.Spec.Service.Type = corev1.ServiceTypeNodePort },
+			wantWarnings: 1,
+		},
+		{
+			name:   "making the default service type explicit",
+			mutate: func(app *appsv1.Application) { app.Spec.Service.Type = corev1.ServiceTypeClusterIP },
+		},
+		{
+			name: "dropping more than half of the replicas",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Replicas = &four
+				app.Spec.Environments[0].Replicas = &four
+			},
+			wantWarnings: 2,
+		},
+		{
+			name: "dropping half of the replicas",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Deployment.Replicas = &six
+				app.Spec.Environments[0].Replicas = &six
+			},
+		},
+		{
+			name: "a new environment has no previous replicas",
+			mutate: func(app *appsv1.Application) {
+				app.Spec.Environments = append(app.Spec.Environments, appsv1.ApplicationEnvironment{Name: "dev", Namespace: "team-a-dev", Replicas: &four})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newValidApplication()
+			old.Spec.Deployment.Replicas = &ten
+			old.Spec.Service.ClusterIP = "10.0.0.1"
+			old.Spec.Environments = []appsv1.ApplicationEnvironment{{Name: "prod", Namespace: "team-a-prod", Replicas: &ten}}
+			app := old.DeepCopy()
+			if tt.allow {
+				app.Annotations = map[string]string{appsv1.AllowImmutableChangesAnnotation: "true"}
+			}
+			tt.mutate(app)
+
+			warnings, errs := validateSpecUpdate(old, app)
+			if got := fieldPaths(errs); !reflect.DeepEqual(got, tt.wantErrs) {
+				t.Errorf("validateSpecUpdate() errors = %v, want %v", got, tt.wantErrs)
+			}
+			if len(warnings) != tt.wantWarnings {
+				t.Errorf("validateSpecUpdate() warnings = %v, want %d warnings", warnings, tt.wantWarnings)
+			}
+		})
+	}
+}